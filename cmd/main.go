@@ -1,15 +1,45 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
 	"github.com/andrsj/go-rabbit-image/internal/app"
+	"github.com/andrsj/go-rabbit-image/internal/config"
 	"github.com/andrsj/go-rabbit-image/pkg/logger"
 )
 
 func main() {
-	var log logger.Logger = logger.NewLogrusLogger("debug")
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+
+	if err != nil {
+		logger.NewLogrusLogger("info").Named("main").Fatal("Error loading configuration", logger.M{
+			"error": err,
+		})
+	}
+
+	if cfg.PrintConfig {
+		dump, err := cfg.Dump()
+		if err != nil {
+			logger.NewLogrusLogger("info").Named("main").Fatal("Error printing configuration", logger.M{
+				"error": err,
+			})
+		}
+
+		fmt.Print(dump)
+
+		return
+	}
+
+	var log logger.Logger = logger.NewLogrusLogger(cfg.Log.Level)
 	log = log.Named("main")
 
-	app, err := app.New(log)
+	app, err := app.New(cfg, log)
 	if err != nil {
 		log.Fatal("Error creating App object", logger.M{
 			"error": err,
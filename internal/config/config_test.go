@@ -0,0 +1,73 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		// problems are the parts of the error message, none if the config is valid
+		problems []string
+	}{
+		{"defaults", func(c *Config) {}, nil},
+		{"log level", func(c *Config) { c.Log.Level = "trace" }, []string{"log.level: unknown level 'trace'"}},
+		{"address", func(c *Config) { c.HTTP.Address = "8080" }, []string{"http.address:"}},
+		{"trusted proxies", func(c *Config) { c.HTTP.TrustedProxies = []string{"10.0.0.0/8", "::1", "proxy"} }, []string{"'proxy' is neither an IP nor a CIDR"}},
+		{"broker driver", func(c *Config) { c.Broker.Driver = "kafka" }, []string{"broker.driver: unknown driver 'kafka'"}},
+		{"rabbitmq url", func(c *Config) { c.RabbitMQ.URL = "http://localhost" }, []string{"rabbitmq.url: scheme must be amqp or amqps"}},
+		{"reconnect delays", func(c *Config) { c.RabbitMQ.ReconnectMaxDelay = Duration{time.Millisecond} }, []string{"rabbitmq.reconnect_min_delay"}},
+		{"s3 without bucket", func(c *Config) { c.Storage.Driver = "s3"; c.Storage.S3.PartSizeMB = 1 }, []string{"storage.s3.endpoint, storage.s3.bucket", "storage.s3.part_size_mb: must be at least 5"}},
+		{"auth without credentials", func(c *Config) { c.Auth.Enabled = true }, []string{"auth: api_keys or jwks_file must be set"}},
+		{"api key", func(c *Config) {
+			c.Auth.Enabled = true
+			c.Auth.APIKeys = []APIKey{{Hash: "abc", Scopes: []string{"admin"}}}
+		}, []string{"auth.api_keys[0].name", "auth.api_keys[0].hash: must be a hex SHA-256", "unknown scope 'admin'"}},
+		{"signing keys", func(c *Config) {
+			c.Signing.Keys = []SigningKey{{ID: "k1", Secret: "short"}, {ID: "k1", Secret: strings.Repeat("s", 32)}}
+			c.Signing.ActiveKey = "k2"
+		}, []string{"signing.keys[0].secret", "signing.keys[1].id", "signing.active_key: no key 'k2'"}},
+		{"signing ttl", func(c *Config) { c.Signing.MaxTTL = Duration{time.Minute} }, []string{"signing.default_ttl, signing.max_ttl"}},
+		{"rate without burst", func(c *Config) { c.RateLimit.Upload.Burst = 0 }, []string{"rate_limit.upload.burst: must be positive"}},
+		{"disabled rate", func(c *Config) { c.RateLimit.Download = Rate{} }, nil},
+		{"negative quota", func(c *Config) { c.Quota.MaxImages = -1 }, []string{"quota.max_storage_mb, quota.max_images"}},
+		{"metadata policy", func(c *Config) { c.Metadata.Policy = "blur" }, []string{"metadata.policy: unknown policy 'blur'"}},
+		{"sample percent", func(c *Config) { c.Tracing.SamplePercent = 101 }, []string{"tracing.sample_percent"}},
+		{"variants", func(c *Config) { c.Variants = append(c.Variants, c.Variants[0]) }, []string{"variants:"}},
+		// All problems are reported at once
+		{"several problems", func(c *Config) {
+			c.Worker.Concurrency = 0
+			c.Database.Path = ""
+		}, []string{"worker.concurrency: must be positive", "database.path: must not be empty"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := Default()
+			test.change(cfg)
+
+			err := cfg.Validate()
+			if len(test.problems) == 0 {
+				if err != nil {
+					t.Fatalf("validate: %v", err)
+				}
+
+				return
+			}
+
+			if !errors.Is(err, errInvalidConfig) {
+				t.Fatalf("got %v, want %v", err, errInvalidConfig)
+			}
+
+			for _, problem := range test.problems {
+				if !strings.Contains(err.Error(), problem) {
+					t.Fatalf("got error %q, want %q in it", err, problem)
+				}
+			}
+		})
+	}
+}
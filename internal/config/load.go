@@ -0,0 +1,290 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of every environment variable read by Load.
+const EnvPrefix = "GRI_"
+
+var errUnknownFormat = errors.New("unknown config file format")
+
+// Duration is a time.Duration that can be read from "5s"-like strings in every layer.
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText parses the duration from text.
+func (d *Duration) UnmarshalText(text []byte) error {
+	duration, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("parse duration: %w", err)
+	}
+
+	d.Duration = duration
+
+	return nil
+}
+
+// MarshalText returns the duration as text.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
+
+// binding connects a dotted key of the config file to a field of Config.
+// The same key is used for the flag name and (upper-cased) for the environment variable.
+type binding struct {
+	key   string
+	usage string
+	set   func(value string) error
+}
+
+// bindings returns the list of settings that can be overridden by environment and flags.
+func (c *Config) bindings() []binding {
+	return []binding{
+		{"log.level", "log level: debug, info, warn or error", stringVar(&c.Log.Level)},
+		{"http.address", "address of the HTTP server", stringVar(&c.HTTP.Address)},
+		{"http.read_header_timeout", "read header timeout of the HTTP server", durationVar(&c.HTTP.ReadHeaderTimeout)},
+		{"http.shutdown_timeout", "graceful shutdown timeout of the HTTP server", durationVar(&c.HTTP.ShutdownTimeout)},
+		{"http.cache_control", "Cache-Control header of the images, empty to disable", stringVar(&c.HTTP.CacheControl)},
+		{"http.trusted_proxies", "comma-separated IPs or CIDRs of the proxies that may set the client IP", listVar(&c.HTTP.TrustedProxies)},
+		{"upload.max_size_mb", "maximum size of the uploaded image in MB", intVar(&c.Upload.MaxSizeMB)},
+		{"upload.max_width", "maximum width of the uploaded image", intVar(&c.Upload.MaxWidth)},
+		{"upload.max_height", "maximum height of the uploaded image", intVar(&c.Upload.MaxHeight)},
+		{"upload.max_pixels", "maximum width × height of the uploaded image", intVar(&c.Upload.MaxPixels)},
+		{"auth.enabled", "authenticate the requests by API keys and JWT bearer tokens", boolVar(&c.Auth.Enabled)},
+		{"auth.jwks_file", "local JWKS file that verifies the JWT bearer tokens", stringVar(&c.Auth.JWKSFile)},
+		{"auth.issuer", "expected issuer of the JWT bearer tokens", stringVar(&c.Auth.Issuer)},
+		{"auth.audience", "expected audience of the JWT bearer tokens", stringVar(&c.Auth.Audience)},
+		{"auth.owner_only", "restrict the clients to the images uploaded by them", boolVar(&c.Auth.OwnerOnly)},
+		{"signing.active_key", "ID of the key that signs the new URLs", stringVar(&c.Signing.ActiveKey)},
+		{"signing.default_ttl", "lifetime of the signed URLs", durationVar(&c.Signing.DefaultTTL)},
+		{"signing.max_ttl", "maximum lifetime of the signed URLs", durationVar(&c.Signing.MaxTTL)},
+		{"rate_limit.upload.per_minute", "uploads and deletions per minute of a client, 0 disables the limit", intVar(&c.RateLimit.Upload.PerMinute)},
+		{"rate_limit.upload.burst", "uploads and deletions of a client at once", intVar(&c.RateLimit.Upload.Burst)},
+		{"rate_limit.download.per_minute", "other requests per minute of a client, 0 disables the limit", intVar(&c.RateLimit.Download.PerMinute)},
+		{"rate_limit.download.burst", "other requests of a client at once", intVar(&c.RateLimit.Download.Burst)},
+		{"quota.max_storage_mb", "storage of the images of an uploader in MB, 0 is unlimited", intVar(&c.Quota.MaxStorageMB)},
+		{"quota.max_images", "number of the images of an uploader, 0 is unlimited", intVar(&c.Quota.MaxImages)},
+		{"broker.driver", "message broker: rabbitmq or memory", stringVar(&c.Broker.Driver)},
+		{"broker.memory_capacity", "capacity of the in-memory queue", intVar(&c.Broker.MemoryCapacity)},
+		{"broker.max_retries", "retries of a failed image before the dead-letter queue", intVar(&c.Broker.MaxRetries)},
+		{"broker.retry_delay", "delay before a failed image is delivered again", durationVar(&c.Broker.RetryDelay)},
+		{"rabbitmq.url", "URL of the RabbitMQ instance", stringVar(&c.RabbitMQ.URL)},
+		{"rabbitmq.queue", "name of the main queue", stringVar(&c.RabbitMQ.Queue)},
+		{"rabbitmq.reconnect_min_delay", "first delay of the reconnection backoff", durationVar(&c.RabbitMQ.ReconnectMinDelay)},
+		{"rabbitmq.reconnect_max_delay", "maximum delay of the reconnection backoff", durationVar(&c.RabbitMQ.ReconnectMaxDelay)},
+		{"rabbitmq.publish_mode", "publishing while reconnecting: wait or fail", stringVar(&c.RabbitMQ.PublishMode)},
+		{"rabbitmq.publish_timeout", "maximum wait for the reconnection on publishing", durationVar(&c.RabbitMQ.PublishTimeout)},
+		{"storage.driver", "file storage: local or s3", stringVar(&c.Storage.Driver)},
+		{"storage.path", "directory of the stored images (of the cache with the s3 driver)", stringVar(&c.Storage.Path)},
+		{"storage.s3.endpoint", "host[:port] of the S3-compatible storage", stringVar(&c.Storage.S3.Endpoint)},
+		{"storage.s3.region", "region of the S3 bucket", stringVar(&c.Storage.S3.Region)},
+		{"storage.s3.bucket", "S3 bucket of the images", stringVar(&c.Storage.S3.Bucket)},
+		{"storage.s3.prefix", "prefix of the S3 object keys", stringVar(&c.Storage.S3.Prefix)},
+		{"storage.s3.access_key", "S3 access key", stringVar(&c.Storage.S3.AccessKey)},
+		{"storage.s3.secret_key", "S3 secret key", stringVar(&c.Storage.S3.SecretKey)},
+		{"storage.s3.use_ssl", "connect to S3 over HTTPS", boolVar(&c.Storage.S3.UseSSL)},
+		{"storage.s3.part_size_mb", "part size of the S3 multipart upload in MB", intVar(&c.Storage.S3.PartSizeMB)},
+		{"storage.s3.timeout", "timeout of the S3 requests", durationVar(&c.Storage.S3.Timeout)},
+		{"storage.s3.create_bucket", "create the S3 bucket if it doesn't exist", boolVar(&c.Storage.S3.CreateBucket)},
+		{"database.path", "file of the SQLite database with the jobs and the image catalog", stringVar(&c.Database.Path)},
+		{"worker.concurrency", "number of images processed at the same time", intVar(&c.Worker.Concurrency)},
+		{"worker.encoders", "number of resize/encode tasks at the same time", intVar(&c.Worker.Encoders)},
+		{"worker.drain_timeout", "time to finish the running jobs on shutdown", durationVar(&c.Worker.DrainTimeout)},
+		{"worker.max_animation_pixels", "limit of frames × width × height of the animated variants", intVar(&c.Worker.MaxAnimationPixels)},
+		{"transform.max_width", "maximum width of the transformed images", intVar(&c.Transform.MaxWidth)},
+		{"transform.max_height", "maximum height of the transformed images", intVar(&c.Transform.MaxHeight)},
+		{"transform.concurrency", "number of transformations at the same time", intVar(&c.Transform.Concurrency)},
+		{"transform.cache_max_mb", "size of the cache of the transformed images in MB", intVar(&c.Transform.CacheMaxMB)},
+		{"metadata.policy", "EXIF metadata of the stored images: strip, keep or whitelist", stringVar(&c.Metadata.Policy)},
+		{"metadata.whitelist", "comma-separated EXIF tags kept by the whitelist policy", listVar(&c.Metadata.Whitelist)},
+		{"tracing.exporter", "exporter of the OpenTelemetry spans: none, stdout or otlp", stringVar(&c.Tracing.Exporter)},
+		{"tracing.endpoint", "host:port of the OTLP gRPC collector", stringVar(&c.Tracing.Endpoint)},
+		{"tracing.insecure", "connect to the OTLP collector without TLS", boolVar(&c.Tracing.Insecure)},
+		{"tracing.sample_percent", "percent of the new traces that are recorded", intVar(&c.Tracing.SamplePercent)},
+		{"tracing.service_name", "name of the service in the traces", stringVar(&c.Tracing.ServiceName)},
+		{"health.timeout", "timeout of every readiness check", durationVar(&c.Health.Timeout)},
+		{"health.drain_delay", "time between failing the readiness and stopping the server", durationVar(&c.Health.DrainDelay)},
+	}
+}
+
+func stringVar(p *string) func(string) error {
+	return func(value string) error {
+		*p = value
+
+		return nil
+	}
+}
+
+func durationVar(p *Duration) func(string) error {
+	return func(value string) error {
+		return p.UnmarshalText([]byte(value))
+	}
+}
+
+func intVar(p *int) func(string) error {
+	return func(value string) error {
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("parse int: %w", err)
+		}
+
+		*p = number
+
+		return nil
+	}
+}
+
+func listVar(p *[]string) func(string) error {
+	return func(value string) error {
+		*p = nil
+
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*p = append(*p, item)
+			}
+		}
+
+		return nil
+	}
+}
+
+func boolVar(p *bool) func(string) error {
+	return func(value string) error {
+		flag, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("parse bool: %w", err)
+		}
+
+		*p = flag
+
+		return nil
+	}
+}
+
+// envName returns the environment variable name of the key, e.g. http.address -> GRI_HTTP_ADDRESS.
+func envName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+// flagValue keeps the raw flag value until the lower layers are applied.
+type flagValue struct {
+	key       string
+	overrides map[string]string
+}
+
+func (f *flagValue) String() string { return "" }
+
+func (f *flagValue) Set(value string) error {
+	f.overrides[f.key] = value
+
+	return nil
+}
+
+/*
+Load builds the configuration from all layers and validates it.
+
+The order is: defaults, the file from --config (or GRI_CONFIG),
+environment variables (GRI_<SECTION>_<KEY>) and command-line flags (--<section>.<key>).
+*/
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+	bindings := cfg.bindings()
+
+	flags := flag.NewFlagSet("go-rabbit-image", flag.ContinueOnError)
+	configPath := flags.String("config", os.Getenv(EnvPrefix+"CONFIG"), "path to a YAML or TOML config file")
+	flags.BoolVar(&cfg.PrintConfig, "print-config", false, "print the resulting config with redacted secrets and exit")
+
+	overrides := make(map[string]string)
+	for _, b := range bindings {
+		flags.Var(&flagValue{key: b.key, overrides: overrides}, b.key, fmt.Sprintf("%s (env %s)", b.usage, envName(b.key)))
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("parse flags: %w", err)
+	}
+
+	// Config file
+	if *configPath != "" {
+		if err := cfg.readFile(*configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	// Environment variables
+	for _, b := range bindings {
+		if value, ok := os.LookupEnv(envName(b.key)); ok {
+			if err := b.set(value); err != nil {
+				return nil, fmt.Errorf("env %s: %w", envName(b.key), err)
+			}
+		}
+	}
+
+	// Command-line flags
+	for _, b := range bindings {
+		if value, ok := overrides[b.key]; ok {
+			if err := b.set(value); err != nil {
+				return nil, fmt.Errorf("flag --%s: %w", b.key, err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// readFile decodes the YAML or TOML file over the current values.
+func (c *Config) readFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open config file: %w", err)
+	}
+	defer file.Close()
+
+	// A list in the file replaces the default one instead of being merged into it
+	defaultVariants, defaultWhitelist := c.Variants, c.Metadata.Whitelist
+	c.Variants, c.Metadata.Whitelist = nil, nil
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(file)
+		decoder.KnownFields(true)
+		err = decoder.Decode(c)
+	case ".toml":
+		decoder := toml.NewDecoder(file)
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(c)
+	default:
+		return fmt.Errorf("%w: '%s'", errUnknownFormat, ext)
+	}
+
+	// An empty file keeps the defaults
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("decode config file '%s': %w", path, err)
+	}
+
+	if c.Variants == nil {
+		c.Variants = defaultVariants
+	}
+
+	if c.Metadata.Whitelist == nil {
+		c.Metadata.Whitelist = defaultWhitelist
+	}
+
+	return nil
+}
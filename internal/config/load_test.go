@@ -0,0 +1,182 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile writes the config file with the name into a temporary directory and returns its path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	return path
+}
+
+const testYAML = `
+log:
+  level: warn
+http:
+  address: ":9000"
+  shutdown_timeout: 1m
+worker:
+  concurrency: 4
+metadata:
+  whitelist: [Orientation]
+variants:
+  - name: thumb
+    max_width: 100
+    max_height: 100
+`
+
+func TestLoadLayers(t *testing.T) {
+	path := writeFile(t, "config.yaml", testYAML)
+
+	tests := []struct {
+		name  string
+		env   map[string]string
+		args  []string
+		check func(t *testing.T, cfg *Config)
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.HTTP.Address != ":8080" || cfg.Log.Level != "debug" || len(cfg.Variants) != 3 {
+					t.Fatalf("got %s, %s, %d variants, want the defaults", cfg.HTTP.Address, cfg.Log.Level, len(cfg.Variants))
+				}
+			},
+		},
+		{
+			name: "file over defaults",
+			args: []string{"--config", path},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.HTTP.Address != ":9000" || cfg.Log.Level != "warn" || cfg.HTTP.ShutdownTimeout.Duration != time.Minute {
+					t.Fatalf("got %s, %s, %s, want the values of the file", cfg.HTTP.Address, cfg.Log.Level, cfg.HTTP.ShutdownTimeout)
+				}
+
+				// The values missing in the file keep the defaults
+				if cfg.HTTP.ReadHeaderTimeout.Duration != time.Second || cfg.Broker.Driver != "rabbitmq" {
+					t.Fatalf("got %s, %s, want the defaults", cfg.HTTP.ReadHeaderTimeout, cfg.Broker.Driver)
+				}
+
+				// The lists of the file replace the default ones
+				if len(cfg.Variants) != 1 || cfg.Variants[0].Name != "thumb" {
+					t.Fatalf("got variants %v, want only thumb", cfg.Variants)
+				}
+
+				if strings.Join(cfg.Metadata.Whitelist, ",") != "Orientation" {
+					t.Fatalf("got whitelist %v, want [Orientation]", cfg.Metadata.Whitelist)
+				}
+			},
+		},
+		{
+			name: "file from env",
+			env:  map[string]string{EnvPrefix + "CONFIG": path},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.HTTP.Address != ":9000" {
+					t.Fatalf("got address %s, want the one of the file", cfg.HTTP.Address)
+				}
+			},
+		},
+		{
+			name: "env over file",
+			env:  map[string]string{"GRI_LOG_LEVEL": "error", "GRI_METADATA_WHITELIST": "Make, Model"},
+			args: []string{"--config", path},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Log.Level != "error" || cfg.HTTP.Address != ":9000" {
+					t.Fatalf("got %s, %s, want the level of the env and the address of the file", cfg.Log.Level, cfg.HTTP.Address)
+				}
+
+				if strings.Join(cfg.Metadata.Whitelist, ",") != "Make,Model" {
+					t.Fatalf("got whitelist %v, want [Make Model]", cfg.Metadata.Whitelist)
+				}
+			},
+		},
+		{
+			name: "flags over env",
+			env:  map[string]string{"GRI_LOG_LEVEL": "error", "GRI_WORKER_CONCURRENCY": "8"},
+			args: []string{"--config", path, "--log.level", "info", "--http.shutdown_timeout=2s"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Log.Level != "info" || cfg.HTTP.ShutdownTimeout.Duration != 2*time.Second {
+					t.Fatalf("got %s, %s, want the values of the flags", cfg.Log.Level, cfg.HTTP.ShutdownTimeout)
+				}
+
+				if cfg.Worker.Concurrency != 8 {
+					t.Fatalf("got concurrency %d, want the one of the env", cfg.Worker.Concurrency)
+				}
+			},
+		},
+		{
+			name: "toml",
+			args: []string{"--config", writeFile(t, "config.toml", "[http]\naddress = \":9100\"\n")},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.HTTP.Address != ":9100" {
+					t.Fatalf("got address %s, want the one of the file", cfg.HTTP.Address)
+				}
+			},
+		},
+		{
+			name: "empty file",
+			args: []string{"--config", writeFile(t, "config.yaml", "")},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.HTTP.Address != ":8080" || len(cfg.Variants) != 3 {
+					t.Fatalf("got %s, %d variants, want the defaults", cfg.HTTP.Address, len(cfg.Variants))
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+
+			cfg, err := Load(test.args)
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+
+			test.check(t, cfg)
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		// err is the part of the error message
+		err string
+	}{
+		{"unknown key in the file", nil, []string{"--config", writeFile(t, "config.yaml", "http:\n  adress: \":1\"\n")}, "field adress not found"},
+		{"unknown key in the toml file", nil, []string{"--config", writeFile(t, "config.toml", "[http]\nadress = \":1\"\n")}, "decode config file"},
+		{"unknown format", nil, []string{"--config", writeFile(t, "config.json", "{}")}, "unknown config file format"},
+		{"missing file", nil, []string{"--config", filepath.Join(t.TempDir(), "missing.yaml")}, "open config file"},
+		{"bad env value", map[string]string{"GRI_WORKER_CONCURRENCY": "many"}, nil, "env GRI_WORKER_CONCURRENCY"},
+		{"bad flag value", nil, []string{"--health.timeout", "soon"}, "flag --health.timeout"},
+		{"unknown flag", nil, []string{"--http.adress", ":1"}, "parse flags"},
+		{"invalid value", nil, []string{"--log.level", "loud"}, "log.level: unknown level 'loud'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+
+			_, err := Load(test.args)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("got error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
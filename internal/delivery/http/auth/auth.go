@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The scopes of the endpoints.
+const (
+	// ScopeUpload allows to upload and delete the images.
+	ScopeUpload = "upload"
+	// ScopeRead allows to read the images, their state and the catalog.
+	ScopeRead = "read"
+)
+
+// Scopes are the known scopes.
+var Scopes = []string{ScopeUpload, ScopeRead}
+
+var (
+	// ErrUnauthenticated is returned if the request has no valid credentials.
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrForbidden is returned if the principal has no scope for the endpoint.
+	ErrForbidden = errors.New("forbidden")
+)
+
+// principalKey is the key of the principal in the context of the request.
+const principalKey = "auth.principal"
+
+// Principal is the authenticated client of the request.
+type Principal struct {
+	// ID identifies the client: "key:<name>" for the API keys, "jwt:<sub>" for the tokens.
+	ID     string
+	Scopes []string
+	// OwnerOnly restricts the principal to the images uploaded by it.
+	OwnerOnly bool
+}
+
+// HasScope reports whether the principal has the scope.
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WithPrincipal stores the principal in the context of the request.
+func WithPrincipal(ctx *gin.Context, principal *Principal) {
+	ctx.Set(principalKey, principal)
+}
+
+// FromContext returns the principal of the request, it's false if the authentication is disabled.
+func FromContext(ctx *gin.Context) (*Principal, bool) {
+	value, ok := ctx.Get(principalKey)
+	if !ok {
+		return nil, false
+	}
+
+	principal, ok := value.(*Principal)
+
+	return principal, ok
+}
@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+const (
+	// HeaderAPIKey is the header of the API key.
+	HeaderAPIKey = "X-API-Key"
+	bearerPrefix = "Bearer "
+	// keyPrincipal and tokenPrincipal are the prefixes of the principals of the API keys and the tokens,
+	// so the subject of the token can't pass for the API key.
+	keyPrincipal   = "key:"
+	tokenPrincipal = "jwt:"
+	// jwtClockSkew is the leeway of the time claims of the tokens.
+	jwtClockSkew = 30 * time.Second
+)
+
+// Authenticator finds the principal of the request by its credentials.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// APIKey is the static API key, only the SHA-256 of the key is stored.
+type APIKey struct {
+	Name string
+	// Hash is the hex SHA-256 of the key.
+	Hash   string
+	Scopes []string
+}
+
+// Config holds the credentials that are accepted.
+type Config struct {
+	APIKeys []APIKey
+	// JWKSFile is the local JSON Web Key Set that verifies the JWT bearer tokens, empty disables them.
+	JWKSFile string
+	// Issuer and Audience of the tokens are checked if they are set.
+	Issuer   string
+	Audience string
+	// OwnerOnly restricts all principals to their own images.
+	OwnerOnly bool
+}
+
+// authenticator checks the API keys and the JWT bearer tokens.
+type authenticator struct {
+	// keys are the API keys by their hashes
+	keys      map[string]APIKey
+	jwks      *jose.JSONWebKeySet
+	expected  jwt.Expected
+	ownerOnly bool
+	logger    logger.Logger
+}
+
+var _ Authenticator = (*authenticator)(nil)
+
+// New creates the authenticator and reads the JWKS file.
+func New(cfg Config, log logger.Logger) (*authenticator, error) {
+	log = log.Named("Authenticator")
+
+	a := &authenticator{
+		keys:      make(map[string]APIKey, len(cfg.APIKeys)),
+		expected:  jwt.Expected{Issuer: cfg.Issuer},
+		ownerOnly: cfg.OwnerOnly,
+		logger:    log,
+	}
+
+	if cfg.Audience != "" {
+		a.expected.Audience = jwt.Audience{cfg.Audience}
+	}
+
+	for _, key := range cfg.APIKeys {
+		a.keys[strings.ToLower(key.Hash)] = key
+	}
+
+	if cfg.JWKSFile != "" {
+		data, err := os.ReadFile(cfg.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("read JWKS '%s': %w", cfg.JWKSFile, err)
+		}
+
+		a.jwks = new(jose.JSONWebKeySet)
+		if err := json.Unmarshal(data, a.jwks); err != nil {
+			return nil, fmt.Errorf("parse JWKS '%s': %w", cfg.JWKSFile, err)
+		}
+
+		log.Info("JWKS loaded", logger.M{"path": cfg.JWKSFile, "keys": len(a.jwks.Keys)})
+	}
+
+	log.Info("Authentication is enabled", logger.M{"api_keys": len(a.keys), "owner_only": cfg.OwnerOnly})
+
+	return a, nil
+}
+
+/*
+Authenticate returns the principal of the API key (X-API-Key header)
+or of the JWT bearer token (Authorization header).
+
+The scopes of the token are the space-separated "scope" claim or the list of them.
+*/
+func (a *authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if key := r.Header.Get(HeaderAPIKey); key != "" {
+		return a.authenticateKey(key)
+	}
+
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, bearerPrefix) {
+		return a.authenticateToken(strings.TrimPrefix(header, bearerPrefix))
+	}
+
+	return nil, fmt.Errorf("%w: no credentials", ErrUnauthenticated)
+}
+
+// authenticateKey finds the API key by its hash, so the keys themselves are never stored.
+func (a *authenticator) authenticateKey(key string) (*Principal, error) {
+	sum := sha256.Sum256([]byte(key))
+
+	apiKey, ok := a.keys[hex.EncodeToString(sum[:])]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown API key", ErrUnauthenticated)
+	}
+
+	return &Principal{
+		ID:        keyPrincipal + apiKey.Name,
+		Scopes:    apiKey.Scopes,
+		OwnerOnly: a.ownerOnly,
+	}, nil
+}
+
+// tokenClaims are the claims of the token besides the registered ones.
+type tokenClaims struct {
+	Scope interface{} `json:"scope"`
+}
+
+// authenticateToken verifies the signature of the token by the key of the JWKS and checks its claims.
+func (a *authenticator) authenticateToken(raw string) (*Principal, error) {
+	if a.jwks == nil {
+		return nil, fmt.Errorf("%w: bearer tokens are disabled", ErrUnauthenticated)
+	}
+
+	token, err := jwt.ParseSigned(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnauthenticated, err)
+	}
+
+	key, err := a.signingKey(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		claims jwt.Claims
+		extra  tokenClaims
+	)
+
+	if err := token.Claims(key.Public(), &claims, &extra); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnauthenticated, err)
+	}
+
+	// The tokens without expiration are never accepted
+	if claims.Expiry == nil || claims.Subject == "" {
+		return nil, fmt.Errorf("%w: the token has no exp or sub claim", ErrUnauthenticated)
+	}
+
+	if err := claims.ValidateWithLeeway(a.expected.WithTime(time.Now()), jwtClockSkew); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnauthenticated, err)
+	}
+
+	return &Principal{
+		ID:        tokenPrincipal + claims.Subject,
+		Scopes:    scopesOf(extra.Scope),
+		OwnerOnly: a.ownerOnly,
+	}, nil
+}
+
+// signingKey returns the key of the JWKS by the key ID of the token,
+// the token without the key ID is verified by the only key of the set.
+func (a *authenticator) signingKey(token *jwt.JSONWebToken) (*jose.JSONWebKey, error) {
+	if len(token.Headers) != 1 {
+		return nil, fmt.Errorf("%w: the token must have one signature", ErrUnauthenticated)
+	}
+
+	header := token.Headers[0]
+
+	keys := a.jwks.Keys
+	if header.KeyID != "" {
+		keys = a.jwks.Key(header.KeyID)
+	}
+
+	if len(keys) != 1 {
+		return nil, fmt.Errorf("%w: no key '%s' in the JWKS", ErrUnauthenticated, header.KeyID)
+	}
+
+	// The key may be bound to the algorithm
+	if keys[0].Algorithm != "" && keys[0].Algorithm != header.Algorithm {
+		return nil, fmt.Errorf("%w: algorithm %s doesn't match the key", ErrUnauthenticated, header.Algorithm)
+	}
+
+	return &keys[0], nil
+}
+
+// scopesOf returns the scopes of the "scope" claim: a space-separated string or a list.
+func scopesOf(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		scopes := make([]string, 0, len(value))
+
+		for _, item := range value {
+			if scope, ok := item.(string); ok {
+				scopes = append(scopes, scope)
+			}
+		}
+
+		return scopes
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+// testKeys are the signing keys of the tests by their key IDs, the JWKS holds their public halves.
+type testKeys map[string]*ecdsa.PrivateKey
+
+func newTestKeys(t *testing.T, ids ...string) testKeys {
+	t.Helper()
+
+	keys := make(testKeys, len(ids))
+
+	for _, id := range ids {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+
+		keys[id] = key
+	}
+
+	return keys
+}
+
+// jwksFile writes the public keys to the JWKS file and returns its path.
+func (k testKeys) jwksFile(t *testing.T) string {
+	t.Helper()
+
+	var set jose.JSONWebKeySet
+	for id, key := range k {
+		set.Keys = append(set.Keys, jose.JSONWebKey{Key: key.Public(), KeyID: id, Algorithm: string(jose.ES256)})
+	}
+
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatalf("marshal JWKS: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write JWKS: %v", err)
+	}
+
+	return path
+}
+
+// signToken returns the token with the claims signed by the key, the key ID is left out if kid is empty.
+func signToken(t *testing.T, key *ecdsa.PrivateKey, kid string, claims jwt.Claims, scope interface{}) string {
+	t.Helper()
+
+	signingKey := jose.SigningKey{Algorithm: jose.ES256, Key: key}
+	if kid != "" {
+		signingKey.Key = jose.JSONWebKey{Key: key, KeyID: kid}
+	}
+
+	signer, err := jose.NewSigner(signingKey, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		t.Fatalf("signer: %v", err)
+	}
+
+	raw, err := jwt.Signed(signer).Claims(claims).Claims(map[string]interface{}{"scope": scope}).CompactSerialize()
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	return raw
+}
+
+func hashOf(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	a, err := New(Config{APIKeys: []APIKey{
+		{Name: "uploader", Hash: hashOf("secret"), Scopes: []string{ScopeUpload}},
+		// The hash may be written in upper case
+		{Name: "reader", Hash: strings.ToUpper(hashOf("data")), Scopes: []string{ScopeRead}},
+	}}, logger.NewLogrusLogger("error"))
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+
+	tests := []struct {
+		key string
+		// principal is the ID of the principal, empty if the key is rejected
+		principal string
+	}{
+		{"secret", "key:uploader"},
+		{"data", "key:reader"},
+		{"unknown", ""},
+		// The hash itself isn't the key
+		{hashOf("secret"), ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(HeaderAPIKey, test.key)
+
+			principal, err := a.Authenticate(r)
+			if test.principal == "" {
+				if !errors.Is(err, ErrUnauthenticated) {
+					t.Fatalf("got %v, want %v", err, ErrUnauthenticated)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("authenticate: %v", err)
+			}
+
+			if principal.ID != test.principal {
+				t.Fatalf("got principal %q, want %q", principal.ID, test.principal)
+			}
+		})
+	}
+}
+
+func TestAuthenticateToken(t *testing.T) {
+	keys := newTestKeys(t, "k1", "k2")
+	// The key outside of the JWKS
+	foreign := newTestKeys(t, "k1")["k1"]
+
+	a, err := New(Config{
+		JWKSFile: keys.jwksFile(t),
+		Issuer:   "https://issuer",
+		Audience: "images",
+	}, logger.NewLogrusLogger("error"))
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+
+	now := time.Now()
+	valid := jwt.Claims{
+		Subject:  "alice",
+		Issuer:   "https://issuer",
+		Audience: jwt.Audience{"images"},
+		Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+	}
+
+	with := func(change func(c *jwt.Claims)) jwt.Claims {
+		c := valid
+		change(&c)
+
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		// principal is the ID of the principal, empty if the token is rejected
+		principal string
+		scopes    []string
+	}{
+		{"valid", signToken(t, keys["k1"], "k1", valid, "read upload"), "jwt:alice", []string{"read", "upload"}},
+		{"second key", signToken(t, keys["k2"], "k2", valid, []string{"read"}), "jwt:alice", []string{"read"}},
+		{"subject of the API key", signToken(t, keys["k1"], "k1", with(func(c *jwt.Claims) { c.Subject = "key:admin" }), nil), "jwt:key:admin", nil},
+		{"expired", signToken(t, keys["k1"], "k1", with(func(c *jwt.Claims) { c.Expiry = jwt.NewNumericDate(now.Add(-time.Minute)) }), "read"), "", nil},
+		{"expired within the skew", signToken(t, keys["k1"], "k1", with(func(c *jwt.Claims) { c.Expiry = jwt.NewNumericDate(now.Add(-10 * time.Second)) }), "read"), "jwt:alice", []string{"read"}},
+		{"no expiry", signToken(t, keys["k1"], "k1", with(func(c *jwt.Claims) { c.Expiry = nil }), "read"), "", nil},
+		{"no subject", signToken(t, keys["k1"], "k1", with(func(c *jwt.Claims) { c.Subject = "" }), "read"), "", nil},
+		{"other audience", signToken(t, keys["k1"], "k1", with(func(c *jwt.Claims) { c.Audience = jwt.Audience{"other"} }), "read"), "", nil},
+		{"other issuer", signToken(t, keys["k1"], "k1", with(func(c *jwt.Claims) { c.Issuer = "https://other" }), "read"), "", nil},
+		{"unknown kid", signToken(t, keys["k1"], "k3", valid, "read"), "", nil},
+		{"kid of the other key", signToken(t, keys["k1"], "k2", valid, "read"), "", nil},
+		{"foreign key", signToken(t, foreign, "k1", valid, "read"), "", nil},
+		// Without the key ID the token is verified only by the only key of the set
+		{"no kid", signToken(t, keys["k1"], "", valid, "read"), "", nil},
+		{"garbage", "not.a.token", "", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Authorization", bearerPrefix+test.token)
+
+			principal, err := a.Authenticate(r)
+			if test.principal == "" {
+				if !errors.Is(err, ErrUnauthenticated) {
+					t.Fatalf("got %v, want %v", err, ErrUnauthenticated)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("authenticate: %v", err)
+			}
+
+			if principal.ID != test.principal {
+				t.Fatalf("got principal %q, want %q", principal.ID, test.principal)
+			}
+
+			if len(principal.Scopes) != len(test.scopes) {
+				t.Fatalf("got scopes %v, want %v", principal.Scopes, test.scopes)
+			}
+
+			for i := range test.scopes {
+				if principal.Scopes[i] != test.scopes[i] {
+					t.Fatalf("got scopes %v, want %v", principal.Scopes, test.scopes)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthenticateTokenDisabled(t *testing.T) {
+	a, err := New(Config{}, logger.NewLogrusLogger("error"))
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", bearerPrefix+"token")
+
+	if _, err := a.Authenticate(r); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("got %v, want %v", err, ErrUnauthenticated)
+	}
+}
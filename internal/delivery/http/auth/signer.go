@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The query parameters of the signed URLs.
+const (
+	ParamExpires   = "expires"
+	ParamClientIP  = "ip"
+	ParamKeyID     = "kid"
+	ParamSignature = "sig"
+
+	// signatureVersion is the prefix of the signed string, it changes with its layout.
+	signatureVersion = "v1"
+)
+
+var (
+	// ErrInvalidSignature is returned for the URLs with a wrong or unknown signature.
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrExpired is returned for the expired URLs.
+	ErrExpired = errors.New("signed URL is expired")
+	// ErrNoSigningKeys is returned if the URLs can't be signed.
+	ErrNoSigningKeys = errors.New("no signing keys")
+)
+
+// Grant is what the signed URL grants: the variant of the image until the expiry,
+// only to the client IP if it's set (as it's seen by the server, see "http.trusted_proxies").
+type Grant struct {
+	ImageID  string
+	Variant  string
+	Expires  time.Time
+	ClientIP string
+}
+
+// payload returns the signed string of the grant.
+func (g Grant) payload() []byte {
+	return []byte(strings.Join([]string{
+		signatureVersion,
+		g.ImageID,
+		g.Variant,
+		strconv.FormatInt(g.Expires.Unix(), 10),
+		g.ClientIP,
+	}, "\n"))
+}
+
+// SigningKey is the HMAC key of the signed URLs.
+type SigningKey struct {
+	ID     string
+	Secret []byte
+}
+
+// URLSigner signs and verifies the URLs of the images.
+type URLSigner interface {
+	Sign(grant Grant) (url.Values, error)
+	Verify(grant Grant, keyID, signature string, now time.Time) error
+}
+
+/*
+urlSigner signs the URLs by HMAC-SHA256 with the active key.
+
+The URL carries the ID of the key, so the keys can be rotated:
+a new key becomes active and the old one stays in the list until its URLs expire.
+*/
+type urlSigner struct {
+	keys   map[string][]byte
+	active string
+}
+
+var _ URLSigner = (*urlSigner)(nil)
+
+// NewURLSigner creates the signer, the active key signs the new URLs and all keys verify them.
+func NewURLSigner(keys []SigningKey, active string) (*urlSigner, error) {
+	s := &urlSigner{
+		keys:   make(map[string][]byte, len(keys)),
+		active: active,
+	}
+
+	for _, key := range keys {
+		s.keys[key.ID] = key.Secret
+	}
+
+	if _, ok := s.keys[active]; !ok && len(keys) > 0 {
+		return nil, fmt.Errorf("the active signing key '%s' is not in the keys", active)
+	}
+
+	return s, nil
+}
+
+// Sign returns the query parameters that grant the access.
+func (s *urlSigner) Sign(grant Grant) (url.Values, error) {
+	secret, ok := s.keys[s.active]
+	if !ok {
+		return nil, ErrNoSigningKeys
+	}
+
+	values := url.Values{}
+	values.Set(ParamExpires, strconv.FormatInt(grant.Expires.Unix(), 10))
+
+	if grant.ClientIP != "" {
+		values.Set(ParamClientIP, grant.ClientIP)
+	}
+
+	values.Set(ParamKeyID, s.active)
+	values.Set(ParamSignature, sign(secret, grant))
+
+	return values, nil
+}
+
+// Verify checks the signature of the grant by the key of the URL and the expiry.
+func (s *urlSigner) Verify(grant Grant, keyID, signature string, now time.Time) error {
+	secret, ok := s.keys[keyID]
+	if !ok {
+		return fmt.Errorf("%w: unknown key '%s'", ErrInvalidSignature, keyID)
+	}
+
+	if !hmac.Equal([]byte(sign(secret, grant)), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+
+	if now.After(grant.Expires) {
+		return ErrExpired
+	}
+
+	return nil
+}
+
+// sign returns the URL-safe HMAC-SHA256 of the grant.
+func sign(secret []byte, grant Grant) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(grant.payload())
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/andrsj/go-rabbit-image/internal/delivery/http/auth"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+require returns the middleware that lets in only the principals with the scope.
+
+The request without valid credentials gets 401, the principal without the scope gets 403.
+Everything is let in if the authentication is disabled (no authenticator).
+*/
+func (h *Handler) require(scope string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if h.authenticator == nil {
+			ctx.Next()
+
+			return
+		}
+
+		principal, err := h.authenticator.Authenticate(ctx.Request)
+		if err != nil {
+			h.logger.Warn("Authentication failed", logger.M{
+				"error": err,
+				"path":  ctx.FullPath(),
+			})
+			ctx.Header("WWW-Authenticate", `Bearer realm="images"`)
+			ctx.AbortWithStatusJSON(
+				http.StatusUnauthorized,
+				gin.H{"error": fmt.Sprintf("Authentication required: %s", err)},
+			)
+
+			return
+		}
+
+		if !principal.HasScope(scope) {
+			err := fmt.Errorf("%w: no scope '%s'", auth.ErrForbidden, scope)
+
+			h.logger.Warn("Access denied", logger.M{
+				"error":     err,
+				"path":      ctx.FullPath(),
+				"principal": principal.ID,
+			})
+			ctx.AbortWithStatusJSON(
+				http.StatusForbidden,
+				gin.H{"error": fmt.Sprintf("Access denied: %s", err)},
+			)
+
+			return
+		}
+
+		auth.WithPrincipal(ctx, principal)
+		ctx.Next()
+	}
+}
+
+// requireUnlessSigned is the require middleware that lets in the signed URLs,
+// their signature is verified by the endpoint.
+func (h *Handler) requireUnlessSigned(scope string) gin.HandlerFunc {
+	require := h.require(scope)
+
+	return func(ctx *gin.Context) {
+		if _, signed := ctx.GetQuery(auth.ParamSignature); signed {
+			ctx.Next()
+
+			return
+		}
+
+		require(ctx)
+	}
+}
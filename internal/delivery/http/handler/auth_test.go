@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andrsj/go-rabbit-image/internal/delivery/http/auth"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// newTestHandler returns the handler with the API keys "reader" (read scope) and "uploader" (both scopes),
+// the key of each is its name. The nil keys disable the authentication.
+func newTestHandler(t *testing.T, keys []auth.APIKey) *Handler {
+	t.Helper()
+
+	log := logger.NewLogrusLogger("error")
+
+	var authenticator auth.Authenticator
+
+	if keys != nil {
+		a, err := auth.New(auth.Config{APIKeys: keys}, log)
+		if err != nil {
+			t.Fatalf("authenticator: %v", err)
+		}
+
+		authenticator = a
+	}
+
+	h, err := New(authenticator, RateLimits{}, nil, log)
+	if err != nil {
+		t.Fatalf("handler: %v", err)
+	}
+
+	// The endpoints respond with the principal that is let in
+	principal := func(ctx *gin.Context) {
+		if p, ok := auth.FromContext(ctx); ok {
+			ctx.String(http.StatusOK, p.ID)
+
+			return
+		}
+
+		ctx.String(http.StatusOK, "anonymous")
+	}
+
+	h.engine.GET("/read", h.require(auth.ScopeRead), principal)
+	h.engine.POST("/upload", h.require(auth.ScopeUpload), principal)
+	h.engine.GET("/img", h.requireUnlessSigned(auth.ScopeRead), principal)
+
+	return h
+}
+
+func testKeys() []auth.APIKey {
+	hash := func(key string) string {
+		sum := sha256.Sum256([]byte(key))
+
+		return hex.EncodeToString(sum[:])
+	}
+
+	return []auth.APIKey{
+		{Name: "reader", Hash: hash("reader"), Scopes: []string{auth.ScopeRead}},
+		{Name: "uploader", Hash: hash("uploader"), Scopes: []string{auth.ScopeRead, auth.ScopeUpload}},
+	}
+}
+
+func TestRequire(t *testing.T) {
+	tests := []struct {
+		name   string
+		keys   []auth.APIKey
+		method string
+		target string
+		// key is the API key of the request, empty for none
+		key    string
+		status int
+		body   string
+	}{
+		{"read with the read scope", testKeys(), http.MethodGet, "/read", "reader", http.StatusOK, "key:reader"},
+		{"upload without the upload scope", testKeys(), http.MethodPost, "/upload", "reader", http.StatusForbidden, ""},
+		{"upload with the upload scope", testKeys(), http.MethodPost, "/upload", "uploader", http.StatusOK, "key:uploader"},
+		{"no credentials", testKeys(), http.MethodGet, "/read", "", http.StatusUnauthorized, ""},
+		{"unknown key", testKeys(), http.MethodGet, "/read", "other", http.StatusUnauthorized, ""},
+		{"signed URL", testKeys(), http.MethodGet, "/img?" + auth.ParamSignature + "=x", "", http.StatusOK, "anonymous"},
+		{"unsigned URL", testKeys(), http.MethodGet, "/img", "", http.StatusUnauthorized, ""},
+		{"disabled authentication", nil, http.MethodPost, "/upload", "", http.StatusOK, "anonymous"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newTestHandler(t, test.keys)
+
+			r := httptest.NewRequest(test.method, test.target, nil)
+			if test.key != "" {
+				r.Header.Set(auth.HeaderAPIKey, test.key)
+			}
+
+			recorder := httptest.NewRecorder()
+			h.engine.ServeHTTP(recorder, r)
+
+			if recorder.Code != test.status {
+				t.Fatalf("got status %d, want %d: %s", recorder.Code, test.status, recorder.Body)
+			}
+
+			if test.status == http.StatusUnauthorized && recorder.Header().Get("WWW-Authenticate") == "" {
+				t.Fatal("no WWW-Authenticate header")
+			}
+
+			if test.body != "" && recorder.Body.String() != test.body {
+				t.Fatalf("got principal %q, want %q", recorder.Body, test.body)
+			}
+		})
+	}
+}
@@ -1,7 +1,11 @@
 package handler
 
 import (
+	"fmt"
+
+	"github.com/andrsj/go-rabbit-image/internal/delivery/http/auth"
 	"github.com/andrsj/go-rabbit-image/internal/delivery/http/rest/api"
+	"github.com/andrsj/go-rabbit-image/internal/metrics"
 	"github.com/andrsj/go-rabbit-image/pkg/logger"
 	"github.com/gin-gonic/gin"
 )
@@ -9,18 +13,43 @@ import (
 // Handler is a struct that holds a gin.Engine instance and a logger instance.
 type Handler struct {
 	engine *gin.Engine
-	logger logger.Logger
+	// authenticator checks the credentials of the requests, nil disables the authentication
+	authenticator auth.Authenticator
+	rateLimits    RateLimits
+	logger        logger.Logger
 }
 
-// New is a constructor function that initializes a gin.Engine instance and returns a Handler instance.
-func New(logger logger.Logger) *Handler {
+/*
+New is a constructor function that initializes a gin.Engine instance and returns a Handler instance,
+the authenticator may be nil if the authentication is disabled, as the rate limiters.
+
+The client IP keys the rate limits and the uploads without the authentication,
+so it's taken from X-Forwarded-For only behind the trusted proxies, none by default.
+*/
+func New(
+	authenticator auth.Authenticator,
+	rateLimits RateLimits,
+	trustedProxies []string,
+	logger logger.Logger,
+) (*Handler, error) {
 	r := gin.Default()
 	r.HandleMethodNotAllowed = true
 
-	return &Handler{
-		engine: r,
-		logger: logger.Named("Gin engine"),
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, fmt.Errorf("set trusted proxies: %w", err)
 	}
+
+	h := &Handler{
+		engine:        r,
+		authenticator: authenticator,
+		rateLimits:    rateLimits,
+		logger:        logger.Named("Gin engine"),
+	}
+
+	// Every request is traced and counted, including the rejected and unmatched ones
+	r.Use(h.trace(), h.instrument())
+
+	return h, nil
 }
 
 // GetGinEngine is a method that returns the gin.Engine instance from a Handler instance.
@@ -28,10 +57,33 @@ func (h *Handler) GetGinEngine() *gin.Engine {
 	return h.engine
 }
 
-// Register is a method that registers the API routes defined in api.API to the gin.Engine instance in Handler.
+/*
+Register is a method that registers the API routes defined in api.API to the gin.Engine instance in Handler.
+
+The upload and the deletion need the upload scope, the reads need the read scope,
+only the health checks, the metrics and the signed URLs of the images are public.
+The rate of the requests is limited after the authentication, so the clients are told apart by the credentials.
+*/
 func (h *Handler) Register(router api.API) {
 	h.logger.Info("Registration of controllers", nil)
 	h.engine.GET("/ping", router.Ping)
-	h.engine.GET("/img/:id", router.GetImage)
-	h.engine.POST("/send-image", router.PublishImage)
+	h.engine.GET("/healthz", router.Healthz)
+	h.engine.GET("/readyz", router.Readyz)
+	h.engine.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	// The signed URL is checked by the endpoint itself instead of the credentials
+	h.engine.GET("/img/:id",
+		h.requireUnlessSigned(auth.ScopeRead), h.limit(h.rateLimits.Download), router.GetImage)
+
+	read := h.engine.Group("/", h.require(auth.ScopeRead), h.limit(h.rateLimits.Download))
+	read.GET("/stats", router.GetStats)
+	read.POST("/img/:id/sign", router.SignImageURL)
+	read.GET("/img/:id/status", router.GetImageStatus)
+	read.GET("/img/:id/meta", router.GetImageMeta)
+	read.GET("/images", router.ListImages)
+	read.GET("/img/:id/transform", router.GetTransformedImage)
+
+	upload := h.engine.Group("/", h.require(auth.ScopeUpload), h.limit(h.rateLimits.Upload))
+	upload.POST("/send-image", router.PublishImage)
+	upload.DELETE("/img/:id", router.DeleteImage)
 }
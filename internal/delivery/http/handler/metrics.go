@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/andrsj/go-rabbit-image/internal/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// unmatchedRoute is the route of the requests that match no route, so the paths don't become labels.
+const unmatchedRoute = "unmatched"
+
+// instrument returns the middleware that counts the requests and measures their latency by the route.
+func (h *Handler) instrument() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+
+		ctx.Next()
+
+		route := ctx.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+
+		method := ctx.Request.Method
+		status := ctx.Writer.Status()
+
+		metrics.HTTPRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+		metrics.HTTPDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/andrsj/go-rabbit-image/internal/delivery/http/auth"
+	"github.com/andrsj/go-rabbit-image/internal/delivery/http/ratelimit"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimits are the limiters of the requests per client, nil disables the limit.
+type RateLimits struct {
+	// Upload limits the upload and the deletion of the images.
+	Upload ratelimit.Limiter
+	// Download limits the rest of the endpoints but the health check and the metrics.
+	Download ratelimit.Limiter
+}
+
+/*
+limit returns the middleware that limits the rate of the requests of every client.
+
+The client is the authenticated principal (the API key or the subject of the token),
+the client IP without the authentication. The request over the limit gets 429 with Retry-After.
+*/
+func (h *Handler) limit(limiter ratelimit.Limiter) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if limiter == nil {
+			ctx.Next()
+
+			return
+		}
+
+		client := "ip:" + ctx.ClientIP()
+		if principal, ok := auth.FromContext(ctx); ok {
+			client = principal.ID
+		}
+
+		allowed, retryAfter := limiter.Allow(client)
+		if !allowed {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+
+			h.logger.Warn("Rate limit exceeded", logger.M{
+				"client":      client,
+				"path":        ctx.FullPath(),
+				"retry_after": seconds,
+			})
+			ctx.Header("Retry-After", strconv.Itoa(seconds))
+			ctx.AbortWithStatusJSON(
+				http.StatusTooManyRequests,
+				gin.H{"error": fmt.Sprintf("Too many requests, retry after %d seconds", seconds)},
+			)
+
+			return
+		}
+
+		ctx.Next()
+	}
+}
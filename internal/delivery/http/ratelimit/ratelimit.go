@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limiter limits the requests of every client separately.
+type Limiter interface {
+	// Allow takes a token of the client, if there is none it returns the time until the next one.
+	Allow(key string) (bool, time.Duration)
+}
+
+// Config is the rate of the requests of a client.
+type Config struct {
+	// PerMinute is the rate at which the tokens are refilled.
+	PerMinute int
+	// Burst is the size of the bucket, the number of requests the client can make at once.
+	Burst int
+}
+
+// bucket is the token bucket of a client.
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+/*
+limiter keeps a token bucket per client.
+
+The idle buckets are full anyway, so they are removed by the sweep
+and created again on the next request of the client.
+*/
+type limiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	limit     rate.Limit
+	burst     int
+	idle      time.Duration
+	lastSweep time.Time
+}
+
+var _ Limiter = (*limiter)(nil)
+
+// minIdle is the minimum time a bucket is kept after the last request.
+const minIdle = time.Minute
+
+// New creates the limiter with the rate of the config.
+func New(cfg Config) *limiter {
+	limit := rate.Limit(float64(cfg.PerMinute) / time.Minute.Seconds())
+
+	// The bucket is full again after burst / rate
+	idle := time.Duration(float64(cfg.Burst) / float64(limit) * float64(time.Second))
+	if idle < minIdle {
+		idle = minIdle
+	}
+
+	return &limiter{
+		buckets:   make(map[string]*bucket),
+		limit:     limit,
+		burst:     cfg.Burst,
+		idle:      idle,
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *limiter) Allow(key string) (bool, time.Duration) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[key] = b
+	}
+
+	b.lastSeen = now
+
+	reservation := b.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		// The token is not taken, the client must retry later
+		reservation.CancelAt(now)
+
+		return false, delay
+	}
+
+	return true, 0
+}
+
+// sweep removes the buckets of the idle clients, it runs at most once per idle period.
+func (l *limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.idle {
+		return
+	}
+
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) >= l.idle {
+			delete(l.buckets, key)
+		}
+	}
+
+	l.lastSweep = now
+}
@@ -0,0 +1,113 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   Config
+		taken int
+		// allowed is the result of the request after the taken ones
+		allowed bool
+		// delay is the upper bound of the time until the next token
+		delay time.Duration
+	}{
+		{"within the burst", Config{PerMinute: 60, Burst: 3}, 2, true, 0},
+		{"over the burst", Config{PerMinute: 60, Burst: 3}, 3, false, time.Second},
+		{"slow rate", Config{PerMinute: 1, Burst: 1}, 1, false, time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := New(test.cfg)
+
+			for i := 0; i < test.taken; i++ {
+				if ok, _ := l.Allow("client"); !ok {
+					t.Fatalf("request %d is denied within the burst", i+1)
+				}
+			}
+
+			ok, delay := l.Allow("client")
+			if ok != test.allowed {
+				t.Fatalf("got allowed %t, want %t", ok, test.allowed)
+			}
+
+			if ok && delay != 0 || !ok && (delay <= 0 || delay > test.delay) {
+				t.Fatalf("got delay %s, want up to %s", delay, test.delay)
+			}
+
+			// The other clients have their own buckets
+			if ok, _ := l.Allow("other"); !ok {
+				t.Fatal("the other client is denied")
+			}
+		})
+	}
+}
+
+func TestAllowRefills(t *testing.T) {
+	l := New(Config{PerMinute: 6000, Burst: 1})
+
+	if ok, _ := l.Allow("client"); !ok {
+		t.Fatal("the first request is denied")
+	}
+
+	ok, delay := l.Allow("client")
+	if ok {
+		t.Fatal("the request over the burst is allowed")
+	}
+
+	// The denied request doesn't take the token, so it's there after the delay
+	time.Sleep(delay)
+
+	if ok, _ := l.Allow("client"); !ok {
+		t.Fatalf("the request after %s is denied", delay)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	l := New(Config{PerMinute: 60, Burst: 1})
+
+	if l.idle != minIdle {
+		t.Fatalf("got idle %s, want %s", l.idle, minIdle)
+	}
+
+	l.Allow("idle")
+	l.Allow("active")
+
+	// The idle client was last seen an idle period ago, the sweep is due
+	past := time.Now().Add(-l.idle)
+	l.buckets["idle"].lastSeen = past
+	l.lastSweep = past
+
+	l.Allow("active")
+
+	if _, ok := l.buckets["idle"]; ok {
+		t.Fatal("the bucket of the idle client is kept")
+	}
+
+	if _, ok := l.buckets["active"]; !ok {
+		t.Fatal("the bucket of the active client is removed")
+	}
+
+	// The removed bucket is full again
+	if ok, _ := l.Allow("idle"); !ok {
+		t.Fatal("the idle client is denied")
+	}
+}
+
+func TestSweepWaitsForIdlePeriod(t *testing.T) {
+	l := New(Config{PerMinute: 60, Burst: 1})
+
+	l.Allow("idle")
+	l.buckets["idle"].lastSeen = time.Now().Add(-l.idle)
+
+	// The last sweep is recent, so nothing is removed yet
+	l.Allow("active")
+
+	if _, ok := l.buckets["idle"]; !ok {
+		t.Fatal("the bucket is removed before the sweep is due")
+	}
+}
@@ -2,9 +2,19 @@ package api
 
 import (
 	"net/http"
+	"time"
 
+	"github.com/andrsj/go-rabbit-image/internal/delivery/http/auth"
+	"github.com/andrsj/go-rabbit-image/internal/domain/dto"
 	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/queue"
+	"github.com/andrsj/go-rabbit-image/internal/domain/variant"
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/codec"
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/exif"
+	"github.com/andrsj/go-rabbit-image/internal/services/health"
+	"github.com/andrsj/go-rabbit-image/internal/services/image/catalog"
+	"github.com/andrsj/go-rabbit-image/internal/services/image/status"
 	"github.com/andrsj/go-rabbit-image/internal/services/image/storage"
+	"github.com/andrsj/go-rabbit-image/internal/services/image/transform"
 	"github.com/andrsj/go-rabbit-image/pkg/logger"
 	"github.com/gin-gonic/gin"
 )
@@ -12,30 +22,107 @@ import (
 // API interface representation of controllers for Gin engine.
 type API interface {
 	Ping(ctx *gin.Context)
+	Healthz(ctx *gin.Context)
+	Readyz(ctx *gin.Context)
 	GetImage(ctx *gin.Context)
+	SignImageURL(ctx *gin.Context)
 	PublishImage(ctx *gin.Context)
+	DeleteImage(ctx *gin.Context)
+	GetImageStatus(ctx *gin.Context)
+	GetImageMeta(ctx *gin.Context)
+	ListImages(ctx *gin.Context)
+	GetTransformedImage(ctx *gin.Context)
+	GetStats(ctx *gin.Context)
+}
+
+// StatsProvider reports the load of the background worker.
+type StatsProvider interface {
+	Stats() dto.WorkerStatsDTO
 }
 
 // API representation of controllers for Gin engine.
 type api struct {
 	imageService     storage.FileStorage
+	statusService    status.JobStatus
+	catalogService   catalog.Catalog
+	transformService transform.Transformer
 	publisherService queue.Publisher
-	logger           logger.Logger
+	statsProvider    StatsProvider
+	healthService    health.Checker
+	variants         variant.Set
+	// cacheControl is the Cache-Control header of the stored images
+	cacheControl string
+	// metadataPolicy decides which EXIF the stored originals keep
+	metadataPolicy exif.Policy
+	uploadLimits   UploadLimits
+	signedURLs     SignedURLs
+	quota          Quota
+	// quotaLocks make the quota check and the registration of the image atomic
+	quotaLocks quotaLocks
+	logger     logger.Logger
+}
+
+// UploadLimits are the limits of the uploaded images.
+type UploadLimits struct {
+	// MaxSize is the maximum size of the uploaded file in bytes.
+	MaxSize int64
+	// Dimensions limit the decoded image, they are checked by the header before storing.
+	Dimensions codec.Limits
+}
+
+// SignedURLs are the settings of the signed URLs of the images.
+type SignedURLs struct {
+	// Signer signs and verifies the URLs, nil disables the signed URLs.
+	Signer auth.URLSigner
+	// DefaultTTL and MaxTTL are the lifetime of the signed URLs.
+	DefaultTTL time.Duration
+	MaxTTL     time.Duration
 }
 
 var _ API = (*api)(nil)
 
 // New function is a constructor for the api struct.
-func New(imageService storage.FileStorage, publisher queue.Publisher, logger logger.Logger) *api {
+func New(
+	imageService storage.FileStorage,
+	statusService status.JobStatus,
+	catalogService catalog.Catalog,
+	transformService transform.Transformer,
+	publisher queue.Publisher,
+	statsProvider StatsProvider,
+	healthService health.Checker,
+	variants variant.Set,
+	cacheControl string,
+	metadataPolicy exif.Policy,
+	uploadLimits UploadLimits,
+	signedURLs SignedURLs,
+	quota Quota,
+	logger logger.Logger,
+) *api {
 	return &api{
 		imageService:     imageService,
+		statusService:    statusService,
+		catalogService:   catalogService,
+		transformService: transformService,
 		publisherService: publisher,
+		statsProvider:    statsProvider,
+		healthService:    healthService,
+		variants:         variants,
+		cacheControl:     cacheControl,
+		metadataPolicy:   metadataPolicy,
+		uploadLimits:     uploadLimits,
+		signedURLs:       signedURLs,
+		quota:            quota,
 		logger:           logger.Named("API"),
 	}
 }
 
-// Ping method returns a 200 OK status code [use it e.g. health checking].
+// Ping method returns a 200 OK status code, the health checks should use /healthz and /readyz.
 func (a *api) Ping(ctx *gin.Context) {
 	a.logger.Info("Endpoint hit: Ping", nil)
 	ctx.String(http.StatusOK, "Ok")
 }
+
+// GetStats method returns the in-flight images, encode tasks and the depth of the queue.
+func (a *api) GetStats(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, a.statsProvider.Stats())
+}
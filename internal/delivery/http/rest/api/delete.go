@@ -0,0 +1,107 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/job"
+	"github.com/andrsj/go-rabbit-image/internal/domain/variant"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+DeleteImage method represents DELETE endpoint that removes the image with all variants.
+
+The job of the image is removed first and leaves a tombstone,
+so the worker skips the queued message and removes the variants written by a running job.
+*/
+func (a *api) DeleteImage(ctx *gin.Context) {
+	imageID := ctx.Param("id")
+
+	if !isValidUUID(imageID) {
+		a.logger.Error("DeleteImage: Invalid image ID", logger.M{"image_id": imageID})
+		ctx.AbortWithStatusJSON(
+			http.StatusBadRequest,
+			gin.H{"error": fmt.Sprintf("Wrong path parameter: %s", errInvalidUUID)},
+		)
+
+		return
+	}
+
+	if !a.authorize(ctx, imageID) {
+		return
+	}
+
+	// The images stored before the jobs were introduced have no job
+	err := a.statusService.Delete(imageID)
+	found := err == nil
+
+	if err != nil && !errors.Is(err, job.ErrNotFound) {
+		a.logger.Error("DeleteImage: Failed to delete the job", logger.M{
+			"error":    err,
+			"image_id": imageID,
+		})
+		ctx.AbortWithStatusJSON(
+			http.StatusInternalServerError,
+			gin.H{"error": fmt.Sprintf("Can't delete the image '%s'", imageID)},
+		)
+
+		return
+	}
+
+	if !found {
+		found, err = a.imageService.ImageExists(imageID, variant.OriginalKey)
+		if err != nil {
+			a.logger.Error("DeleteImage: Failed to check the image", logger.M{
+				"error":    err,
+				"image_id": imageID,
+			})
+			ctx.AbortWithStatusJSON(
+				http.StatusInternalServerError,
+				gin.H{"error": fmt.Sprintf("Can't delete the image '%s'", imageID)},
+			)
+
+			return
+		}
+	}
+
+	if !found {
+		ctx.AbortWithStatusJSON(
+			http.StatusNotFound,
+			gin.H{"error": fmt.Sprintf("Image not found: '%s'", imageID)},
+		)
+
+		return
+	}
+
+	if err := a.catalogService.Delete(imageID); err != nil {
+		a.logger.Error("DeleteImage: Failed to delete the image from catalog", logger.M{
+			"error":    err,
+			"image_id": imageID,
+		})
+		ctx.AbortWithStatusJSON(
+			http.StatusInternalServerError,
+			gin.H{"error": fmt.Sprintf("Can't delete the image '%s'", imageID)},
+		)
+
+		return
+	}
+
+	if err := a.imageService.DeleteImageFromStorage(imageID); err != nil {
+		a.logger.Error("DeleteImage: Failed to delete the image from storage", logger.M{
+			"error":    err,
+			"image_id": imageID,
+		})
+		ctx.AbortWithStatusJSON(
+			http.StatusInternalServerError,
+			gin.H{"error": fmt.Sprintf("Can't delete the image '%s'", imageID)},
+		)
+
+		return
+	}
+
+	a.logger.Info("DeleteImage: Image deleted", logger.M{"image_id": imageID})
+	ctx.Status(http.StatusNoContent)
+}
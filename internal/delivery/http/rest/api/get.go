@@ -5,21 +5,20 @@ import (
 	"fmt"
 	"net/http"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/andrsj/go-rabbit-image/internal/delivery/http/auth"
+	"github.com/andrsj/go-rabbit-image/internal/domain/variant"
 	"github.com/andrsj/go-rabbit-image/pkg/logger"
 	"github.com/gin-gonic/gin"
 )
 
-const (
-	DefaultLevel = "100"
-	Level1       = "75"
-	Level2       = "50"
-	Level3       = "25"
-)
-
 type imageParams struct {
-	ID      string
-	Quality string
+	ID string
+	// Variant is the name of the variant profile, the old quality parameter is its alias
+	Variant string
 }
 
 // GetImage method represents GET endpoint for user.
@@ -27,13 +26,18 @@ func (a *api) GetImage(ctx *gin.Context) {
 	// Get image parameters from URL
 	params := imageParams{
 		ID:      ctx.Param("id"),
-		Quality: ctx.DefaultQuery("quality", DefaultLevel),
+		Variant: ctx.Query("variant"),
+	}
+
+	// The quality levels (100, 75, 50, 25) are the names of the default profiles
+	if params.Variant == "" {
+		params.Variant = ctx.DefaultQuery("quality", variant.Original)
 	}
 
 	// Validate image parameters
 	a.logger.Debug("GetImage: Validating image params", logger.M{"params": params})
 
-	err := validateGetImageParams(params)
+	key, err := validateGetImageParams(params, a.variants)
 	if err != nil {
 		// Log and return error message to client
 		a.logger.Error("GetImage: Invalid image params", logger.M{
@@ -48,19 +52,41 @@ func (a *api) GetImage(ctx *gin.Context) {
 		return
 	}
 
+	// The signed URL replaces the credentials, it's valid only for its image, variant and client
+	var signedUntil time.Time
+
+	if _, signed := ctx.GetQuery(auth.ParamSignature); signed {
+		signedUntil, err = a.verifySignedURL(ctx, params.ID, key)
+		if err != nil {
+			a.logger.Warn("GetImage: Invalid signed URL", logger.M{
+				"error":    err,
+				"image_id": params.ID,
+				"variant":  params.Variant,
+			})
+			ctx.AbortWithStatusJSON(
+				http.StatusForbidden,
+				gin.H{"error": fmt.Sprintf("Invalid signed URL: %s", err)},
+			)
+
+			return
+		}
+	} else if !a.authorize(ctx, params.ID) {
+		return
+	}
+
 	// Read image from file storage
 	a.logger.Debug("GetImage: Reading image from storage", logger.M{
 		"image_id": params.ID,
-		"quality":  params.Quality,
+		"variant":  params.Variant,
 	})
 
-	img, err := a.imageService.ReadImageFromStorage(params.ID, params.Quality)
+	img, info, err := a.imageService.OpenImageFromStorage(params.ID, key)
 	if err != nil {
 		// Log and return error message to client
 		a.logger.Error("GetImage: Failed to read image from storage", logger.M{
 			"error":    err,
 			"image_id": params.ID,
-			"quality":  params.Quality,
+			"variant":  params.Variant,
 		})
 		ctx.AbortWithStatusJSON(
 			http.StatusNotFound,
@@ -69,52 +95,51 @@ func (a *api) GetImage(ctx *gin.Context) {
 
 		return
 	}
-
-	// Set the content type header and status code
-	contentType := http.DetectContentType(img)
-	ctx.Header("Content-type", contentType)
-	ctx.Writer.WriteHeader(http.StatusOK)
+	defer img.Close()
 
 	// Send image to client
 	a.logger.Info("GetImage: Sending image to client", logger.M{
 		"image_id": params.ID,
-		"quality":  params.Quality,
+		"variant":  params.Variant,
+		"size":     info.Size,
 	})
 
-	if _, err := ctx.Writer.Write(img); err != nil {
-		// Log and return error message to client
-		a.logger.Error("GetImage: Failed to send image to client", logger.M{
-			"error":    err,
-			"image_id": params.ID,
-		})
-		ctx.AbortWithStatusJSON(
-			http.StatusInternalServerError,
-			gin.H{"error": fmt.Sprintf("Failed to send image '%s'", params.ID)},
-		)
+	// The stored images never change, so they can be cached by the clients and CDNs
+	ctx.Header("Content-Type", info.ContentType)
 
-		return
+	if info.Hash != "" {
+		ctx.Header("ETag", strconv.Quote(info.Hash))
+	}
+
+	switch cacheControl := a.cacheControlOf(ctx); {
+	case !signedUntil.IsZero():
+		// The shared caches must not serve the signed image after the URL expires
+		ctx.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", int(time.Until(signedUntil).Seconds())))
+	case cacheControl != "":
+		ctx.Header("Cache-Control", cacheControl)
 	}
+
+	// The image is streamed from the storage, ServeContent answers the conditional
+	// (If-None-Match, If-Modified-Since) requests with 304 and the range requests with 206
+	http.ServeContent(ctx.Writer, ctx.Request, "", info.ModTime, img)
 }
 
-var (
-	errInvalidUUID  = errors.New("invalid format of ID")
-	errQualityImage = errors.New("invalid quality parameter: use 100, 75, 50 or 25")
-)
+var errInvalidUUID = errors.New("invalid format of ID")
 
-// validateGetImageParams validates the image parameters.
-func validateGetImageParams(params imageParams) error {
+// validateGetImageParams validates the image parameters and returns the storage key of the variant.
+func validateGetImageParams(params imageParams, variants variant.Set) (string, error) {
 	// Check if the ID parameter has a valid UUID format
 	if !isValidUUID(params.ID) {
-		return errInvalidUUID
+		return "", errInvalidUUID
 	}
 
-	// Check if the quality parameter is valid
-	switch params.Quality {
-	case DefaultLevel, Level1, Level2, Level3:
-		return nil
-	default:
-		return fmt.Errorf("%w: %s quality", errQualityImage, params.Quality)
+	// Check if the variant is the original or one of the profiles
+	key, err := variants.Key(params.Variant)
+	if err != nil {
+		return "", fmt.Errorf("%w, use one of: %s", err, strings.Join(variants.Names(), ", "))
 	}
+
+	return key, nil
 }
 
 // isValidUUID checks if the given string is a valid UUID.
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/andrsj/go-rabbit-image/internal/domain/dto"
+	"github.com/gin-gonic/gin"
+)
+
+// Healthz reports that the process is alive, e.g. for the liveness probe.
+// The dependencies aren't checked, so their outage doesn't restart the process.
+func (a *api) Healthz(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "no-store")
+	ctx.JSON(http.StatusOK, a.healthService.Live())
+}
+
+/*
+Readyz reports whether the service can serve the requests, e.g. for the readiness probe of the load balancer.
+
+It responds with 200 if the broker, the storage and the worker are ready and with 503 otherwise,
+the body has the status, the latency and the error of every component.
+On shutdown, it responds with 503 before the server stops, so the load balancers drain the instance first.
+*/
+func (a *api) Readyz(ctx *gin.Context) {
+	health := a.healthService.Ready(ctx.Request.Context())
+
+	status := http.StatusOK
+	if health.Status != dto.HealthOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	ctx.Header("Cache-Control", "no-store")
+	ctx.JSON(status, health)
+}
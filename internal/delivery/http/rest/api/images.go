@@ -0,0 +1,188 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andrsj/go-rabbit-image/internal/delivery/http/auth"
+	"github.com/andrsj/go-rabbit-image/internal/domain/dto"
+	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/catalog"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+	defaultSort    = "created_at"
+)
+
+var errInvalidOrder = errors.New("order must be asc or desc")
+
+// GetImageMeta method represents GET endpoint with the catalog record of the image.
+func (a *api) GetImageMeta(ctx *gin.Context) {
+	imageID := ctx.Param("id")
+
+	if !isValidUUID(imageID) {
+		a.logger.Error("GetImageMeta: Invalid image ID", logger.M{"image_id": imageID})
+		ctx.AbortWithStatusJSON(
+			http.StatusBadRequest,
+			gin.H{"error": fmt.Sprintf("Wrong path parameter: %s", errInvalidUUID)},
+		)
+
+		return
+	}
+
+	if !a.authorize(ctx, imageID) {
+		return
+	}
+
+	image, err := a.catalogService.GetImage(imageID)
+	if errors.Is(err, catalog.ErrNotFound) {
+		ctx.AbortWithStatusJSON(
+			http.StatusNotFound,
+			gin.H{"error": fmt.Sprintf("Image not found: %s", err)},
+		)
+
+		return
+	}
+
+	if err != nil {
+		a.logger.Error("GetImageMeta: Failed to read the image", logger.M{
+			"error":    err,
+			"image_id": imageID,
+		})
+		ctx.AbortWithStatusJSON(
+			http.StatusInternalServerError,
+			gin.H{"error": fmt.Sprintf("Can't read the metadata of image '%s'", imageID)},
+		)
+
+		return
+	}
+
+	ctx.JSON(http.StatusOK, image)
+}
+
+// ListImages method represents GET endpoint with the page of the catalog.
+func (a *api) ListImages(ctx *gin.Context) {
+	query, err := parseImageQuery(ctx)
+	if err != nil {
+		a.logger.Error("ListImages: Invalid params", logger.M{"error": err})
+		ctx.AbortWithStatusJSON(
+			http.StatusBadRequest,
+			gin.H{"error": fmt.Sprintf("Wrong query parameter: %s", err)},
+		)
+
+		return
+	}
+
+	// The principals restricted to their own images see only them
+	if principal, ok := auth.FromContext(ctx); ok && principal.OwnerOnly {
+		query.Uploader = principal.ID
+	}
+
+	page, err := a.catalogService.ListImages(query)
+	if errors.Is(err, catalog.ErrInvalidQuery) {
+		ctx.AbortWithStatusJSON(
+			http.StatusBadRequest,
+			gin.H{"error": fmt.Sprintf("Wrong query parameter: %s", err)},
+		)
+
+		return
+	}
+
+	if err != nil {
+		a.logger.Error("ListImages: Failed to list the images", logger.M{"error": err})
+		ctx.AbortWithStatusJSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Can't list the images"},
+		)
+
+		return
+	}
+
+	ctx.JSON(http.StatusOK, page)
+}
+
+/*
+parseImageQuery reads the list parameters:
+
+	page, per_page                the page starting from 1 and its size up to 100
+	sort, order                   created_at/size/filename/content_type and asc/desc
+	content_type, uploader        the exact values
+	created_after, created_before the time range in RFC 3339
+
+The newest images go first by default.
+*/
+func parseImageQuery(ctx *gin.Context) (dto.ImageQueryDTO, error) {
+	query := dto.ImageQueryDTO{
+		Sort:        ctx.DefaultQuery("sort", defaultSort),
+		ContentType: ctx.Query("content_type"),
+		Uploader:    ctx.Query("uploader"),
+	}
+
+	var err error
+
+	if query.Page, err = positiveQuery(ctx, "page", 1); err != nil {
+		return query, err
+	}
+
+	if query.PerPage, err = positiveQuery(ctx, "per_page", defaultPerPage); err != nil {
+		return query, err
+	}
+
+	if query.PerPage > maxPerPage {
+		query.PerPage = maxPerPage
+	}
+
+	switch ctx.DefaultQuery("order", "desc") {
+	case "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return query, errInvalidOrder
+	}
+
+	if query.CreatedAfter, err = timeQuery(ctx, "created_after"); err != nil {
+		return query, err
+	}
+
+	if query.CreatedBefore, err = timeQuery(ctx, "created_before"); err != nil {
+		return query, err
+	}
+
+	return query, nil
+}
+
+// positiveQuery returns the positive integer query parameter or the default if it's not set.
+func positiveQuery(ctx *gin.Context, name string, defaultValue int) (int, error) {
+	raw, ok := ctx.GetQuery(name)
+	if !ok {
+		return defaultValue, nil
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("%s: must be a positive number", name)
+	}
+
+	return value, nil
+}
+
+// timeQuery returns the RFC 3339 time query parameter or the zero time if it's not set.
+func timeQuery(ctx *gin.Context, name string) (time.Time, error) {
+	raw, ok := ctx.GetQuery(name)
+	if !ok {
+		return time.Time{}, nil
+	}
+
+	value, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: must be a time in RFC 3339", name)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/andrsj/go-rabbit-image/internal/delivery/http/auth"
+	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/catalog"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+authorize checks that the principal of the request may access the image
+and responds with 404 if it may not.
+
+The principals restricted to their own images see the images of the others as missing,
+so the IDs of the images aren't disclosed. The images without the catalog record have no owner.
+*/
+func (a *api) authorize(ctx *gin.Context, imageID string) bool {
+	principal, ok := auth.FromContext(ctx)
+	if !ok || !principal.OwnerOnly {
+		return true
+	}
+
+	image, err := a.catalogService.GetImage(imageID)
+	if err != nil && !errors.Is(err, catalog.ErrNotFound) {
+		a.logger.Error("Can't read the owner of the image", logger.M{
+			"error":    err,
+			"image_id": imageID,
+		})
+	}
+
+	if err == nil && image.Uploader == principal.ID {
+		return true
+	}
+
+	ctx.AbortWithStatusJSON(
+		http.StatusNotFound,
+		gin.H{"error": fmt.Sprintf("Image not found: '%s'", imageID)},
+	)
+
+	return false
+}
+
+// uploader identifies the client that uploads the image: the authenticated principal,
+// or the address of the client if the authentication is disabled,
+// the proxies set it by X-Forwarded-For only if they are trusted ("http.trusted_proxies").
+func uploader(ctx *gin.Context) string {
+	if principal, ok := auth.FromContext(ctx); ok {
+		return principal.ID
+	}
+
+	return ctx.ClientIP()
+}
+
+/*
+cacheControlOf returns the Cache-Control header of the image.
+
+The authenticated reads must not be stored by the shared caches (CDNs, proxies):
+they would serve the image to the clients without the credentials,
+so the configured public header is kept only when the authentication is disabled.
+*/
+func (a *api) cacheControlOf(ctx *gin.Context) string {
+	if _, ok := auth.FromContext(ctx); !ok || a.cacheControl == "" {
+		return a.cacheControl
+	}
+
+	if strings.Contains(a.cacheControl, "public") {
+		return strings.Replace(a.cacheControl, "public", "private", 1)
+	}
+
+	if strings.Contains(a.cacheControl, "private") || strings.Contains(a.cacheControl, "no-store") {
+		return a.cacheControl
+	}
+
+	return "private, " + a.cacheControl
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andrsj/go-rabbit-image/internal/delivery/http/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCacheControlOf(t *testing.T) {
+	tests := []struct {
+		name         string
+		configured   string
+		principal    *auth.Principal
+		cacheControl string
+	}{
+		{"without auth", "public, max-age=60", nil, "public, max-age=60"},
+		{"authenticated", "public, max-age=60", &auth.Principal{ID: "key:a"}, "private, max-age=60"},
+		{"owner only", "public, max-age=60", &auth.Principal{ID: "key:a", OwnerOnly: true}, "private, max-age=60"},
+		{"authenticated without public", "max-age=60", &auth.Principal{ID: "key:a"}, "private, max-age=60"},
+		{"no-store", "no-store", &auth.Principal{ID: "key:a"}, "no-store"},
+		{"disabled", "", &auth.Principal{ID: "key:a"}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			if test.principal != nil {
+				auth.WithPrincipal(ctx, test.principal)
+			}
+
+			a := &api{cacheControl: test.configured}
+			if got := a.cacheControlOf(ctx); got != test.cacheControl {
+				t.Fatalf("got %q, want %q", got, test.cacheControl)
+			}
+		})
+	}
+}
@@ -1,76 +1,259 @@
 package api
 
 import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 
+	"github.com/andrsj/go-rabbit-image/internal/domain/dto"
+	"github.com/andrsj/go-rabbit-image/internal/domain/variant"
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/codec"
+	"github.com/andrsj/go-rabbit-image/internal/tracing"
 	"github.com/andrsj/go-rabbit-image/pkg/logger"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// PublishImage represents the POST endpoint for publishing users images.
+const (
+	// formField is the name of the form field with the image.
+	formField = "image"
+	// formOverhead is the room for the boundaries and the other fields of the form over the size of the image.
+	formOverhead = 1 << 20
+	// configHeaderLen is the limit of the header that is read to get the dimensions of the image.
+	configHeaderLen = 1 << 20
+)
+
+var (
+	errNoImage        = errors.New("the form has no 'image' field")
+	errUploadTooLarge = errors.New("the image is larger than the upload limit")
+)
+
+/*
+PublishImage represents the POST endpoint for publishing users images.
+
+The multipart form is parsed as a stream: the image is written to the file storage
+part by part and only its ID is published (claim check), so the image is never held in memory.
+
+The uploads over the size limit are rejected with 413 and the images
+with the dimensions over the limits with 422, the dimensions are read from the header
+before the image is stored. Once the job is registered, the rejected images are recorded as failed jobs.
+
+The uploads over the storage quota of the uploader get 403, the quota is checked before reading
+the image and again with its size before it's registered and published.
+*/
 func (a *api) PublishImage(ctx *gin.Context) {
-	// Retrieve the image file from the form data
-	file, err := ctx.FormFile("image")
-	if err != nil {
-		a.logger.Error("Can't get image from form data", logger.M{"error": err})
+	maxSize := a.uploadLimits.MaxSize
+
+	// The size of the body is known before reading it if the client sends it
+	if ctx.Request.ContentLength > maxSize+formOverhead {
+		a.logger.Error("Upload is too large", logger.M{"size": ctx.Request.ContentLength, "limit": maxSize})
+		ctx.AbortWithStatusJSON(
+			http.StatusRequestEntityTooLarge,
+			gin.H{"error": fmt.Sprintf("The image is larger than %d bytes", maxSize)},
+		)
 
 		return
 	}
 
-	// Open the image file
-	src, err := file.Open()
+	// The uploader without room for one more image is rejected before reading the image
+	owner := uploader(ctx)
+	if !a.allowUpload(ctx, owner, 1) {
+		return
+	}
+
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxSize+formOverhead)
+
+	// Find the image in the form data
+	part, err := imagePart(ctx.Request)
 	if err != nil {
-		a.logger.Error("Can't open the image file", logger.M{"error": err})
+		a.logger.Error("Can't get image from form data", logger.M{"error": err})
 		ctx.AbortWithStatusJSON(
-			http.StatusInternalServerError,
-			gin.H{"error": fmt.Sprintf("Can't open the file: %s", err)},
+			http.StatusBadRequest,
+			gin.H{"error": fmt.Sprintf("Can't get the image: %s", err)},
 		)
 
 		return
 	}
-	defer src.Close()
+	defer part.Close()
 
-	// Read the contents of the image file into a buffer
-	buf := make([]byte, file.Size)
+	// The body may be sent without the length, so the image is also counted while it's read
+	limited := &sizeLimiter{r: part, left: maxSize}
 
-	_, err = io.ReadFull(src, buf)
-	if err != nil {
+	// Peek the header of the image to detect the content type
+	src := bufio.NewReaderSize(limited, codec.SniffLen)
+
+	header, err := src.Peek(codec.SniffLen)
+	if err != nil && !errors.Is(err, io.EOF) {
 		a.logger.Error("Can't read the image", logger.M{"error": err})
 		ctx.AbortWithStatusJSON(
-			http.StatusInternalServerError,
+			http.StatusBadRequest,
 			gin.H{"error": fmt.Sprintf("Can't read the image: %s", err)},
 		)
 
 		return
 	}
 
-	// Detect the content type of the image and validate it
-	contentType := http.DetectContentType(buf)
-	switch contentType {
-	case "image/jpeg", "image/png":
-	default:
-		a.logger.Error("Can't accept the type of image", logger.M{"content type": contentType})
+	// Detect the format of the image, only the registered formats are accepted
+	format, err := codec.Detect(header)
+	if err != nil {
+		a.logger.Error("Can't accept the type of image", logger.M{"error": err})
 		ctx.AbortWithStatusJSON(
 			http.StatusBadRequest,
-			gin.H{"error": fmt.Sprintf("Can't accept the type '%s': please, use jpg/png type", contentType)},
+			gin.H{"error": fmt.Sprintf("Can't accept the type '%s': %s", http.DetectContentType(header), err)},
 		)
 
 		return
 	}
 
+	contentType := format.ContentType
+
 	// Generate a unique ID for the image and publish it to the message queue
 	imageID := uuid.New().String()
 
-	err = a.publisherService.Publish(ctx, buf, imageID, contentType)
+	// Register the job before publishing, so the worker always finds it
+	err = a.statusService.MarkQueued(imageID)
 	if err != nil {
-		a.logger.Error("Can't publish the image", logger.M{"error": err})
+		a.logger.Error("Can't register the job of the image", logger.M{"error": err})
 		ctx.AbortWithStatusJSON(
 			http.StatusInternalServerError,
-			gin.H{"error": fmt.Sprintf("Can't publish the image: %s", err)},
+			gin.H{"error": fmt.Sprintf("Can't register the image: %s", err)},
+		)
+
+		return
+	}
+
+	// fail records the failed job and responds with its ID, so the client can check it.
+	// The image that turns out to be over the size limit gets 413 whatever step fails.
+	fail := func(status int, message string, err error) {
+		if limited.exceeded {
+			status, err = http.StatusRequestEntityTooLarge, fmt.Errorf("%w of %d bytes", errUploadTooLarge, maxSize)
+			message = "The image is too large"
+		}
+
+		a.logger.Error(message, logger.M{"error": err, "id": imageID})
+		_ = a.statusService.MarkFailed(imageID, err)
+		ctx.AbortWithStatusJSON(
+			status,
+			gin.H{"error": fmt.Sprintf("%s: %s", message, err), "id": imageID},
 		)
+	}
+
+	// Check the dimensions by the header, the decoded image may be much bigger than the file
+	config, upload, err := format.PeekConfig(src, configHeaderLen)
+
+	switch {
+	case errors.Is(err, codec.ErrNoConfig):
+		// The worker checks the dimensions of the stored original before decoding it
+		a.logger.Warn("Can't check the dimensions of the image", logger.M{"error": err, "id": imageID})
+	case err != nil:
+		fail(http.StatusBadRequest, "Can't read the image", err)
+
+		return
+	default:
+		if err := a.uploadLimits.Dimensions.Check(config); err != nil {
+			fail(http.StatusUnprocessableEntity, "Can't accept the image", err)
+
+			return
+		}
+	}
+
+	// The metadata of the image is filtered by the policy before it's stored
+	var metadata map[string]string
+
+	if format.FilterMetadata != nil {
+		filtered, kept, err := format.FilterMetadata(upload, a.metadataPolicy, a.uploadLimits.Dimensions)
+
+		switch {
+		case errors.Is(err, codec.ErrTooLarge):
+			fail(http.StatusUnprocessableEntity, "Can't accept the image", err)
+
+			return
+		case err != nil:
+			fail(http.StatusBadRequest, "Can't read the image", err)
+
+			return
+		}
+
+		upload = filtered
+
+		if !kept.Empty() {
+			metadata = kept.Fields()
+		}
+	}
+
+	// Stream the original to the storage, the worker reads it from there,
+	// the checksum of the original is computed on the way
+	checksum := sha256.New()
+
+	_, storeSpan := tracing.Tracer().Start(ctx.Request.Context(), "store original")
+
+	size, err := a.imageService.StreamImageToStorage(io.TeeReader(upload, checksum), imageID, variant.OriginalKey)
+	storeSpan.End()
+
+	if err != nil {
+		// Nothing of the image must stay in the storage
+		_ = a.imageService.DeleteImageFromStorage(imageID)
+
+		fail(http.StatusInternalServerError, "Can't store the image", err)
+
+		return
+	}
+
+	// The quota is checked with the actual size, the image isn't counted before it's registered,
+	// so the quota of the uploader is locked until then
+	unlockQuota := a.lockQuota(owner)
+
+	if err := a.checkQuota(owner, size); err != nil {
+		unlockQuota()
+
+		_ = a.imageService.DeleteImageFromStorage(imageID)
+
+		if errors.Is(err, errQuotaExceeded) {
+			fail(http.StatusForbidden, "Can't accept the image", err)
+		} else {
+			fail(http.StatusInternalServerError, "Can't check the quota", err)
+		}
+
+		return
+	}
+
+	// Add the image to the catalog, the worker adds its dimensions and variants
+	err = a.catalogService.Register(dto.ImageDTO{
+		ImageID:     imageID,
+		Filename:    part.FileName(),
+		ContentType: contentType,
+		Size:        size,
+		Checksum:    hex.EncodeToString(checksum.Sum(nil)),
+		Uploader:    owner,
+		Metadata:    metadata,
+	})
+	unlockQuota()
+
+	if err != nil {
+		// The entry may be partly written, neither it nor the original must stay
+		a.discard(imageID)
+
+		fail(http.StatusInternalServerError, "Can't register the image", err)
+
+		return
+	}
+
+	// The message has no body: the worker finds the image by its ID.
+	// The publishing isn't canceled with the request, only the span of the request is taken
+	publishContext := trace.ContextWithSpan(ctx, trace.SpanFromContext(ctx.Request.Context()))
+
+	err = a.publisherService.Publish(publishContext, nil, imageID, contentType)
+	if err != nil {
+		// The worker never gets the image, so it's not kept in the catalog and doesn't count to the quota
+		a.discard(imageID)
+
+		fail(http.StatusInternalServerError, "Can't publish the image", err)
 
 		return
 	}
@@ -79,6 +262,7 @@ func (a *api) PublishImage(ctx *gin.Context) {
 	a.logger.Info("Successfully published the image", logger.M{
 		"id":           imageID,
 		"content type": contentType,
+		"size":         size,
 	})
 	ctx.JSON(
 		http.StatusOK,
@@ -88,3 +272,80 @@ func (a *api) PublishImage(ctx *gin.Context) {
 		},
 	)
 }
+
+// discard removes the catalog entry and the stored original of the image, which upload has failed.
+func (a *api) discard(imageID string) {
+	if err := a.catalogService.Delete(imageID); err != nil {
+		a.logger.Warn("Can't remove the failed image from the catalog", logger.M{"error": err, "id": imageID})
+	}
+
+	if err := a.imageService.DeleteImageFromStorage(imageID); err != nil {
+		a.logger.Warn("Can't remove the failed image from the storage", logger.M{"error": err, "id": imageID})
+	}
+}
+
+// allowUpload checks the quota of the uploader for the image of the size and responds with the error.
+func (a *api) allowUpload(ctx *gin.Context, owner string, size int64) bool {
+	err := a.checkQuota(owner, size)
+	if err == nil {
+		return true
+	}
+
+	status, message := http.StatusInternalServerError, "Can't check the quota"
+	if errors.Is(err, errQuotaExceeded) {
+		status, message = http.StatusForbidden, "Can't accept the image"
+	}
+
+	a.logger.Error(message, logger.M{"error": err, "uploader": owner})
+	ctx.AbortWithStatusJSON(
+		status,
+		gin.H{"error": fmt.Sprintf("%s: %s", message, err)},
+	)
+
+	return false
+}
+
+// sizeLimiter fails the reading when more than the limit is read, exceeded is set then.
+type sizeLimiter struct {
+	r        io.Reader
+	left     int64
+	exceeded bool
+}
+
+func (l *sizeLimiter) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+
+	l.left -= int64(n)
+	if l.left < 0 {
+		l.exceeded = true
+
+		return 0, errUploadTooLarge
+	}
+
+	return n, err
+}
+
+// imagePart returns the part of the multipart form with the image, the parts before it are skipped.
+func imagePart(r *http.Request) (*multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, fmt.Errorf("multipart: %w", err)
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, errNoImage
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("multipart: %w", err)
+		}
+
+		if part.FormName() == formField {
+			return part, nil
+		}
+
+		_ = part.Close()
+	}
+}
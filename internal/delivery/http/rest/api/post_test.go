@@ -0,0 +1,243 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andrsj/go-rabbit-image/internal/delivery/inmemory/broker"
+	"github.com/andrsj/go-rabbit-image/internal/domain/dto"
+	"github.com/andrsj/go-rabbit-image/internal/domain/variant"
+	catalogRepository "github.com/andrsj/go-rabbit-image/internal/infrastructure/catalog/repository"
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/codec"
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/database"
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/exif"
+	fileRepository "github.com/andrsj/go-rabbit-image/internal/infrastructure/file/repository"
+	jobRepository "github.com/andrsj/go-rabbit-image/internal/infrastructure/job/repository"
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/worker/compressor"
+	"github.com/andrsj/go-rabbit-image/internal/services/image/catalog"
+	"github.com/andrsj/go-rabbit-image/internal/services/image/status"
+	"github.com/andrsj/go-rabbit-image/internal/services/image/storage"
+	"github.com/andrsj/go-rabbit-image/internal/services/image/transform"
+	"github.com/andrsj/go-rabbit-image/internal/services/publisher"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// testCacheControl is the Cache-Control of the images of the test API.
+const testCacheControl = "public, max-age=60"
+
+// newTestAPI returns the API with the local storage, the SQLite database and the in-memory broker in a temporary directory.
+func newTestAPI(t *testing.T, policy exif.Policy, quota Quota) *api {
+	t.Helper()
+
+	log := logger.NewLogrusLogger("error")
+	dir := t.TempDir()
+
+	files, err := fileRepository.New(filepath.Join(dir, "images"), 1<<20, log)
+	if err != nil {
+		t.Fatalf("file repository: %v", err)
+	}
+
+	db, err := database.Open(filepath.Join(dir, "images.db"), log)
+	if err != nil {
+		t.Fatalf("database: %v", err)
+	}
+	t.Cleanup(func() { _ = database.Close(db) })
+
+	jobs, err := jobRepository.New(db, log)
+	if err != nil {
+		t.Fatalf("job repository: %v", err)
+	}
+
+	images, err := catalogRepository.New(db, log)
+	if err != nil {
+		t.Fatalf("catalog repository: %v", err)
+	}
+
+	messages := broker.New(broker.Config{Capacity: 100}, log)
+	t.Cleanup(func() { _ = messages.Close() })
+
+	limits := UploadLimits{MaxSize: 1 << 20, Dimensions: codec.Limits{MaxWidth: 1000, MaxHeight: 1000}}
+	fileService := storage.New(files, log)
+	transformService := transform.New(transform.Config{
+		MaxWidth:    limits.Dimensions.MaxWidth,
+		MaxHeight:   limits.Dimensions.MaxHeight,
+		Limits:      limits.Dimensions,
+		Concurrency: 1,
+	}, fileService, files, compressor.New(log), log)
+
+	return New(
+		fileService, status.New(jobs, log), catalog.New(images, log), transformService,
+		publisher.New(messages, log), nil, nil, variant.Set{}, testCacheControl, policy,
+		limits, SignedURLs{}, quota, log,
+	)
+}
+
+// upload sends the image to PublishImage and returns the response with the ID of the image.
+func upload(t *testing.T, a *api, data []byte) (int, string) {
+	t.Helper()
+
+	var body bytes.Buffer
+
+	form := multipart.NewWriter(&body)
+
+	part, err := form.CreateFormFile(formField, "image.png")
+	if err != nil {
+		t.Fatalf("form: %v", err)
+	}
+
+	_, _ = part.Write(data)
+	_ = form.Close()
+
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/send-image", &body)
+	ctx.Request.Header.Set("Content-Type", form.FormDataContentType())
+
+	a.PublishImage(ctx)
+
+	var response struct {
+		ID string `json:"id"`
+	}
+
+	_ = json.Unmarshal(recorder.Body.Bytes(), &response)
+
+	return recorder.Code, response.ID
+}
+
+// pngWithEXIF returns the PNG with the eXIf chunk of the camera model right after IHDR.
+func pngWithEXIF(t *testing.T) []byte {
+	t.Helper()
+
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	// The TIFF structure with the Model tag: "Model" in the entry itself
+	var tiff bytes.Buffer
+	tiff.WriteString("II*\x00\x08\x00\x00\x00\x01\x00")
+	_ = binary.Write(&tiff, binary.LittleEndian, []uint16{0x0110, 2})
+	_ = binary.Write(&tiff, binary.LittleEndian, uint32(4))
+	tiff.WriteString("Cam\x00\x00\x00\x00\x00")
+
+	chunk := append([]byte("eXIf"), tiff.Bytes()...)
+
+	const headerLen = 8 + 4 + 4 + 13 + 4
+
+	var data bytes.Buffer
+	data.Write(encoded.Bytes()[:headerLen])
+	_ = binary.Write(&data, binary.BigEndian, uint32(tiff.Len()))
+	data.Write(chunk)
+	_ = binary.Write(&data, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	data.Write(encoded.Bytes()[headerLen:])
+
+	return data.Bytes()
+}
+
+func TestPublishImageStripsPNGMetadata(t *testing.T) {
+	original := pngWithEXIF(t)
+
+	tests := []struct {
+		policy exif.Policy
+		kept   bool
+	}{
+		{exif.Policy{Mode: exif.PolicyStrip}, false},
+		{exif.Policy{Mode: exif.PolicyWhitelist, Whitelist: []string{"Make"}}, false},
+		{exif.Policy{Mode: exif.PolicyKeep}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy.Mode, func(t *testing.T) {
+			a := newTestAPI(t, test.policy, Quota{})
+
+			code, id := upload(t, a, original)
+			if code != http.StatusOK {
+				t.Fatalf("got status %d, want %d", code, http.StatusOK)
+			}
+
+			stored, _, err := a.imageService.OpenImageFromStorage(id, variant.OriginalKey)
+			if err != nil {
+				t.Fatalf("open the stored original: %v", err)
+			}
+			defer stored.Close()
+
+			data, err := io.ReadAll(stored)
+			if err != nil {
+				t.Fatalf("read the stored original: %v", err)
+			}
+
+			if kept := bytes.Contains(data, []byte("eXIf")); kept != test.kept {
+				t.Fatalf("the stored original has the eXIf chunk: %t, want %t", kept, test.kept)
+			}
+
+			if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+				t.Fatalf("the stored original is broken: %v", err)
+			}
+		})
+	}
+}
+
+// slowUsage is the catalog that returns the usage late, so the concurrent uploads overlap between the check and the registration.
+type slowUsage struct {
+	catalog.Catalog
+}
+
+func (c slowUsage) Usage(uploader string) (*dto.UsageDTO, error) {
+	usage, err := c.Catalog.Usage(uploader)
+	time.Sleep(20 * time.Millisecond)
+
+	return usage, err
+}
+
+func TestPublishImageQuotaUnderConcurrentUploads(t *testing.T) {
+	a := newTestAPI(t, exif.Policy{Mode: exif.PolicyStrip}, Quota{MaxImages: 1})
+	a.catalogService = slowUsage{a.catalogService}
+	original := pngWithEXIF(t)
+
+	const uploads = 8
+
+	codes := make(chan int, uploads)
+
+	var wg sync.WaitGroup
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			code, _ := upload(t, a, original)
+			codes <- code
+		}()
+	}
+
+	wg.Wait()
+	close(codes)
+
+	accepted := 0
+	for code := range codes {
+		switch code {
+		case http.StatusOK:
+			accepted++
+		case http.StatusForbidden:
+		default:
+			t.Fatalf("got status %d, want %d or %d", code, http.StatusOK, http.StatusForbidden)
+		}
+	}
+
+	if accepted != 1 {
+		t.Fatalf("%d uploads are accepted over the quota of 1 image", accepted)
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Quota is the storage quota of every uploader, zero disables the limit.
+type Quota struct {
+	// MaxBytes is the size of the originals with their variants.
+	MaxBytes  int64
+	MaxImages int64
+}
+
+var errQuotaExceeded = errors.New("storage quota exceeded")
+
+/*
+checkQuota checks that the new image of the size fits the quota of the uploader.
+
+The usage is read from the catalog, the variants of the image are counted
+once the worker stores them, so the quota may be exceeded by the variants of the last images.
+The check is only final under lockQuota held until the image is registered.
+*/
+func (a *api) checkQuota(uploader string, size int64) error {
+	if a.quota.MaxBytes == 0 && a.quota.MaxImages == 0 {
+		return nil
+	}
+
+	usage, err := a.catalogService.Usage(uploader)
+	if err != nil {
+		return fmt.Errorf("can't read the usage: %w", err)
+	}
+
+	if a.quota.MaxImages > 0 && usage.Images >= a.quota.MaxImages {
+		return fmt.Errorf("%w: %d of %d images", errQuotaExceeded, usage.Images, a.quota.MaxImages)
+	}
+
+	if a.quota.MaxBytes > 0 && usage.Bytes+size > a.quota.MaxBytes {
+		return fmt.Errorf("%w: %d + %d of %d bytes", errQuotaExceeded, usage.Bytes, size, a.quota.MaxBytes)
+	}
+
+	return nil
+}
+
+// quotaLocks serialize the uploads of every uploader between the quota check and the registration.
+type quotaLocks struct {
+	mu    sync.Mutex
+	locks map[string]*quotaLock
+}
+
+// quotaLock is the lock of the uploader, users counts who holds or waits for it.
+type quotaLock struct {
+	sync.Mutex
+	users int
+}
+
+/*
+lockQuota locks the quota of the uploader and returns the function that unlocks it.
+
+Without the lock the concurrent uploads all read the same usage and pass the check together.
+The lock is held by one instance of the service, the instances sharing the catalog still race.
+Nothing is locked if the quota is disabled.
+*/
+func (a *api) lockQuota(uploader string) func() {
+	if a.quota.MaxBytes == 0 && a.quota.MaxImages == 0 {
+		return func() {}
+	}
+
+	l := &a.quotaLocks
+
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*quotaLock)
+	}
+
+	lock, ok := l.locks[uploader]
+	if !ok {
+		lock = &quotaLock{}
+		l.locks[uploader] = lock
+	}
+	lock.users++
+	l.mu.Unlock()
+
+	lock.Lock()
+
+	return func() {
+		lock.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		// The lock of the uploader is kept only while it's used
+		lock.users--
+		if lock.users == 0 {
+			delete(l.locks, uploader)
+		}
+	}
+}
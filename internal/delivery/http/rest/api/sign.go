@@ -0,0 +1,183 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/andrsj/go-rabbit-image/internal/delivery/http/auth"
+	"github.com/andrsj/go-rabbit-image/internal/domain/variant"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errSigningDisabled = errors.New("signed URLs are disabled")
+	errInvalidTTL      = errors.New("ttl must be a positive duration")
+	errInvalidIP       = errors.New("ip must be an IP address")
+	errWrongClient     = errors.New("the URL is signed for another client")
+)
+
+/*
+SignImageURL method represents POST endpoint that issues the signed URL of the image,
+so the image can be downloaded without the credentials until the URL expires.
+
+	variant  the variant of the image, the original by default
+	ttl      the lifetime of the URL, e.g. 15m (up to the max TTL)
+	ip       the only client address that may use the URL
+*/
+func (a *api) SignImageURL(ctx *gin.Context) {
+	imageID := ctx.Param("id")
+
+	if a.signedURLs.Signer == nil {
+		ctx.AbortWithStatusJSON(
+			http.StatusNotImplemented,
+			gin.H{"error": errSigningDisabled.Error()},
+		)
+
+		return
+	}
+
+	params := imageParams{ID: imageID, Variant: ctx.DefaultQuery("variant", variant.Original)}
+
+	key, err := validateGetImageParams(params, a.variants)
+	if err == nil {
+		err = a.parseGrantParams(ctx)
+	}
+
+	if err != nil {
+		a.logger.Error("SignImageURL: Invalid params", logger.M{
+			"error":    err,
+			"image_id": imageID,
+		})
+		ctx.AbortWithStatusJSON(
+			http.StatusBadRequest,
+			gin.H{"error": fmt.Sprintf("Wrong query parameter: %s", err)},
+		)
+
+		return
+	}
+
+	if !a.authorize(ctx, imageID) {
+		return
+	}
+
+	exists, err := a.imageService.ImageExists(imageID, variant.OriginalKey)
+	if err != nil || !exists {
+		ctx.AbortWithStatusJSON(
+			http.StatusNotFound,
+			gin.H{"error": fmt.Sprintf("Image not found: '%s'", imageID)},
+		)
+
+		return
+	}
+
+	ttl, _ := a.grantTTL(ctx)
+
+	grant := auth.Grant{
+		ImageID:  imageID,
+		Variant:  key,
+		Expires:  time.Now().Add(ttl).Truncate(time.Second),
+		ClientIP: ctx.Query(auth.ParamClientIP),
+	}
+
+	values, err := a.signedURLs.Signer.Sign(grant)
+	if err != nil {
+		a.logger.Error("SignImageURL: Failed to sign the URL", logger.M{
+			"error":    err,
+			"image_id": imageID,
+		})
+		ctx.AbortWithStatusJSON(
+			http.StatusInternalServerError,
+			gin.H{"error": fmt.Sprintf("Can't sign the URL of image '%s'", imageID)},
+		)
+
+		return
+	}
+
+	values.Set("variant", params.Variant)
+
+	a.logger.Info("SignImageURL: URL signed", logger.M{
+		"image_id": imageID,
+		"variant":  params.Variant,
+		"expires":  grant.Expires,
+	})
+	ctx.JSON(http.StatusOK, gin.H{
+		"url":        (&url.URL{Path: "/img/" + imageID, RawQuery: values.Encode()}).String(),
+		"expires_at": grant.Expires,
+	})
+}
+
+// parseGrantParams validates the lifetime and the client address of the signed URL.
+func (a *api) parseGrantParams(ctx *gin.Context) error {
+	if _, err := a.grantTTL(ctx); err != nil {
+		return err
+	}
+
+	if ip, ok := ctx.GetQuery(auth.ParamClientIP); ok && net.ParseIP(ip) == nil {
+		return errInvalidIP
+	}
+
+	return nil
+}
+
+// grantTTL returns the lifetime of the signed URL, it's cut to the max TTL.
+func (a *api) grantTTL(ctx *gin.Context) (time.Duration, error) {
+	raw, ok := ctx.GetQuery("ttl")
+	if !ok {
+		return a.signedURLs.DefaultTTL, nil
+	}
+
+	ttl, err := time.ParseDuration(raw)
+	if err != nil || ttl <= 0 {
+		return 0, errInvalidTTL
+	}
+
+	if ttl > a.signedURLs.MaxTTL {
+		ttl = a.signedURLs.MaxTTL
+	}
+
+	return ttl, nil
+}
+
+/*
+verifySignedURL checks the signature of the request to GET /img/:id
+and returns the expiry of the URL.
+
+The signature binds the ID, the variant, the expiry and the client address (if it's set).
+The address is the client IP of the request, it's taken from X-Forwarded-For only behind the trusted proxies,
+so a client can't claim the bound address, but behind an untrusted proxy all clients share its address.
+*/
+func (a *api) verifySignedURL(ctx *gin.Context, imageID, key string) (time.Time, error) {
+	if a.signedURLs.Signer == nil {
+		return time.Time{}, fmt.Errorf("%w: %s", auth.ErrInvalidSignature, errSigningDisabled)
+	}
+
+	expires, err := strconv.ParseInt(ctx.Query(auth.ParamExpires), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: wrong expiry", auth.ErrInvalidSignature)
+	}
+
+	grant := auth.Grant{
+		ImageID:  imageID,
+		Variant:  key,
+		Expires:  time.Unix(expires, 0),
+		ClientIP: ctx.Query(auth.ParamClientIP),
+	}
+
+	err = a.signedURLs.Signer.Verify(grant, ctx.Query(auth.ParamKeyID), ctx.Query(auth.ParamSignature), time.Now())
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// The addresses are compared as IPs, an IPv6 address has many spellings
+	if grant.ClientIP != "" && !net.ParseIP(grant.ClientIP).Equal(net.ParseIP(ctx.ClientIP())) {
+		return time.Time{}, errWrongClient
+	}
+
+	return grant.Expires, nil
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/job"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// GetImageStatus method represents GET endpoint with the processing state of the image.
+func (a *api) GetImageStatus(ctx *gin.Context) {
+	imageID := ctx.Param("id")
+
+	if !isValidUUID(imageID) {
+		a.logger.Error("GetImageStatus: Invalid image ID", logger.M{"image_id": imageID})
+		ctx.AbortWithStatusJSON(
+			http.StatusBadRequest,
+			gin.H{"error": fmt.Sprintf("Wrong path parameter: %s", errInvalidUUID)},
+		)
+
+		return
+	}
+
+	if !a.authorize(ctx, imageID) {
+		return
+	}
+
+	status, err := a.statusService.GetStatus(imageID)
+	if errors.Is(err, job.ErrNotFound) {
+		ctx.AbortWithStatusJSON(
+			http.StatusNotFound,
+			gin.H{"error": fmt.Sprintf("Image not found: %s", err)},
+		)
+
+		return
+	}
+
+	if err != nil {
+		a.logger.Error("GetImageStatus: Failed to read the job", logger.M{
+			"error":    err,
+			"image_id": imageID,
+		})
+		ctx.AbortWithStatusJSON(
+			http.StatusInternalServerError,
+			gin.H{"error": fmt.Sprintf("Can't read the status of image '%s'", imageID)},
+		)
+
+		return
+	}
+
+	ctx.JSON(http.StatusOK, status)
+}
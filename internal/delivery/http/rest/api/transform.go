@@ -0,0 +1,128 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/andrsj/go-rabbit-image/internal/domain/variant"
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/codec"
+	"github.com/andrsj/go-rabbit-image/internal/services/image/transform"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+	"github.com/gin-gonic/gin"
+)
+
+var errInvalidNumber = errors.New("must be a number")
+
+// GetTransformedImage method represents GET endpoint with the original image transformed on the fly.
+func (a *api) GetTransformedImage(ctx *gin.Context) {
+	imageID := ctx.Param("id")
+
+	params, err := parseTransformParams(ctx)
+	if err == nil && !isValidUUID(imageID) {
+		err = errInvalidUUID
+	}
+
+	if err != nil {
+		a.logger.Error("GetTransformedImage: Invalid params", logger.M{
+			"error":    err,
+			"image_id": imageID,
+		})
+		ctx.AbortWithStatusJSON(
+			http.StatusBadRequest,
+			gin.H{"error": fmt.Sprintf("Wrong query parameter: %s", err)},
+		)
+
+		return
+	}
+
+	if !a.authorize(ctx, imageID) {
+		return
+	}
+
+	img, err := a.transformService.Transform(imageID, params)
+
+	switch {
+	case errors.Is(err, variant.ErrInvalidTransform):
+		ctx.AbortWithStatusJSON(
+			http.StatusBadRequest,
+			gin.H{"error": fmt.Sprintf("Wrong query parameter: %s", err)},
+		)
+
+		return
+	case errors.Is(err, codec.ErrTooLarge):
+		ctx.AbortWithStatusJSON(
+			http.StatusUnprocessableEntity,
+			gin.H{"error": fmt.Sprintf("Can't transform the image: %s", err)},
+		)
+
+		return
+	case errors.Is(err, transform.ErrNotFound):
+		ctx.AbortWithStatusJSON(
+			http.StatusNotFound,
+			gin.H{"error": fmt.Sprintf("Image not found: %s", err)},
+		)
+
+		return
+	case err != nil:
+		a.logger.Error("GetTransformedImage: Failed to transform the image", logger.M{
+			"error":    err,
+			"image_id": imageID,
+		})
+		ctx.AbortWithStatusJSON(
+			http.StatusInternalServerError,
+			gin.H{"error": fmt.Sprintf("Can't transform image '%s'", imageID)},
+		)
+
+		return
+	}
+
+	// The transformed image is cached as the stored ones, its ETag is derived from the hash of the original
+	ctx.Header("Content-Type", img.ContentType)
+
+	if img.Hash != "" {
+		ctx.Header("ETag", strconv.Quote(img.Hash))
+	}
+
+	if cacheControl := a.cacheControlOf(ctx); cacheControl != "" {
+		ctx.Header("Cache-Control", cacheControl)
+	}
+
+	// ServeContent answers the conditional requests with 304 and the range requests with 206
+	http.ServeContent(ctx.Writer, ctx.Request, "", img.ModTime, bytes.NewReader(img.Data))
+}
+
+// parseTransformParams reads the transformation from the query parameters.
+func parseTransformParams(ctx *gin.Context) (variant.Transform, error) {
+	params := variant.Transform{
+		Fit:    ctx.DefaultQuery("fit", variant.FitContain),
+		Format: ctx.Query("format"),
+	}
+
+	numbers := []struct {
+		name  string
+		value *int
+	}{
+		{"w", &params.Width},
+		{"h", &params.Height},
+		{"q", &params.Quality},
+	}
+
+	for _, number := range numbers {
+		raw := ctx.Query(number.name)
+		if raw == "" {
+			continue
+		}
+
+		value, err := strconv.Atoi(raw)
+		if err != nil {
+			return params, fmt.Errorf("%s %w", number.name, errInvalidNumber)
+		}
+
+		*number.value = value
+	}
+
+	return params, nil
+}
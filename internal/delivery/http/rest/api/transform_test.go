@@ -0,0 +1,99 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andrsj/go-rabbit-image/internal/delivery/http/auth"
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/exif"
+	"github.com/gin-gonic/gin"
+)
+
+// transformImage sends the request of the transformation with the query and the headers to GetTransformedImage.
+func transformImage(a *api, id, query string, header http.Header, principal *auth.Principal) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/img/"+id+"/transform?"+query, nil)
+	ctx.Params = gin.Params{{Key: "id", Value: id}}
+
+	for key, values := range header {
+		ctx.Request.Header[key] = values
+	}
+
+	if principal != nil {
+		auth.WithPrincipal(ctx, principal)
+	}
+
+	a.GetTransformedImage(ctx)
+	// The engine writes the status of the responses without the body (304) after the handler
+	ctx.Writer.WriteHeaderNow()
+
+	return recorder
+}
+
+func TestGetTransformedImageHeaders(t *testing.T) {
+	a := newTestAPI(t, exif.Policy{Mode: exif.PolicyStrip}, Quota{})
+
+	code, id := upload(t, a, pngWithEXIF(t))
+	if code != http.StatusOK {
+		t.Fatalf("upload: got status %d", code)
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		principal   *auth.Principal
+		contentType string
+		cache       string
+	}{
+		{"same format", "w=2", nil, "image/png", testCacheControl},
+		// The second request reads the result from the cache
+		{"cached", "w=2", nil, "image/png", testCacheControl},
+		{"jpeg", "w=2&format=jpeg&q=80", nil, "image/jpeg", testCacheControl},
+		{"authenticated", "w=3", &auth.Principal{ID: "key:reader"}, "image/png", "private, max-age=60"},
+	}
+
+	etags := make(map[string]string)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := transformImage(a, id, test.query, nil, test.principal)
+			if response.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", response.Code, response.Body)
+			}
+
+			header := response.Header()
+			if got := header.Get("Content-Type"); got != test.contentType {
+				t.Fatalf("got content type %q, want %q", got, test.contentType)
+			}
+
+			if got := header.Get("Cache-Control"); got != test.cache {
+				t.Fatalf("got Cache-Control %q, want %q", got, test.cache)
+			}
+
+			etag := header.Get("ETag")
+			if etag == "" {
+				t.Fatal("no ETag")
+			}
+
+			// The ETag identifies the transformation of the original
+			if previous, ok := etags[test.query]; ok && previous != etag {
+				t.Fatalf("got ETag %s, want %s of the same transformation", etag, previous)
+			}
+
+			for query, other := range etags {
+				if query != test.query && other == etag {
+					t.Fatalf("the transformations %s and %s have the same ETag %s", query, test.query, etag)
+				}
+			}
+
+			etags[test.query] = etag
+
+			response = transformImage(a, id, test.query, http.Header{"If-None-Match": {etag}}, test.principal)
+			if response.Code != http.StatusNotModified {
+				t.Fatalf("got status %d for the matching ETag, want %d", response.Code, http.StatusNotModified)
+			}
+		})
+	}
+}
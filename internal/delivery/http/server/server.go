@@ -13,11 +13,11 @@ import (
 //
 // I actually don't know how to graceful shutdown the gin.Engine,
 // so I directly shutdown the http.Server.
-func New(h *handler.Handler) *http.Server {
+func New(h *handler.Handler, address string, readHeaderTimeout time.Duration) *http.Server {
 	server := &http.Server{
-		Addr:              ":8080",
+		Addr:              address,
 		Handler:           h.GetGinEngine(),
-		ReadHeaderTimeout: time.Second,
+		ReadHeaderTimeout: readHeaderTimeout,
 	}
 
 	return server
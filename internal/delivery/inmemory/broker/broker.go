@@ -0,0 +1,457 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/andrsj/go-rabbit-image/internal/domain/dto"
+	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/queue"
+	"github.com/andrsj/go-rabbit-image/internal/metrics"
+	"github.com/andrsj/go-rabbit-image/internal/tracing"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// retryCountHeader keeps the number of the retries of the message, like in the RabbitMQ client.
+	retryCountHeader = "x-retry-count"
+	// driverName is the label of the metrics of the broker.
+	driverName = "memory"
+	// queueName is the name of the queue in the traces.
+	queueName = "in-memory"
+)
+
+var (
+	// ErrClosed is returned by Publish after the broker was closed
+	// and is sent to the consumers' error channel, like amqp.ErrClosed for RabbitMQ.
+	ErrClosed = errors.New("in-memory broker is closed")
+
+	errUnknownDelivery = errors.New("unknown delivery tag")
+)
+
+// Config holds the settings of the in-memory broker.
+type Config struct {
+	// Capacity is the maximum number of messages in the queue.
+	Capacity int
+	// MaxRetries is the number of retries before the message goes to the dead letters.
+	MaxRetries int
+	// RetryDelay is the time before the retried message is returned to the queue.
+	RetryDelay time.Duration
+}
+
+// envelope is the in-memory analog of amqp.Publishing.
+type envelope struct {
+	headers     map[string]interface{}
+	contentType string
+	body        []byte
+}
+
+// DeadLetter is a message rejected by the consumer or out of retries.
+type DeadLetter struct {
+	ImageID string
+	Headers map[string]interface{}
+	Reason  string
+}
+
+// inMemoryBroker is a channel-backed implementation of the queue.MessageBroker
+// for tests and single-binary mode (no RabbitMQ is required).
+type inMemoryBroker struct {
+	queue       chan envelope
+	closed      chan struct{}
+	once        sync.Once
+	stopped     chan struct{}
+	stopConsume sync.Once
+
+	mu          sync.Mutex
+	lastTag     uint64
+	unacked     map[uint64]envelope
+	deadLetters []DeadLetter
+
+	maxRetries int
+	retryDelay time.Duration
+	logger     logger.Logger
+}
+
+var (
+	_ queue.MessageBroker = (*inMemoryBroker)(nil)
+	_ queue.StateReporter = (*inMemoryBroker)(nil)
+)
+
+// New creates the broker with a queue that holds at most cfg.Capacity messages.
+func New(cfg Config, log logger.Logger) *inMemoryBroker {
+	log = log.Named("In-memory broker")
+	log.Info("Creating in-memory queue", logger.M{"capacity": cfg.Capacity})
+
+	return &inMemoryBroker{
+		queue:      make(chan envelope, cfg.Capacity),
+		closed:     make(chan struct{}),
+		stopped:    make(chan struct{}),
+		unacked:    make(map[uint64]envelope),
+		maxRetries: cfg.MaxRetries,
+		retryDelay: cfg.RetryDelay,
+		logger:     log,
+	}
+}
+
+// Publish puts the message to the queue.
+//
+// If the queue is full, it waits for free space until the context is done.
+func (b *inMemoryBroker) Publish(ctx context.Context, message []byte, imageID, contentType string) error {
+	b.logger.Info("Publishing a message to in-memory queue", logger.M{
+		"image_id":     imageID,
+		"content_type": contentType,
+	})
+
+	ctx, span := tracing.Tracer().Start(ctx, "publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystem(driverName),
+			semconv.MessagingDestinationName(queueName),
+		),
+	)
+	defer span.End()
+
+	msg := envelope{
+		headers: map[string]interface{}{
+			"id": imageID,
+		},
+		contentType: contentType,
+		body:        message,
+	}
+
+	// The trace context goes next to the ID, like in the RabbitMQ client
+	tracing.Inject(ctx, msg.headers)
+
+	select {
+	case <-b.closed:
+		metrics.Published.WithLabelValues(driverName, metrics.ResultFailure).Inc()
+		tracing.Fail(span, ErrClosed)
+
+		return ErrClosed
+	default:
+	}
+
+	select {
+	case b.queue <- msg:
+	case <-b.closed:
+		metrics.Published.WithLabelValues(driverName, metrics.ResultFailure).Inc()
+		tracing.Fail(span, ErrClosed)
+
+		return ErrClosed
+	case <-ctx.Done():
+		b.logger.Error("Failed to publish a message: queue is full", logger.M{
+			"error":    ctx.Err(),
+			"image_id": imageID,
+		})
+		metrics.Published.WithLabelValues(driverName, metrics.ResultFailure).Inc()
+		tracing.Fail(span, ctx.Err())
+
+		return fmt.Errorf("queue is full: %w", ctx.Err())
+	}
+
+	metrics.Published.WithLabelValues(driverName, metrics.ResultSuccess).Inc()
+
+	b.logger.Info("Successfully published a message to in-memory queue", logger.M{
+		"image_id":     imageID,
+		"content_type": contentType,
+		"queue_length": len(b.queue),
+	})
+
+	return nil
+}
+
+// MustConsumeMessages returns the channel of messages from the queue.
+//
+// Every consumer competes for the messages of the same queue.
+// The messages must be passed to Ack, Retry or Reject.
+// When the broker is closed, the unacknowledged messages are requeued
+// and ErrClosed is sent to the error channel.
+func (b *inMemoryBroker) MustConsumeMessages() (<-chan dto.MessageDTO, <-chan error) {
+	messageCh := make(chan dto.MessageDTO)
+	errorCh := make(chan error, 1)
+
+	go func() {
+		for {
+			// The stop wins over the waiting messages, select would pick any of them
+			select {
+			case <-b.stopped:
+				b.logger.Info("Consuming stopped", nil)
+
+				return
+			default:
+			}
+
+			select {
+			case msg := <-b.queue:
+				imageID, ok := msg.headers["id"].(string)
+				if !ok {
+					b.deadLetter(msg, "message without image ID")
+
+					continue
+				}
+
+				b.logger.Info("Received message from in-memory queue", logger.M{"id": imageID})
+
+				ctx := tracing.Extract(msg.headers)
+				tracing.RecordQueueWait(ctx, msg.headers, queueName)
+
+				tag := b.track(msg)
+
+				select {
+				case messageCh <- dto.MessageDTO{
+					Body:        msg.body,
+					ImageID:     imageID,
+					ContentType: msg.contentType,
+					Headers:     msg.headers,
+					Attempt:     retryCount(msg.headers) + 1,
+					DeliveryTag: tag,
+					Context:     ctx,
+				}:
+				case <-b.stopped:
+					// The message wasn't handed to the consumer
+					if _, err := b.untrack(tag); err == nil {
+						b.requeue(msg)
+					}
+
+					b.logger.Info("Consuming stopped", nil)
+
+					return
+				case <-b.closed:
+					b.requeueUnacked()
+					b.logger.Warn("In-memory broker closed", nil)
+					errorCh <- ErrClosed
+
+					return
+				}
+
+			case <-b.stopped:
+				b.logger.Info("Consuming stopped", nil)
+
+				return
+
+			case <-b.closed:
+				b.requeueUnacked()
+				b.logger.Warn("In-memory broker closed", nil)
+				errorCh <- ErrClosed
+
+				return
+			}
+		}
+	}()
+
+	return messageCh, errorCh
+}
+
+// Ack confirms that the message is processed.
+func (b *inMemoryBroker) Ack(message dto.MessageDTO) error {
+	if _, err := b.untrack(message.DeliveryTag); err != nil {
+		return err
+	}
+
+	b.logger.Debug("Message acknowledged", logger.M{"image_id": message.ImageID})
+
+	return nil
+}
+
+// Retry returns the message to the queue after the retry delay with the increased retry count.
+// After MaxRetries the message is moved to the dead letters.
+func (b *inMemoryBroker) Retry(message dto.MessageDTO, reason error) error {
+	msg, err := b.untrack(message.DeliveryTag)
+	if err != nil {
+		return err
+	}
+
+	retries := retryCount(msg.headers)
+	if retries >= b.maxRetries {
+		b.deadLetter(msg, reason.Error())
+
+		return fmt.Errorf("%w: after %d retries: %s", queue.ErrDeadLettered, retries, reason)
+	}
+
+	headers := make(map[string]interface{}, len(msg.headers)+1)
+	for key, value := range msg.headers {
+		headers[key] = value
+	}
+
+	headers[retryCountHeader] = retries + 1
+	tracing.Inject(message.Context, headers)
+	msg.headers = headers
+
+	b.logger.Info("Retrying the message", logger.M{
+		"image_id": message.ImageID,
+		"retry":    retries + 1,
+		"delay":    b.retryDelay,
+		"reason":   reason,
+	})
+
+	time.AfterFunc(b.retryDelay, func() {
+		b.requeue(msg)
+	})
+
+	return nil
+}
+
+// Reject moves the message to the dead letters.
+func (b *inMemoryBroker) Reject(message dto.MessageDTO, reason error) error {
+	msg, err := b.untrack(message.DeliveryTag)
+	if err != nil {
+		return err
+	}
+
+	b.deadLetter(msg, reason.Error())
+
+	return nil
+}
+
+// Requeue returns the unfinished message to the queue without counting a retry.
+func (b *inMemoryBroker) Requeue(message dto.MessageDTO) error {
+	msg, err := b.untrack(message.DeliveryTag)
+	if err != nil {
+		return err
+	}
+
+	b.logger.Info("Requeueing the message", logger.M{"image_id": message.ImageID})
+	b.requeue(msg)
+
+	return nil
+}
+
+// StopConsuming stops handing the messages to the consumers, they stay in the queue.
+func (b *inMemoryBroker) StopConsuming() error {
+	b.stopConsume.Do(func() {
+		close(b.stopped)
+	})
+
+	return nil
+}
+
+// QueueDepth returns the number of messages waiting in the queue.
+func (b *inMemoryBroker) QueueDepth() (int, error) {
+	return len(b.queue), nil
+}
+
+// DeadLetters returns the copy of the rejected messages.
+func (b *inMemoryBroker) DeadLetters() []DeadLetter {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]DeadLetter(nil), b.deadLetters...)
+}
+
+// State returns connected until the broker is closed, there is no connection to lose.
+func (b *inMemoryBroker) State() queue.State {
+	select {
+	case <-b.closed:
+		return queue.StateClosed
+	default:
+		return queue.StateConnected
+	}
+}
+
+// Close stops the consumers, the messages left in the queue are kept.
+func (b *inMemoryBroker) Close() error {
+	b.once.Do(func() {
+		close(b.closed)
+	})
+
+	return nil
+}
+
+// track registers the message as delivered and not acknowledged, it returns the delivery tag.
+func (b *inMemoryBroker) track(msg envelope) uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastTag++
+	b.unacked[b.lastTag] = msg
+
+	return b.lastTag
+}
+
+// untrack removes the message from the unacknowledged ones.
+func (b *inMemoryBroker) untrack(tag uint64) (envelope, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	msg, ok := b.unacked[tag]
+	if !ok {
+		return envelope{}, fmt.Errorf("%w: %d", errUnknownDelivery, tag)
+	}
+
+	delete(b.unacked, tag)
+
+	return msg, nil
+}
+
+// requeueUnacked puts all unacknowledged messages back to the queue.
+func (b *inMemoryBroker) requeueUnacked() {
+	b.mu.Lock()
+	unacked := b.unacked
+	b.unacked = make(map[uint64]envelope)
+	b.mu.Unlock()
+
+	for _, msg := range unacked {
+		b.requeue(msg)
+	}
+}
+
+/*
+requeue puts the message back to the queue.
+
+If the queue is full, the message waits for free space in the background,
+so the caller (e.g. the consumer that drains the queue) isn't blocked.
+The message didn't fail again, so it's dead-lettered only if the broker is closed before there is space.
+*/
+func (b *inMemoryBroker) requeue(msg envelope) {
+	select {
+	case b.queue <- msg:
+		return
+	default:
+	}
+
+	imageID, _ := msg.headers["id"].(string)
+	b.logger.Warn("Queue is full, the message waits for free space", logger.M{"image_id": imageID})
+
+	go func() {
+		select {
+		case b.queue <- msg:
+		case <-b.closed:
+			b.deadLetter(msg, "can't requeue the message: broker is closed with the full queue")
+		}
+	}()
+}
+
+// deadLetter stores the message in the dead letters.
+func (b *inMemoryBroker) deadLetter(msg envelope, reason string) {
+	imageID, _ := msg.headers["id"].(string)
+
+	b.logger.Warn("Rejecting the message to the dead letters", logger.M{
+		"image_id": imageID,
+		"reason":   reason,
+	})
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Keep the dead letters bounded like the queue, the oldest ones are dropped
+	if len(b.deadLetters) >= cap(b.queue) {
+		b.deadLetters = b.deadLetters[1:]
+	}
+
+	b.deadLetters = append(b.deadLetters, DeadLetter{
+		ImageID: imageID,
+		Headers: msg.headers,
+		Reason:  reason,
+	})
+}
+
+// retryCount reads the retry count from the headers.
+func retryCount(headers map[string]interface{}) int {
+	count, _ := headers[retryCountHeader].(int)
+
+	return count
+}
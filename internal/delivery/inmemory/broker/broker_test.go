@@ -0,0 +1,249 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andrsj/go-rabbit-image/internal/domain/dto"
+	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/queue"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+)
+
+// waitTimeout limits the waits of the tests for the asynchronous deliveries.
+const waitTimeout = 2 * time.Second
+
+var errTest = errors.New("test failure")
+
+func newTestBroker(t *testing.T, cfg Config) *inMemoryBroker {
+	t.Helper()
+
+	b := New(cfg, logger.NewLogrusLogger("error"))
+	t.Cleanup(func() { _ = b.Close() })
+
+	return b
+}
+
+func publish(t *testing.T, b *inMemoryBroker, imageID string) {
+	t.Helper()
+
+	if err := b.Publish(context.Background(), nil, imageID, "image/png"); err != nil {
+		t.Fatalf("Publish(%s): %v", imageID, err)
+	}
+}
+
+func receive(t *testing.T, messages <-chan dto.MessageDTO) dto.MessageDTO {
+	t.Helper()
+
+	select {
+	case message := <-messages:
+		return message
+	case <-time.After(waitTimeout):
+		t.Fatal("no message is delivered")
+
+		return dto.MessageDTO{}
+	}
+}
+
+func expectNoMessage(t *testing.T, messages <-chan dto.MessageDTO, wait time.Duration) {
+	t.Helper()
+
+	select {
+	case message := <-messages:
+		t.Fatalf("unexpected message %s", message.ImageID)
+	case <-time.After(wait):
+	}
+}
+
+// waitDepth waits until the queue holds depth messages.
+func waitDepth(t *testing.T, b *inMemoryBroker, depth int) {
+	t.Helper()
+
+	deadline := time.Now().Add(waitTimeout)
+	for time.Now().Before(deadline) {
+		if got, _ := b.QueueDepth(); got == depth {
+			return
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	got, _ := b.QueueDepth()
+	t.Fatalf("queue depth is %d, want %d", got, depth)
+}
+
+func TestAck(t *testing.T) {
+	b := newTestBroker(t, Config{Capacity: 10, MaxRetries: 3})
+	messages, _ := b.MustConsumeMessages()
+
+	publish(t, b, "a")
+
+	message := receive(t, messages)
+	if message.ImageID != "a" || message.Attempt != 1 {
+		t.Fatalf("got %s attempt %d, want a attempt 1", message.ImageID, message.Attempt)
+	}
+
+	if err := b.Ack(message); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+
+	if err := b.Ack(message); !errors.Is(err, errUnknownDelivery) {
+		t.Fatalf("second Ack: got %v, want %v", err, errUnknownDelivery)
+	}
+
+	expectNoMessage(t, messages, 50*time.Millisecond)
+}
+
+func TestRetryWaitsForDelay(t *testing.T) {
+	const delay = 100 * time.Millisecond
+
+	b := newTestBroker(t, Config{Capacity: 10, MaxRetries: 3, RetryDelay: delay})
+	messages, _ := b.MustConsumeMessages()
+
+	publish(t, b, "a")
+
+	start := time.Now()
+	if err := b.Retry(receive(t, messages), errTest); err != nil {
+		t.Fatalf("Retry: %v", err)
+	}
+
+	message := receive(t, messages)
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Fatalf("retried after %s, want at least %s", elapsed, delay)
+	}
+
+	if message.Attempt != 2 || retryCount(message.Headers) != 1 {
+		t.Fatalf("got attempt %d with %d retries, want attempt 2 with 1 retry",
+			message.Attempt, retryCount(message.Headers))
+	}
+}
+
+func TestRetryDeadLettersAfterMaxRetries(t *testing.T) {
+	b := newTestBroker(t, Config{Capacity: 10, MaxRetries: 1})
+	messages, _ := b.MustConsumeMessages()
+
+	publish(t, b, "a")
+
+	if err := b.Retry(receive(t, messages), errTest); err != nil {
+		t.Fatalf("first Retry: %v", err)
+	}
+
+	err := b.Retry(receive(t, messages), errTest)
+	if !errors.Is(err, queue.ErrDeadLettered) {
+		t.Fatalf("second Retry: got %v, want %v", err, queue.ErrDeadLettered)
+	}
+
+	deadLetters := b.DeadLetters()
+	if len(deadLetters) != 1 || deadLetters[0].ImageID != "a" || deadLetters[0].Reason != errTest.Error() {
+		t.Fatalf("got dead letters %+v, want one of a with %q", deadLetters, errTest)
+	}
+
+	expectNoMessage(t, messages, 50*time.Millisecond)
+}
+
+func TestReject(t *testing.T) {
+	b := newTestBroker(t, Config{Capacity: 10, MaxRetries: 3})
+	messages, _ := b.MustConsumeMessages()
+
+	publish(t, b, "a")
+
+	if err := b.Reject(receive(t, messages), errTest); err != nil {
+		t.Fatalf("Reject: %v", err)
+	}
+
+	if deadLetters := b.DeadLetters(); len(deadLetters) != 1 || deadLetters[0].ImageID != "a" {
+		t.Fatalf("got dead letters %+v, want one of a", deadLetters)
+	}
+}
+
+func TestPublishToFullQueue(t *testing.T) {
+	b := newTestBroker(t, Config{Capacity: 1})
+
+	publish(t, b, "a")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := b.Publish(ctx, nil, "b", "image/png"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Publish to the full queue: got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRetryWaitsForSpaceInFullQueue(t *testing.T) {
+	b := newTestBroker(t, Config{Capacity: 1, MaxRetries: 3})
+	messages, _ := b.MustConsumeMessages()
+
+	publish(t, b, "a")
+	a := receive(t, messages)
+
+	// The consumer holds "b" and "c" fills the queue
+	publish(t, b, "b")
+	publish(t, b, "c")
+	waitDepth(t, b, 1)
+
+	if err := b.Retry(a, errTest); err != nil {
+		t.Fatalf("Retry: %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if deadLetters := b.DeadLetters(); len(deadLetters) != 0 {
+		t.Fatalf("the retried message is dead-lettered: %+v", deadLetters)
+	}
+
+	for _, want := range []string{"b", "c", "a"} {
+		message := receive(t, messages)
+		if message.ImageID != want {
+			t.Fatalf("got %s, want %s", message.ImageID, want)
+		}
+
+		if err := b.Ack(message); err != nil {
+			t.Fatalf("Ack(%s): %v", want, err)
+		}
+	}
+}
+
+func TestStopConsumingKeepsMessages(t *testing.T) {
+	b := newTestBroker(t, Config{Capacity: 10})
+	messages, _ := b.MustConsumeMessages()
+
+	if err := b.StopConsuming(); err != nil {
+		t.Fatalf("StopConsuming: %v", err)
+	}
+
+	publish(t, b, "a")
+	expectNoMessage(t, messages, 50*time.Millisecond)
+	waitDepth(t, b, 1)
+}
+
+func TestCloseRequeuesUnacked(t *testing.T) {
+	b := newTestBroker(t, Config{Capacity: 10})
+	messages, errs := b.MustConsumeMessages()
+
+	publish(t, b, "a")
+	receive(t, messages)
+
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrClosed) {
+			t.Fatalf("got %v, want %v", err, ErrClosed)
+		}
+	case <-time.After(waitTimeout):
+		t.Fatal("no error after Close")
+	}
+
+	waitDepth(t, b, 1)
+
+	if state := b.State(); state != queue.StateClosed {
+		t.Fatalf("got state %s, want %s", state, queue.StateClosed)
+	}
+
+	if err := b.Publish(context.Background(), nil, "b", "image/png"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Publish after Close: got %v, want %v", err, ErrClosed)
+	}
+}
@@ -3,73 +3,210 @@ package client
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/andrsj/go-rabbit-image/internal/domain/dto"
 	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/queue"
+	"github.com/andrsj/go-rabbit-image/internal/metrics"
+	"github.com/andrsj/go-rabbit-image/internal/tracing"
 	"github.com/andrsj/go-rabbit-image/pkg/logger"
 	amqp "github.com/rabbitmq/amqp091-go"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const (
+	// retryCountHeader keeps the number of the retries of the message.
+	retryCountHeader = "x-retry-count"
+	// lastErrorHeader keeps the reason of the last retry of the message.
+	lastErrorHeader = "x-last-error"
+	// driverName is the label of the metrics of the client.
+	driverName = "rabbitmq"
+)
+
+// Config holds the settings of the RabbitMQ client.
+type Config struct {
+	// URL of the RabbitMQ instance.
+	URL string
+	// Queue is the name of the main queue,
+	// the retry and dead-letter queues are named "<Queue>.retry" and "<Queue>.dead".
+	Queue string
+	// MaxRetries is the number of retries before the message goes to the dead-letter queue.
+	MaxRetries int
+	// RetryDelay is the time the message waits in the retry queue.
+	RetryDelay time.Duration
+	// ReconnectMinDelay and ReconnectMaxDelay bound the exponential backoff of the reconnections.
+	ReconnectMinDelay time.Duration
+	ReconnectMaxDelay time.Duration
+	// PublishWait makes Publish wait for the reconnection (at most PublishTimeout)
+	// instead of failing fast with ErrNotConnected.
+	PublishWait    bool
+	PublishTimeout time.Duration
+	// Prefetch is the number of unacknowledged messages RabbitMQ delivers to the consumer,
+	// it should match the number of images the worker processes at the same time.
+	Prefetch int
+}
+
+// delivery links the tag given to the worker with the channel that received the message.
+type delivery struct {
+	generation uint64
+	tag        uint64
+}
+
 type rabbitMQ struct {
-	conn      *amqp.Connection
-	channel   *amqp.Channel
-	MainQueue string
-	logger    logger.Logger
+	url            string
+	MainQueue      string
+	RetryQueue     string
+	DeadQueue      string
+	DeadLetters    string
+	maxRetries     int
+	retryDelay     time.Duration
+	minDelay       time.Duration
+	maxDelay       time.Duration
+	publishWait    bool
+	publishTimeout time.Duration
+	prefetch       int
+
+	// mu guards the connection, the channel and the state, they are replaced on reconnection
+	mu         sync.RWMutex
+	conn       *amqp.Connection
+	channel    *amqp.Channel
+	generation uint64
+	state      queue.State
+	ready      chan struct{}
+	done       chan struct{}
+
+	// deliveries maps the tags given to the worker to the tags of the current channel
+	deliveries map[uint64]delivery
+	lastTag    uint64
+
+	// consumers are the tags of the active consumers, stopped is closed by StopConsuming
+	consumers    map[string]struct{}
+	lastConsumer int
+	stopped      chan struct{}
+	stopOnce     sync.Once
+
+	logger logger.Logger
 }
 
-var _ queue.MessageBroker = (*rabbitMQ)(nil)
+var (
+	_ queue.MessageBroker = (*rabbitMQ)(nil)
+	_ queue.StateReporter = (*rabbitMQ)(nil)
+)
 
 // New function is responsible for creating a new instance
 // of the rabbitMQ struct, which represents a client for connecting to RabbitMQ.
 //
-// The function takes three arguments: the URL of the RabbitMQ instance,
-// the name of the queue to be used, and a logger object to be used for logging messages.
-func New(url, queue_name string, log logger.Logger) (*rabbitMQ, error) {
+// The function takes two arguments: the settings of the client (URL, queue name, retries)
+// and a logger object to be used for logging messages.
+//
+// The first connection must succeed, after that the client reconnects
+// in the background every time the connection or the channel is closed.
+func New(cfg Config, log logger.Logger) (*rabbitMQ, error) {
 	log = log.Named("RabbitMQ client")
 
-	// Attempts to establish a connection to RabbitMQ
-	log.Info("Establishing connection to RabbitMQ...", logger.M{"URL": url, "queue_name": queue_name})
-	conn, err := amqp.Dial(url)
-	if err != nil {
-		log.Error("Failed to connect to RabbitMQ", logger.M{"error": err})
+	r := &rabbitMQ{
+		url:            cfg.URL,
+		MainQueue:      cfg.Queue,
+		RetryQueue:     cfg.Queue + ".retry",
+		DeadQueue:      cfg.Queue + ".dead",
+		DeadLetters:    cfg.Queue + ".dlx",
+		maxRetries:     cfg.MaxRetries,
+		retryDelay:     cfg.RetryDelay,
+		minDelay:       cfg.ReconnectMinDelay,
+		maxDelay:       cfg.ReconnectMaxDelay,
+		publishWait:    cfg.PublishWait,
+		publishTimeout: cfg.PublishTimeout,
+		prefetch:       cfg.Prefetch,
+		ready:          make(chan struct{}),
+		done:           make(chan struct{}),
+		consumers:      make(map[string]struct{}),
+		stopped:        make(chan struct{}),
+		logger:         log,
+	}
+
+	if err := r.connect(); err != nil {
 		return nil, err
 	}
-	log.Info("Connection established successfully", nil)
 
-	// Attempts to open a new channel
-	log.Info("Opening a new channel...", nil)
-	channel, err := conn.Channel()
+	go r.supervise()
+
+	return r, nil
+}
+
+/*
+declareTopology declares the queues used by the client:
+
+  - the main queue, the rejected messages go from it to the dead-letter exchange;
+  - the retry queue, the messages wait there for the retry delay
+    and then go back to the main queue through the default exchange;
+  - the dead-letter exchange and the dead-letter queue bound to it for the poison messages.
+*/
+func (r *rabbitMQ) declareTopology(channel *amqp.Channel) error {
+	r.logger.Info("Declaring the dead-letter exchange and queue...", nil)
+
+	err := channel.ExchangeDeclare(r.DeadLetters, amqp.ExchangeDirect, true, false, false, false, nil)
+	if err != nil {
+		r.logger.Error("Failed to declare the dead-letter exchange", logger.M{"error": err})
+		return err
+	}
+
+	_, err = channel.QueueDeclare(r.DeadQueue, true, false, false, false, nil)
+	if err != nil {
+		r.logger.Error("Failed to declare the dead-letter queue", logger.M{"error": err})
+		return err
+	}
+
+	err = channel.QueueBind(r.DeadQueue, r.MainQueue, r.DeadLetters, false, nil)
 	if err != nil {
-		log.Error("Failed to open a new channel", logger.M{"error": err})
-		return nil, fmt.Errorf("can't connect to RabbitMQ:%s", err)
+		r.logger.Error("Failed to bind the dead-letter queue", logger.M{"error": err})
+		return err
 	}
-	log.Info("New channel opened successfully", nil)
 
 	// Attempts to declare the main queue
-	log.Info("Declaring the main queue...", nil)
+	r.logger.Info("Declaring the main queue...", nil)
 	_, err = channel.QueueDeclare(
-		queue_name,
+		r.MainQueue,
+		true,
 		false,
+		false,
+		false,
+		amqp.Table{
+			"x-dead-letter-exchange": r.DeadLetters,
+		},
+	)
+	if err != nil {
+		r.logger.Error("Failed to declare the main queue", logger.M{"error": err})
+		return err
+	}
+	r.logger.Info("Main queue declared successfully", nil)
+
+	r.logger.Info("Declaring the retry queue...", nil)
+	_, err = channel.QueueDeclare(
+		r.RetryQueue,
 		true,
 		false,
 		false,
-		nil,
+		false,
+		amqp.Table{
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": r.MainQueue,
+		},
 	)
 	if err != nil {
-		log.Error("Failed to declare the main queue", logger.M{"error": err})
-		return nil, err
+		r.logger.Error("Failed to declare the retry queue", logger.M{"error": err})
+		return err
 	}
-	log.Info("Main queue declared successfully", nil)
 
-	return &rabbitMQ{
-		conn:      conn,
-		channel:   channel,
-		MainQueue: queue_name,
-		logger:    log,
-	}, nil
+	return nil
 }
 
-// Publish publishes a message to RabbitMQ
+// Publish publishes a message to RabbitMQ.
+//
+// While the client is reconnecting, it waits for the connection until the context is done
+// (at most PublishTimeout) or fails fast with ErrNotConnected, depending on the PublishWait setting.
 func (r *rabbitMQ) Publish(ctx context.Context, message []byte, image_id, contentType string) error {
 	// Logging that the message is being published
 	r.logger.Info("Publishing a message to RabbitMQ", logger.M{
@@ -77,18 +214,44 @@ func (r *rabbitMQ) Publish(ctx context.Context, message []byte, image_id, conten
 		"image_id":     image_id,
 		"content_type": contentType,
 	})
+
+	ctx, span := tracing.Tracer().Start(ctx, "publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystem(driverName),
+			semconv.MessagingDestinationName(r.MainQueue),
+		),
+	)
+	defer span.End()
+
+	// The trace context goes next to the ID, so the worker continues the trace
+	headers := amqp.Table{"id": image_id}
+	tracing.Inject(ctx, headers)
+
+	waitCtx, cancel := context.WithTimeout(ctx, r.publishTimeout)
+	defer cancel()
+
+	channel, _, err := r.waitReady(waitCtx, r.publishWait)
+	if err != nil {
+		r.logger.Error("Failed to publish a message to RabbitMQ", logger.M{
+			"error": err,
+		})
+		metrics.Published.WithLabelValues(driverName, metrics.ResultFailure).Inc()
+		tracing.Fail(span, err)
+		return err
+	}
+
 	// Publishing the message to RabbitMQ with given context, image ID, content type
-	err := r.channel.PublishWithContext(ctx,
+	err = channel.PublishWithContext(ctx,
 		"",
 		r.MainQueue,
 		false,
 		false,
 		amqp.Publishing{
-			Headers: map[string]interface{}{
-				"id": image_id,
-			},
-			ContentType: contentType,
-			Body:        message,
+			Headers:      headers,
+			ContentType:  contentType,
+			DeliveryMode: amqp.Persistent,
+			Body:         message,
 		},
 	)
 	// If there's an error, logging that publishing the message failed
@@ -96,9 +259,13 @@ func (r *rabbitMQ) Publish(ctx context.Context, message []byte, image_id, conten
 		r.logger.Error("Failed to publish a message to RabbitMQ", logger.M{
 			"error": err,
 		})
+		metrics.Published.WithLabelValues(driverName, metrics.ResultFailure).Inc()
+		tracing.Fail(span, err)
 		return err
 	}
 
+	metrics.Published.WithLabelValues(driverName, metrics.ResultSuccess).Inc()
+
 	// Logging that the message has been published successfully
 	r.logger.Info("Successfully published a message to RabbitMQ", logger.M{
 		"queue_name":   r.MainQueue,
@@ -109,43 +276,382 @@ func (r *rabbitMQ) Publish(ctx context.Context, message []byte, image_id, conten
 }
 
 // MustConsumeMessages method is to consume messages from the main queue and return them to the caller.
+//
+// The messages are consumed with manual acknowledgements:
+// every message must be passed to Ack, Retry or Reject.
+//
+// The consuming is resumed after every reconnection until StopConsuming is called,
+// the error channel receives amqp.ErrClosed only when the client is closed.
 func (r *rabbitMQ) MustConsumeMessages() (<-chan dto.MessageDTO, <-chan error) {
-	// Consume messages from the main queue
-	msgs, err := r.channel.Consume(
-		r.MainQueue,
-		"",
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
-		r.logger.Error("Error consuming messages: %v", logger.M{"error": err})
-		panic(err)
-	}
-
 	// Create channels for receiving messages and errors
 	messageCh := make(chan dto.MessageDTO)
 	errorCh := make(chan error, 1)
 
+	r.mu.Lock()
+	r.lastConsumer++
+	consumerTag := fmt.Sprintf("%s-consumer-%d", r.MainQueue, r.lastConsumer)
+	r.mu.Unlock()
+
 	go func() {
-		// Iterate over messages received from the main queue
-		for msg := range msgs {
-			r.logger.Info("Received message from RabbitMQ", logger.M{"id": msg.Headers["id"].(string)})
-
-			// Send the received message to the messageCh channel
-			messageCh <- dto.MessageDTO{
-				Body:        msg.Body,
-				ImageID:     msg.Headers["id"].(string),
-				ContentType: msg.ContentType,
+		for {
+			channel, generation, err := r.waitReady(context.Background(), true)
+			if err != nil {
+				// Notify the errorCh channel that the client is closed
+				r.logger.Warn("RabbitMQ client closed", nil)
+				errorCh <- err
+
+				return
 			}
-		}
 
-		// Notify the errorCh channel that the RabbitMQ channel is closed
-		r.logger.Warn("RabbitMQ channel closed", nil)
-		errorCh <- amqp.ErrClosed
+			select {
+			case <-r.stopped:
+				r.logger.Info("Consuming stopped", logger.M{"consumer": consumerTag})
+
+				return
+			default:
+			}
+
+			// Consume messages from the main queue
+			msgs, err := channel.Consume(
+				r.MainQueue,
+				consumerTag,
+				false,
+				false,
+				false,
+				false,
+				nil,
+			)
+			if err != nil {
+				// The channel is broken, the supervisor will restore it
+				r.logger.Error("Error consuming messages", logger.M{"error": err})
+				r.waitReconnecting(generation)
+
+				continue
+			}
+
+			r.mu.Lock()
+			r.consumers[consumerTag] = struct{}{}
+			r.mu.Unlock()
+
+			r.logger.Info("Consuming messages", logger.M{"queue_name": r.MainQueue, "consumer": consumerTag})
+			r.forward(msgs, generation, messageCh)
+
+			r.mu.Lock()
+			delete(r.consumers, consumerTag)
+			r.mu.Unlock()
+
+			r.logger.Warn("RabbitMQ channel closed, waiting for reconnection", nil)
+		}
 	}()
 
 	return messageCh, errorCh
 }
+
+// QueueDepth returns the number of messages ready in the main queue.
+func (r *rabbitMQ) QueueDepth() (int, error) {
+	channel, _, err := r.waitReady(context.Background(), false)
+	if err != nil {
+		return 0, err
+	}
+
+	// The passive declaration only reads the state of the existing queue
+	state, err := channel.QueueDeclarePassive(r.MainQueue, true, false, false, false, amqp.Table{
+		"x-dead-letter-exchange": r.DeadLetters,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("inspect queue: %w", err)
+	}
+
+	return state.Messages, nil
+}
+
+// forward sends the deliveries of one channel to the messageCh until the channel is closed.
+func (r *rabbitMQ) forward(msgs <-chan amqp.Delivery, generation uint64, messageCh chan<- dto.MessageDTO) {
+	// Iterate over messages received from the main queue
+	for msg := range msgs {
+		imageID, ok := msg.Headers["id"].(string)
+		if !ok {
+			// The message can't be processed ever, so it goes to the dead-letter queue
+			r.logger.Error("Received message without image ID", logger.M{"delivery_tag": msg.DeliveryTag})
+
+			if err := msg.Nack(false, false); err != nil {
+				r.logger.Error("Failed to reject the message", logger.M{"error": err})
+			}
+
+			continue
+		}
+
+		r.logger.Info("Received message from RabbitMQ", logger.M{"id": imageID})
+
+		ctx := tracing.Extract(msg.Headers)
+		tracing.RecordQueueWait(ctx, msg.Headers, r.MainQueue)
+
+		message := dto.MessageDTO{
+			Body:        msg.Body,
+			ImageID:     imageID,
+			ContentType: msg.ContentType,
+			Headers:     msg.Headers,
+			Attempt:     retryCount(msg.Headers) + 1,
+			DeliveryTag: r.track(generation, msg.DeliveryTag),
+			Context:     ctx,
+		}
+
+		// Send the received message to the messageCh channel
+		select {
+		case messageCh <- message:
+		case <-r.stopped:
+			// The prefetched message wasn't handed to the worker, so it goes back to the queue
+			if err := r.Requeue(message); err != nil {
+				r.logger.Error("Failed to requeue the prefetched message", logger.M{"error": err})
+			}
+		}
+	}
+}
+
+// StopConsuming cancels the consumers, so RabbitMQ stops delivering new messages.
+//
+// The prefetched messages that weren't handed to the worker are requeued,
+// the delivered ones still must be acknowledged or requeued by the worker.
+func (r *rabbitMQ) StopConsuming() error {
+	r.stopOnce.Do(func() {
+		close(r.stopped)
+	})
+
+	r.mu.RLock()
+	channel, state := r.channel, r.state
+	tags := make([]string, 0, len(r.consumers))
+	for tag := range r.consumers {
+		tags = append(tags, tag)
+	}
+	r.mu.RUnlock()
+
+	if state != queue.StateConnected {
+		// The broker has already requeued everything of the closed channel
+		return nil
+	}
+
+	for _, tag := range tags {
+		r.logger.Info("Cancelling the consumer", logger.M{"consumer": tag})
+
+		if err := channel.Cancel(tag, false); err != nil {
+			return fmt.Errorf("cancel consumer '%s': %w", tag, err)
+		}
+	}
+
+	return nil
+}
+
+// waitReconnecting waits until the connection of the given generation is replaced or the client is closed.
+func (r *rabbitMQ) waitReconnecting(generation uint64) {
+	ticker := time.NewTicker(r.minDelay)
+	defer ticker.Stop()
+
+	for {
+		r.mu.RLock()
+		current, state := r.generation, r.state
+		r.mu.RUnlock()
+
+		if current != generation || state != queue.StateConnected {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// track registers the delivery of the channel and returns the tag given to the worker.
+func (r *rabbitMQ) track(generation, tag uint64) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastTag++
+
+	if generation == r.generation {
+		r.deliveries[r.lastTag] = delivery{generation: generation, tag: tag}
+	}
+
+	return r.lastTag
+}
+
+// untrack returns the channel and the tag of the delivery,
+// it fails if the channel was closed since the message was received.
+func (r *rabbitMQ) untrack(message dto.MessageDTO) (*amqp.Channel, uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d, ok := r.deliveries[message.DeliveryTag]
+	if !ok || d.generation != r.generation || r.state != queue.StateConnected {
+		return nil, 0, fmt.Errorf("%w: image '%s'", errStaleDelivery, message.ImageID)
+	}
+
+	delete(r.deliveries, message.DeliveryTag)
+
+	return r.channel, d.tag, nil
+}
+
+// Ack confirms that the message is processed.
+func (r *rabbitMQ) Ack(message dto.MessageDTO) error {
+	channel, tag, err := r.untrack(message)
+	if err == nil {
+		err = channel.Ack(tag, false)
+	}
+
+	if err != nil {
+		r.logger.Error("Failed to acknowledge the message", logger.M{
+			"error":    err,
+			"image_id": message.ImageID,
+		})
+
+		return fmt.Errorf("ack: %w", err)
+	}
+
+	r.logger.Debug("Message acknowledged", logger.M{"image_id": message.ImageID})
+
+	return nil
+}
+
+// Retry publishes a copy of the message with the increased retry count to the retry queue
+// and acknowledges the original. After MaxRetries the message is rejected to the dead-letter queue.
+func (r *rabbitMQ) Retry(message dto.MessageDTO, reason error) error {
+	retries := retryCount(message.Headers)
+	if retries >= r.maxRetries {
+		r.logger.Warn("Message has no retries left", logger.M{
+			"image_id": message.ImageID,
+			"retries":  retries,
+		})
+
+		if err := r.Reject(message, reason); err != nil {
+			return err
+		}
+
+		return fmt.Errorf("%w: after %d retries: %s", queue.ErrDeadLettered, retries, reason)
+	}
+
+	channel, tag, err := r.untrack(message)
+	if err != nil {
+		r.logger.Error("Failed to retry the message", logger.M{
+			"error":    err,
+			"image_id": message.ImageID,
+		})
+
+		return fmt.Errorf("retry: %w", err)
+	}
+
+	headers := amqp.Table{}
+	for key, value := range message.Headers {
+		headers[key] = value
+	}
+
+	headers[retryCountHeader] = int32(retries + 1)
+	headers[lastErrorHeader] = reason.Error()
+	tracing.Inject(message.Context, headers)
+
+	r.logger.Info("Retrying the message", logger.M{
+		"image_id": message.ImageID,
+		"retry":    retries + 1,
+		"delay":    r.retryDelay,
+		"reason":   reason,
+	})
+
+	// The message expires in the retry queue and goes back to the main queue
+	err = channel.PublishWithContext(context.Background(),
+		"",
+		r.RetryQueue,
+		false,
+		false,
+		amqp.Publishing{
+			Headers:      headers,
+			ContentType:  message.ContentType,
+			DeliveryMode: amqp.Persistent,
+			Expiration:   strconv.FormatInt(r.retryDelay.Milliseconds(), 10),
+			Body:         message.Body,
+		},
+	)
+	if err != nil {
+		r.logger.Error("Failed to publish the message to the retry queue", logger.M{
+			"error":    err,
+			"image_id": message.ImageID,
+		})
+
+		// Put the original back to the main queue, so the message isn't lost
+		if nackErr := channel.Nack(tag, false, true); nackErr != nil {
+			r.logger.Error("Failed to requeue the message", logger.M{"error": nackErr})
+		}
+
+		return fmt.Errorf("retry: %w", err)
+	}
+
+	if err := channel.Ack(tag, false); err != nil {
+		r.logger.Error("Failed to acknowledge the retried message", logger.M{
+			"error":    err,
+			"image_id": message.ImageID,
+		})
+
+		return fmt.Errorf("retry: %w", err)
+	}
+
+	return nil
+}
+
+// Requeue returns the unfinished message to the main queue without counting a retry.
+func (r *rabbitMQ) Requeue(message dto.MessageDTO) error {
+	channel, tag, err := r.untrack(message)
+	if err == nil {
+		err = channel.Nack(tag, false, true)
+	}
+
+	if err != nil {
+		r.logger.Error("Failed to requeue the message", logger.M{
+			"error":    err,
+			"image_id": message.ImageID,
+		})
+
+		return fmt.Errorf("requeue: %w", err)
+	}
+
+	r.logger.Info("Message requeued", logger.M{"image_id": message.ImageID})
+
+	return nil
+}
+
+// Reject sends the message to the dead-letter queue.
+func (r *rabbitMQ) Reject(message dto.MessageDTO, reason error) error {
+	r.logger.Warn("Rejecting the message to the dead-letter queue", logger.M{
+		"image_id": message.ImageID,
+		"reason":   reason,
+	})
+
+	channel, tag, err := r.untrack(message)
+	if err == nil {
+		err = channel.Nack(tag, false, false)
+	}
+
+	if err != nil {
+		r.logger.Error("Failed to reject the message", logger.M{
+			"error":    err,
+			"image_id": message.ImageID,
+		})
+
+		return fmt.Errorf("reject: %w", err)
+	}
+
+	return nil
+}
+
+// retryCount reads the retry count from the headers, AMQP may return it in different integer types.
+func retryCount(headers map[string]interface{}) int {
+	switch value := headers[retryCountHeader].(type) {
+	case int32:
+		return int(value)
+	case int64:
+		return int(value)
+	case int:
+		return value
+	default:
+		return 0
+	}
+}
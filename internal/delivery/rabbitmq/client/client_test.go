@@ -0,0 +1,125 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andrsj/go-rabbit-image/internal/domain/dto"
+	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/queue"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+)
+
+// consumerTag is the tag of the first consumer of the client of the "Queue".
+const consumerTag = event("consume:Queue-consumer-1")
+
+func newTestClient(t *testing.T, broker *fakeBroker) *rabbitMQ {
+	t.Helper()
+
+	client, err := New(Config{
+		URL:               broker.url(),
+		Queue:             "Queue",
+		MaxRetries:        3,
+		RetryDelay:        time.Second,
+		ReconnectMinDelay: 10 * time.Millisecond,
+		ReconnectMaxDelay: 50 * time.Millisecond,
+		PublishWait:       true,
+		PublishTimeout:    time.Second,
+		Prefetch:          1,
+	}, logger.NewLogrusLogger("error"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	t.Cleanup(func() { _ = client.Close() })
+
+	return client
+}
+
+func receive(t *testing.T, messages <-chan dto.MessageDTO) dto.MessageDTO {
+	t.Helper()
+
+	select {
+	case message := <-messages:
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message is delivered")
+
+		return dto.MessageDTO{}
+	}
+}
+
+func TestConsumeAndStopConsuming(t *testing.T) {
+	broker := newFakeBroker(t)
+	client := newTestClient(t, broker)
+
+	messages, errs := client.MustConsumeMessages()
+	broker.waitEvent(consumerTag)
+
+	broker.deliver("a")
+
+	message := receive(t, messages)
+	if message.ImageID != "a" || message.Attempt != 1 {
+		t.Fatalf("got %s attempt %d, want a attempt 1", message.ImageID, message.Attempt)
+	}
+
+	if err := client.Ack(message); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+
+	broker.waitEvent("ack:1")
+
+	if err := client.StopConsuming(); err != nil {
+		t.Fatalf("StopConsuming: %v", err)
+	}
+
+	broker.waitEvent("cancel:Queue-consumer-1")
+
+	// StopConsuming may be called again, e.g. by the worker and on Close
+	if err := client.StopConsuming(); err != nil {
+		t.Fatalf("second StopConsuming: %v", err)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	select {
+	case err := <-errs:
+		t.Fatalf("the stopped consumer got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestConsumeResumesAfterReconnection(t *testing.T) {
+	broker := newFakeBroker(t)
+	client := newTestClient(t, broker)
+
+	messages, _ := client.MustConsumeMessages()
+	broker.waitEvent(consumerTag)
+
+	broker.dropConnections()
+
+	// The consumer is registered again on the new connection
+	broker.waitEvent(consumerTag)
+
+	if state := client.State(); state != queue.StateConnected {
+		t.Fatalf("got state %s, want %s", state, queue.StateConnected)
+	}
+
+	broker.deliver("b")
+
+	message := receive(t, messages)
+	if message.ImageID != "b" {
+		t.Fatalf("got %s, want b", message.ImageID)
+	}
+
+	if err := client.Ack(message); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+
+	if err := client.StopConsuming(); err != nil {
+		t.Fatalf("StopConsuming: %v", err)
+	}
+
+	broker.waitEvent("cancel:Queue-consumer-1")
+}
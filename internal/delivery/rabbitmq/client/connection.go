@@ -0,0 +1,217 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/queue"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	// ErrNotConnected is returned by Publish in the fail-fast mode while the client is reconnecting.
+	ErrNotConnected = errors.New("not connected to RabbitMQ")
+
+	errStaleDelivery = errors.New("delivery belongs to a closed channel, it is requeued by RabbitMQ")
+)
+
+// connect dials RabbitMQ, opens the channel, declares the topology
+// and marks the client as connected.
+func (r *rabbitMQ) connect() error {
+	// Attempts to establish a connection to RabbitMQ
+	r.logger.Info("Establishing connection to RabbitMQ...", logger.M{"queue_name": r.MainQueue})
+	conn, err := amqp.Dial(r.url)
+	if err != nil {
+		r.logger.Error("Failed to connect to RabbitMQ", logger.M{"error": err})
+		return err
+	}
+	r.logger.Info("Connection established successfully", nil)
+
+	// Attempts to open a new channel
+	r.logger.Info("Opening a new channel...", nil)
+	channel, err := conn.Channel()
+	if err != nil {
+		r.logger.Error("Failed to open a new channel", logger.M{"error": err})
+		_ = conn.Close()
+		return fmt.Errorf("can't connect to RabbitMQ:%s", err)
+	}
+	r.logger.Info("New channel opened successfully", nil)
+
+	if err := r.declareTopology(channel); err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	// RabbitMQ delivers only as many messages as the worker can process at the same time
+	if err := channel.Qos(r.prefetch, 0, false); err != nil {
+		r.logger.Error("Failed to set the prefetch count", logger.M{"error": err})
+		_ = conn.Close()
+		return fmt.Errorf("qos: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The client may be closed while it was connecting
+	if r.state == queue.StateClosed {
+		_ = conn.Close()
+
+		return amqp.ErrClosed
+	}
+
+	r.conn = conn
+	r.channel = channel
+	r.generation++
+	// The deliveries of the previous channel are requeued by RabbitMQ
+	r.deliveries = make(map[uint64]delivery)
+	r.state = queue.StateConnected
+	close(r.ready)
+
+	return nil
+}
+
+/*
+supervise watches the connection and the channel
+and restores them with exponential backoff and jitter when they are closed.
+
+The consumers and the publishers wait for the ready channel,
+which is closed after each successful (re)connection.
+*/
+func (r *rabbitMQ) supervise() {
+	for {
+		r.mu.RLock()
+		connClosed := r.conn.NotifyClose(make(chan *amqp.Error, 1))
+		channelClosed := r.channel.NotifyClose(make(chan *amqp.Error, 1))
+		r.mu.RUnlock()
+
+		var reason *amqp.Error
+		select {
+		case reason = <-connClosed:
+		case reason = <-channelClosed:
+		case <-r.done:
+			return
+		}
+
+		r.logger.Warn("Connection to RabbitMQ is lost", logger.M{"reason": reason})
+
+		r.mu.Lock()
+		if r.state == queue.StateClosed {
+			r.mu.Unlock()
+
+			return
+		}
+
+		r.state = queue.StateReconnecting
+		r.ready = make(chan struct{})
+		conn := r.conn
+		r.mu.Unlock()
+
+		// The channel may be closed alone, the connection must be closed too
+		_ = conn.Close()
+
+		if !r.reconnect() {
+			return
+		}
+	}
+}
+
+// reconnect tries to connect until it succeeds or the client is closed.
+func (r *rabbitMQ) reconnect() bool {
+	delay := r.minDelay
+
+	for attempt := 1; ; attempt++ {
+		// Full jitter: wait a random time in [delay/2, delay)
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+
+		r.logger.Info("Reconnecting to RabbitMQ", logger.M{
+			"attempt": attempt,
+			"delay":   wait,
+		})
+
+		select {
+		case <-time.After(wait):
+		case <-r.done:
+			return false
+		}
+
+		err := r.connect()
+		if err == nil {
+			r.logger.Info("Reconnected to RabbitMQ", logger.M{"attempt": attempt})
+
+			return true
+		}
+
+		r.logger.Error("Reconnecting failed", logger.M{"error": err, "attempt": attempt})
+
+		delay *= 2
+		if delay > r.maxDelay {
+			delay = r.maxDelay
+		}
+	}
+}
+
+// waitReady waits until the client is connected and returns the current channel.
+//
+// If publishing is fail-fast (wait is false), it returns ErrNotConnected without waiting.
+func (r *rabbitMQ) waitReady(ctx context.Context, wait bool) (*amqp.Channel, uint64, error) {
+	for {
+		r.mu.RLock()
+		state, ready, channel, generation := r.state, r.ready, r.channel, r.generation
+		r.mu.RUnlock()
+
+		switch state {
+		case queue.StateConnected:
+			return channel, generation, nil
+		case queue.StateClosed:
+			return nil, 0, amqp.ErrClosed
+		case queue.StateReconnecting:
+		}
+
+		if !wait {
+			return nil, 0, ErrNotConnected
+		}
+
+		select {
+		case <-ready:
+		case <-r.done:
+			return nil, 0, amqp.ErrClosed
+		case <-ctx.Done():
+			return nil, 0, fmt.Errorf("%w: %s", ErrNotConnected, ctx.Err())
+		}
+	}
+}
+
+// State returns the state of the connection.
+func (r *rabbitMQ) State() queue.State {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.state
+}
+
+// Close stops the reconnections, closes the connection and the consumers.
+func (r *rabbitMQ) Close() error {
+	r.mu.Lock()
+	if r.state == queue.StateClosed {
+		r.mu.Unlock()
+
+		return nil
+	}
+
+	r.state = queue.StateClosed
+	close(r.done)
+	conn := r.conn
+	r.mu.Unlock()
+
+	r.logger.Info("Closing the connection to RabbitMQ", nil)
+
+	if err := conn.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
+		return fmt.Errorf("close connection: %w", err)
+	}
+
+	return nil
+}
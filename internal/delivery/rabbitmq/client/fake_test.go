@@ -0,0 +1,389 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The frames and the methods of AMQP 0-9-1 used by the fake broker.
+const (
+	frameMethod    = 1
+	frameHeader    = 2
+	frameBody      = 3
+	frameHeartbeat = 8
+	frameEnd       = 0xCE
+
+	classConnection = 10
+	classChannel    = 20
+	classExchange   = 40
+	classQueue      = 50
+	classBasic      = 60
+)
+
+// event is a method the fake broker received from the client, e.g. "consume:<tag>" or "ack:<tag>".
+type event string
+
+/*
+fakeBroker is an in-process AMQP 0-9-1 server that speaks just enough of the protocol for the client:
+the handshake, the channel, the declarations, the consumers and the acknowledgements.
+
+It accepts every declaration, records the consumers and lets the test
+deliver the messages to them and drop the connections.
+*/
+type fakeBroker struct {
+	t        *testing.T
+	listener net.Listener
+	events   chan event
+
+	mu    sync.Mutex
+	conns []*fakeConn
+}
+
+// fakeConn is a connection of the client, the writes come from its reader and from the test.
+type fakeConn struct {
+	conn net.Conn
+
+	mu          sync.Mutex
+	channel     uint16
+	consumer    string
+	deliveryTag uint64
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	b := &fakeBroker{t: t, listener: listener, events: make(chan event, 100)}
+
+	go b.accept()
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+		b.dropConnections()
+	})
+
+	return b
+}
+
+// url returns the URL the client dials.
+func (b *fakeBroker) url() string {
+	return fmt.Sprintf("amqp://guest:guest@%s/", b.listener.Addr())
+}
+
+func (b *fakeBroker) accept() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		c := &fakeConn{conn: conn}
+
+		b.mu.Lock()
+		b.conns = append(b.conns, c)
+		b.mu.Unlock()
+
+		go b.serve(c)
+	}
+}
+
+// dropConnections closes all connections without the AMQP close, like a crashed broker.
+func (b *fakeBroker) dropConnections() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, c := range b.conns {
+		_ = c.conn.Close()
+	}
+
+	b.conns = nil
+}
+
+// waitEvent waits for the event, the other events are skipped.
+func (b *fakeBroker) waitEvent(want event) {
+	b.t.Helper()
+
+	timeout := time.After(5 * time.Second)
+
+	for {
+		select {
+		case got := <-b.events:
+			if got == want {
+				return
+			}
+		case <-timeout:
+			b.t.Fatalf("no %q event", want)
+		}
+	}
+}
+
+// deliver sends the message with the image ID to the consumer of the last connection.
+func (b *fakeBroker) deliver(imageID string) {
+	b.t.Helper()
+
+	b.mu.Lock()
+	if len(b.conns) == 0 {
+		b.mu.Unlock()
+		b.t.Fatal("no connection to deliver to")
+	}
+	c := b.conns[len(b.conns)-1]
+	b.mu.Unlock()
+
+	c.mu.Lock()
+	c.deliveryTag++
+	channel, consumer, tag := c.channel, c.consumer, c.deliveryTag
+	c.mu.Unlock()
+
+	var deliver args
+	deliver.shortstr(consumer)
+	deliver.longlong(tag)
+	deliver.octet(0)
+	deliver.shortstr("")
+	deliver.shortstr("Queue")
+
+	// The content header: class, weight, empty body, content type and headers
+	var header args
+	header.short(classBasic)
+	header.short(0)
+	header.longlong(0)
+	header.short(0x8000 | 0x2000)
+	header.shortstr("image/png")
+	header.table(map[string]string{"id": imageID})
+
+	if err := c.method(channel, classBasic, 60, deliver.Bytes()); err != nil {
+		b.t.Fatalf("deliver: %v", err)
+	}
+
+	if err := c.write(frameHeader, channel, header.Bytes()); err != nil {
+		b.t.Fatalf("deliver: %v", err)
+	}
+}
+
+// serve runs the handshake and answers the methods of the client until the connection is closed.
+func (b *fakeBroker) serve(c *fakeConn) {
+	defer c.conn.Close()
+
+	protocol := make([]byte, 8)
+	if _, err := io.ReadFull(c.conn, protocol); err != nil {
+		return
+	}
+
+	// connection.start: version 0-9, no server properties, PLAIN, en_US
+	var start args
+	start.octet(0)
+	start.octet(9)
+	start.table(nil)
+	start.longstr("PLAIN")
+	start.longstr("en_US")
+
+	if err := c.method(0, classConnection, 10, start.Bytes()); err != nil {
+		return
+	}
+
+	for {
+		frameType, channel, payload, err := c.read()
+		if err != nil {
+			return
+		}
+
+		if frameType != frameMethod {
+			// The heartbeats and the content of the published messages
+			continue
+		}
+
+		if err := b.answer(c, channel, payload); err != nil {
+			return
+		}
+	}
+}
+
+// answer replies to the method of the client.
+func (b *fakeBroker) answer(c *fakeConn, channel uint16, payload []byte) error {
+	class := binary.BigEndian.Uint16(payload[0:2])
+	method := binary.BigEndian.Uint16(payload[2:4])
+	r := bytes.NewReader(payload[4:])
+
+	var reply args
+
+	switch [2]uint16{class, method} {
+	case [2]uint16{classConnection, 11}: // start-ok -> tune
+		reply.short(0)
+		reply.long(131072)
+		reply.short(0)
+
+		return c.method(0, classConnection, 30, reply.Bytes())
+	case [2]uint16{classConnection, 31}: // tune-ok
+		return nil
+	case [2]uint16{classConnection, 40}: // open -> open-ok
+		reply.shortstr("")
+
+		return c.method(0, classConnection, 41, reply.Bytes())
+	case [2]uint16{classConnection, 50}: // close -> close-ok
+		_ = c.method(0, classConnection, 51, nil)
+
+		return io.EOF
+	case [2]uint16{classChannel, 10}: // open -> open-ok
+		c.mu.Lock()
+		c.channel = channel
+		c.mu.Unlock()
+
+		reply.longstr("")
+
+		return c.method(channel, classChannel, 11, reply.Bytes())
+	case [2]uint16{classChannel, 40}: // close -> close-ok
+		return c.method(channel, classChannel, 41, nil)
+	case [2]uint16{classExchange, 10}: // declare -> declare-ok
+		return c.method(channel, classExchange, 11, nil)
+	case [2]uint16{classQueue, 10}: // declare -> declare-ok with the name and no messages
+		skip(r, 2)
+		reply.shortstr(readShortstr(r))
+		reply.long(0)
+		reply.long(0)
+
+		return c.method(channel, classQueue, 11, reply.Bytes())
+	case [2]uint16{classQueue, 20}: // bind -> bind-ok
+		return c.method(channel, classQueue, 21, nil)
+	case [2]uint16{classBasic, 10}: // qos -> qos-ok
+		return c.method(channel, classBasic, 11, nil)
+	case [2]uint16{classBasic, 20}: // consume -> consume-ok
+		skip(r, 2)
+		readShortstr(r)
+		tag := readShortstr(r)
+
+		c.mu.Lock()
+		c.consumer = tag
+		c.mu.Unlock()
+
+		reply.shortstr(tag)
+		if err := c.method(channel, classBasic, 21, reply.Bytes()); err != nil {
+			return err
+		}
+
+		b.events <- event("consume:" + tag)
+
+		return nil
+	case [2]uint16{classBasic, 30}: // cancel -> cancel-ok
+		tag := readShortstr(r)
+
+		reply.shortstr(tag)
+		if err := c.method(channel, classBasic, 31, reply.Bytes()); err != nil {
+			return err
+		}
+
+		b.events <- event("cancel:" + tag)
+
+		return nil
+	case [2]uint16{classBasic, 80}: // ack
+		var tag uint64
+		_ = binary.Read(r, binary.BigEndian, &tag)
+
+		b.events <- event(fmt.Sprintf("ack:%d", tag))
+
+		return nil
+	case [2]uint16{classBasic, 40}: // publish, its content frames follow
+		return nil
+	default:
+		return fmt.Errorf("unexpected method %d.%d", class, method)
+	}
+}
+
+// read reads one frame.
+func (c *fakeConn) read() (byte, uint16, []byte, error) {
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(c.conn, header); err != nil {
+		return 0, 0, nil, err
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint32(header[3:7])+1)
+	if _, err := io.ReadFull(c.conn, payload); err != nil {
+		return 0, 0, nil, err
+	}
+
+	if payload[len(payload)-1] != frameEnd {
+		return 0, 0, nil, errors.New("bad frame end")
+	}
+
+	return header[0], binary.BigEndian.Uint16(header[1:3]), payload[:len(payload)-1], nil
+}
+
+// method writes the method frame with the encoded arguments.
+func (c *fakeConn) method(channel, class, method uint16, arguments []byte) error {
+	var payload args
+	payload.short(class)
+	payload.short(method)
+	payload.Write(arguments)
+
+	return c.write(frameMethod, channel, payload.Bytes())
+}
+
+func (c *fakeConn) write(frameType byte, channel uint16, payload []byte) error {
+	var frame args
+	frame.octet(frameType)
+	frame.short(channel)
+	frame.long(uint32(len(payload)))
+	frame.Write(payload)
+	frame.octet(frameEnd)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err := c.conn.Write(frame.Bytes())
+
+	return err
+}
+
+// args encodes the arguments of the methods.
+type args struct {
+	bytes.Buffer
+}
+
+func (a *args) octet(v byte)      { a.WriteByte(v) }
+func (a *args) short(v uint16)    { _ = binary.Write(a, binary.BigEndian, v) }
+func (a *args) long(v uint32)     { _ = binary.Write(a, binary.BigEndian, v) }
+func (a *args) longlong(v uint64) { _ = binary.Write(a, binary.BigEndian, v) }
+
+func (a *args) shortstr(s string) {
+	a.octet(byte(len(s)))
+	a.WriteString(s)
+}
+
+func (a *args) longstr(s string) {
+	a.long(uint32(len(s)))
+	a.WriteString(s)
+}
+
+// table encodes the field table with the string values.
+func (a *args) table(fields map[string]string) {
+	var t args
+	for key, value := range fields {
+		t.shortstr(key)
+		t.octet('S')
+		t.longstr(value)
+	}
+
+	a.long(uint32(t.Len()))
+	a.Write(t.Bytes())
+}
+
+func skip(r *bytes.Reader, n int64) {
+	_, _ = r.Seek(n, io.SeekCurrent)
+}
+
+func readShortstr(r *bytes.Reader) string {
+	n, _ := r.ReadByte()
+	s := make([]byte, n)
+	_, _ = io.ReadFull(r, s)
+
+	return string(s)
+}
@@ -1,5 +1,7 @@
 package dto
 
+import "context"
+
 // MessageDTO represents a message received from a message broker queue.
 type MessageDTO struct {
 	// Slice of bytes that contains the message body.
@@ -8,4 +10,13 @@ type MessageDTO struct {
 	ImageID string
 	// String that represents the content type of the image associated with the message.
 	ContentType string
+	// Headers of the message as they were received from the message broker.
+	Headers map[string]interface{}
+	// Attempt is the number of the delivery attempt of the message, starting from 1.
+	Attempt int
+	// DeliveryTag identifies the delivery for the acknowledgement of the message.
+	DeliveryTag uint64
+	// Context carries the trace context of the publisher extracted from the headers,
+	// so the processing of the image continues the trace of its upload.
+	Context context.Context
 }
@@ -0,0 +1,27 @@
+package dto
+
+// The statuses of the health checks.
+const (
+	HealthOK       = "ok"
+	HealthFailing  = "failing"
+	HealthDraining = "draining"
+)
+
+// HealthDTO represents the liveness or the readiness of the service.
+type HealthDTO struct {
+	// Status is "ok" if all components are healthy, "draining" on shutdown and "failing" otherwise.
+	Status string `json:"status"`
+	// Components are the results of the checks by the name of the component.
+	Components map[string]ComponentHealthDTO `json:"components,omitempty"`
+}
+
+// ComponentHealthDTO represents the result of the check of one component.
+type ComponentHealthDTO struct {
+	Status string `json:"status"`
+	// LatencyMS is the duration of the check in milliseconds.
+	LatencyMS float64 `json:"latency_ms"`
+	// Error is the reason of the failing check.
+	Error string `json:"error,omitempty"`
+	// Details describe the state of the component, e.g. the state of the connection.
+	Details map[string]interface{} `json:"details,omitempty"`
+}
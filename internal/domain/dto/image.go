@@ -0,0 +1,67 @@
+package dto
+
+import "time"
+
+// ImageDTO represents the catalog record of a stored image.
+type ImageDTO struct {
+	ImageID string `json:"id"`
+	// Filename is the name of the uploaded file.
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	// Width and Height of the original, they are set when the worker decodes it.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// Size and Checksum (SHA-256) of the original.
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+	// Uploader identifies the client that uploaded the image.
+	Uploader string `json:"uploader"`
+	// Metadata is the EXIF of the original kept by the metadata policy, by the names of the tags.
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Variants  []ImageVariantDTO `json:"variants,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// ImageVariantDTO represents a stored variant (level) of an image.
+type ImageVariantDTO struct {
+	Level       string `json:"level"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Size        int64  `json:"size"`
+	// Checksum is the SHA-256 of the stored variant.
+	Checksum  string    `json:"checksum"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ImageQueryDTO represents the page, the order and the filters of the image list.
+type ImageQueryDTO struct {
+	// Page starts from 1.
+	Page    int
+	PerPage int
+	// Sort is a field of the image: created_at, size, filename or content_type.
+	Sort string
+	Desc bool
+
+	// The filters are skipped if they are empty.
+	ContentType   string
+	Uploader      string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+// ImagePageDTO represents a page of the image list.
+type ImagePageDTO struct {
+	Items   []ImageDTO `json:"items"`
+	Page    int        `json:"page"`
+	PerPage int        `json:"per_page"`
+	Total   int64      `json:"total"`
+}
+
+// UsageDTO represents the storage used by the images of an uploader.
+type UsageDTO struct {
+	Images int64 `json:"images"`
+	// Bytes is the size of the originals and their variants.
+	Bytes int64 `json:"bytes"`
+}
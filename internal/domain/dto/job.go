@@ -0,0 +1,38 @@
+package dto
+
+import "time"
+
+// JobStatus is a state of the image processing job.
+type JobStatus string
+
+const (
+	// JobQueued - the image is published to the message broker.
+	JobQueued JobStatus = "queued"
+	// JobProcessing - the worker has received the image and is creating the variants.
+	JobProcessing JobStatus = "processing"
+	// JobDone - all variants of the image are stored.
+	JobDone JobStatus = "done"
+	// JobFailed - the image or at least one variant can't be processed.
+	JobFailed JobStatus = "failed"
+)
+
+// JobDTO represents the processing state of an image.
+type JobDTO struct {
+	ImageID   string       `json:"id"`
+	Status    JobStatus    `json:"status"`
+	Error     string       `json:"error,omitempty"`
+	Variants  []VariantDTO `json:"variants"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// VariantDTO represents the result of creating one variant (level) of an image.
+type VariantDTO struct {
+	Level  string    `json:"level"`
+	Status JobStatus `json:"status"`
+	Size   int       `json:"size"`
+	// Ratio is the size of the variant relative to the size of the original.
+	Ratio     float64   `json:"ratio"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
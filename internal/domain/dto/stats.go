@@ -0,0 +1,15 @@
+package dto
+
+// WorkerStatsDTO represents the load of the background worker.
+type WorkerStatsDTO struct {
+	// Images processed right now and the maximum of them.
+	InFlight    int `json:"in_flight"`
+	Concurrency int `json:"concurrency"`
+	// Consumers is the number of running consumers, it's less than Concurrency before the start and on shutdown.
+	Consumers int `json:"consumers"`
+	// Encode tasks running right now and the maximum of them.
+	EncodesInFlight int `json:"encodes_in_flight"`
+	Encoders        int `json:"encoders"`
+	// QueueDepth is the number of messages waiting in the queue, -1 if it is unknown.
+	QueueDepth int `json:"queue_depth"`
+}
@@ -0,0 +1,32 @@
+package catalog
+
+import (
+	"errors"
+
+	"github.com/andrsj/go-rabbit-image/internal/domain/dto"
+)
+
+var (
+	// ErrNotFound is returned when there is no image with the ID in the catalog.
+	ErrNotFound = errors.New("image not found")
+	// ErrInvalidQuery is returned when the list is requested with an unknown sort field.
+	ErrInvalidQuery = errors.New("invalid query")
+)
+
+// Repository stores the metadata of the images.
+type Repository interface {
+	// Create adds the uploaded image to the catalog.
+	Create(image dto.ImageDTO) error
+	// SetDimensions sets the width and height of the original image.
+	SetDimensions(imageID string, width, height int) error
+	// SetVariant creates or updates the stored variant of the image.
+	SetVariant(imageID string, variant dto.ImageVariantDTO) error
+	// Get returns the image with all variants.
+	Get(imageID string) (*dto.ImageDTO, error)
+	// List returns the page of the images without variants.
+	List(query dto.ImageQueryDTO) (*dto.ImagePageDTO, error)
+	// Usage returns the number and the size of the images of the uploader with their variants.
+	Usage(uploader string) (*dto.UsageDTO, error)
+	// Delete removes the image with all variants, it does nothing if there is no image.
+	Delete(imageID string) error
+}
@@ -0,0 +1,13 @@
+package file
+
+import "errors"
+
+// ErrCacheMiss is returned by GetDerived if the derived image isn't in the cache.
+var ErrCacheMiss = errors.New("derived image is not cached")
+
+// Cache stores the images derived from the originals (e.g. on-the-fly transformations),
+// the size of the cache is bounded, so the least recently used images are evicted.
+type Cache interface {
+	GetDerived(id string, key string) ([]byte, error)
+	PutDerived(data []byte, id string, key string) error
+}
@@ -1,6 +1,35 @@
 package file
 
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// ImageInfo describes a stored image.
+type ImageInfo struct {
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+	// Hash identifies the content of the image, it's computed when the image is written.
+	Hash string
+}
+
 type Repository interface {
 	CreateImage(data []byte, id string, level string) error
 	GetImage(id string, level string) ([]byte, error)
+	// WriteImage stores the image from the reader without holding it in memory, it returns the written size.
+	WriteImage(r io.Reader, id string, level string) (int64, error)
+	// OpenImage opens the stored image for streaming reads, the caller must close it.
+	OpenImage(id string, level string) (io.ReadSeekCloser, ImageInfo, error)
+	// DeleteImage removes the original and all variants of the image, it does nothing if there is no image.
+	DeleteImage(id string) error
+	// Exists reports whether the level of the image is stored.
+	Exists(id string, level string) (bool, error)
+}
+
+// Prober checks that the storage accepts the writes, e.g. for health checks.
+type Prober interface {
+	// Probe writes and removes a small object, it fails if the storage isn't writable.
+	Probe(ctx context.Context) error
 }
@@ -0,0 +1,27 @@
+package job
+
+import (
+	"errors"
+
+	"github.com/andrsj/go-rabbit-image/internal/domain/dto"
+)
+
+// ErrNotFound is returned when there is no job for the image ID.
+var ErrNotFound = errors.New("job not found")
+
+// Repository stores the processing state of the images.
+type Repository interface {
+	// Create registers a new job for the image in the queued status.
+	Create(imageID string) error
+	// SetStatus changes the status of the job, errText is empty if there is no error.
+	SetStatus(imageID string, status dto.JobStatus, errText string) error
+	// SetVariant creates or updates the result of one variant of the image.
+	SetVariant(imageID string, variant dto.VariantDTO) error
+	// Get returns the job with all variants.
+	Get(imageID string) (*dto.JobDTO, error)
+	// Delete removes the job with all variants and leaves a tombstone of the image,
+	// so the worker skips the messages of the deleted image.
+	Delete(imageID string) error
+	// IsDeleted reports whether the image has a tombstone.
+	IsDeleted(imageID string) (bool, error)
+}
@@ -2,19 +2,56 @@ package queue
 
 import (
 	"context"
+	"errors"
 
 	"github.com/andrsj/go-rabbit-image/internal/domain/dto"
 )
 
+// ErrDeadLettered is returned by Retry when the message ran out of attempts
+// and was sent to the dead-letter queue instead.
+var ErrDeadLettered = errors.New("message is sent to the dead-letter queue")
+
 type Publisher interface {
 	Publish(ctx context.Context, message []byte, imageID, contentType string) error
 }
 
 type Consumer interface {
 	MustConsumeMessages() (<-chan dto.MessageDTO, <-chan error)
+	// Ack confirms that the message is processed and removes it from the queue.
+	Ack(message dto.MessageDTO) error
+	// Retry returns the message to the queue for one more attempt.
+	Retry(message dto.MessageDTO, reason error) error
+	// Reject sends the message to the dead-letter queue without retrying.
+	Reject(message dto.MessageDTO, reason error) error
+	// Requeue returns the unfinished message to the queue as is, e.g. on shutdown.
+	Requeue(message dto.MessageDTO) error
+	// StopConsuming stops the delivery of new messages,
+	// the delivered ones still must be acknowledged or requeued.
+	StopConsuming() error
+	// QueueDepth returns the number of messages waiting in the queue.
+	QueueDepth() (int, error)
 }
 
 type MessageBroker interface {
 	Publisher
 	Consumer
+	// Close stops the consumers and releases the connection.
+	Close() error
+}
+
+// State is the state of the connection to the message broker.
+type State string
+
+const (
+	// StateConnected - the messages can be published and consumed.
+	StateConnected State = "connected"
+	// StateReconnecting - the connection is lost and the client tries to restore it.
+	StateReconnecting State = "reconnecting"
+	// StateClosed - the client was closed and won't reconnect.
+	StateClosed State = "closed"
+)
+
+// StateReporter reports the state of the connection, e.g. for health checks.
+type StateReporter interface {
+	State() State
 }
@@ -0,0 +1,119 @@
+package variant
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// FitCover fills the box and crops the parts of the image out of it.
+	FitCover = "cover"
+	// FitContain fits the whole image into the box keeping the aspect ratio.
+	FitContain = "contain"
+	// FitFill stretches the image to the box.
+	FitFill = "fill"
+)
+
+// ErrInvalidTransform is returned for the transformation with wrong or too big parameters.
+var ErrInvalidTransform = errors.New("invalid transformation")
+
+/*
+Transform is an on-the-fly transformation of the original image.
+
+One of Width and Height may be zero, then it's calculated from the aspect ratio of the image.
+Format and Quality are the same as in Profile.
+*/
+type Transform struct {
+	Width   int
+	Height  int
+	Fit     string
+	Format  string
+	Quality int
+}
+
+// Validate checks the parameters, the size must not be above the limits.
+func (t Transform) Validate(maxWidth, maxHeight int) error {
+	switch {
+	case t.Width < 0 || t.Height < 0:
+		return fmt.Errorf("%w: width and height must not be negative", ErrInvalidTransform)
+	case t.Width == 0 && t.Height == 0:
+		return fmt.Errorf("%w: width or height is required", ErrInvalidTransform)
+	case t.Width > maxWidth || t.Height > maxHeight:
+		return fmt.Errorf("%w: the size is limited to %dx%d", ErrInvalidTransform, maxWidth, maxHeight)
+	case t.Quality < 0 || t.Quality > fullScale:
+		return fmt.Errorf("%w: quality must be between 0 and 100", ErrInvalidTransform)
+	}
+
+	switch t.Fit {
+	case FitCover, FitContain, FitFill:
+	default:
+		return fmt.Errorf("%w: unknown fit '%s', use cover, contain or fill", ErrInvalidTransform, t.Fit)
+	}
+
+	switch t.Format {
+	case "", FormatJPEG, FormatPNG:
+	default:
+		return fmt.Errorf("%w: unknown format '%s', use jpeg or png", ErrInvalidTransform, t.Format)
+	}
+
+	return nil
+}
+
+// Key returns the name of the transformation, the same transformations have the same key.
+func (t Transform) Key() string {
+	format := t.Format
+	if format == "" {
+		format = "orig"
+	}
+
+	return fmt.Sprintf("w%d_h%d_%s_%s_q%d", t.Width, t.Height, t.Fit, format, t.Quality)
+}
+
+// Profile returns the profile with the encoder settings of the transformation.
+func (t Transform) Profile() Profile {
+	return Profile{
+		Name:    t.Key(),
+		Format:  t.Format,
+		Quality: t.Quality,
+	}
+}
+
+/*
+CheckSize checks the size of the result for the image with the given size.
+
+Validate checks only the given sides, the missing one is calculated from the aspect ratio
+and may be far above the limits for a narrow image, e.g. h=200 of a 2000x20 image is 20000x200.
+*/
+func (t Transform) CheckSize(width, height, maxWidth, maxHeight int) error {
+	w, h := float64(t.Width), float64(t.Height)
+
+	// The sides are compared as floats, the calculated side may overflow int
+	switch {
+	case t.Width == 0:
+		w = float64(width) * h / float64(height)
+	case t.Height == 0:
+		h = float64(height) * w / float64(width)
+	}
+
+	if w > float64(maxWidth) || h > float64(maxHeight) {
+		return fmt.Errorf("%w: the result of %.0fx%.0f for the %dx%d image is over the limit of %dx%d",
+			ErrInvalidTransform, w, h, width, height, maxWidth, maxHeight)
+	}
+
+	return nil
+}
+
+// Box returns the size of the box for the image with the given size,
+// the missing side is calculated from the aspect ratio, CheckSize checks it against the limits.
+func (t Transform) Box(width, height int) (int, int) {
+	w, h := t.Width, t.Height
+
+	switch {
+	case w == 0:
+		w = atLeastOne(float64(width) * float64(h) / float64(height))
+	case h == 0:
+		h = atLeastOne(float64(height) * float64(w) / float64(width))
+	}
+
+	return w, h
+}
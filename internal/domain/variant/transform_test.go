@@ -0,0 +1,34 @@
+package variant
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTransformCheckSize(t *testing.T) {
+	tests := []struct {
+		name          string
+		transform     Transform
+		width, height int
+		wantErr       bool
+	}{
+		{"both sides in the limits", Transform{Width: 300, Height: 200}, 2000, 20, false},
+		{"calculated width in the limits", Transform{Height: 200}, 400, 300, false},
+		{"calculated width over the limit", Transform{Height: 200}, 2000, 20, true},
+		{"calculated height over the limit", Transform{Width: 200}, 20, 2000, true},
+		{"calculated side over int", Transform{Height: 2048}, 1 << 30, 1, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.transform.CheckSize(test.width, test.height, 2048, 2048)
+			if test.wantErr != (err != nil) {
+				t.Fatalf("got %v, want error: %t", err, test.wantErr)
+			}
+
+			if err != nil && !errors.Is(err, ErrInvalidTransform) {
+				t.Fatalf("got %v, want %v", err, ErrInvalidTransform)
+			}
+		})
+	}
+}
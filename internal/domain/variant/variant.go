@@ -0,0 +1,187 @@
+package variant
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+const (
+	// Original is the name of the uploaded image, it is stored as is.
+	Original = "original"
+	// OriginalKey is the storage key of the original, it's the old "100" quality level.
+	OriginalKey = "100"
+
+	// FormatJPEG and FormatPNG are the output formats of the profiles,
+	// an empty format keeps the format of the original.
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+
+	// CompressionDefault, CompressionNone, CompressionSpeed and CompressionBest
+	// are the compression levels of the PNG encoder, an empty level is the default one.
+	CompressionDefault = "default"
+	CompressionNone    = "none"
+	CompressionSpeed   = "speed"
+	CompressionBest    = "best"
+
+	// MaxColors is the maximum size of the palette of the quantized PNG images.
+	MaxColors = 256
+
+	fullScale = 100
+)
+
+var (
+	// ErrUnknown is returned for a name that is neither the original nor a profile.
+	ErrUnknown = errors.New("unknown variant")
+
+	errInvalidProfile = errors.New("invalid variant profile")
+
+	namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+)
+
+/*
+Profile describes one variant created from every uploaded image.
+
+The image is scaled by Scale (percent of the original size)
+and then fit into MaxWidth x MaxHeight keeping the aspect ratio,
+the zero values don't limit anything. The images are never upscaled.
+*/
+type Profile struct {
+	Name      string
+	Scale     int
+	MaxWidth  int
+	MaxHeight int
+	// Format is jpeg, png or empty (the format of the original).
+	Format string
+	// Quality is the JPEG quality (1-100), zero is the default quality of the encoder.
+	Quality int
+	// Compression is the PNG compression level: default, none, speed or best.
+	Compression string
+	// Colors quantizes the PNG image to the palette of this size (2-256), zero keeps all colors.
+	Colors int
+}
+
+// Validate checks the values of the profile.
+func (p Profile) Validate() error {
+	switch {
+	case !namePattern.MatchString(p.Name):
+		return fmt.Errorf("%w: name '%s' must match %s", errInvalidProfile, p.Name, namePattern)
+	case p.Name == Original || p.Name == OriginalKey:
+		return fmt.Errorf("%w: name '%s' is reserved for the original", errInvalidProfile, p.Name)
+	case p.Scale < 0 || p.Scale > fullScale:
+		return fmt.Errorf("%w: %s: scale must be between 0 and 100", errInvalidProfile, p.Name)
+	case p.MaxWidth < 0 || p.MaxHeight < 0:
+		return fmt.Errorf("%w: %s: max width and height must not be negative", errInvalidProfile, p.Name)
+	case p.Quality < 0 || p.Quality > fullScale:
+		return fmt.Errorf("%w: %s: quality must be between 0 and 100", errInvalidProfile, p.Name)
+	case p.Colors != 0 && (p.Colors < 2 || p.Colors > MaxColors):
+		return fmt.Errorf("%w: %s: colors must be between 2 and %d", errInvalidProfile, p.Name, MaxColors)
+	}
+
+	switch p.Compression {
+	case "", CompressionDefault, CompressionNone, CompressionSpeed, CompressionBest:
+	default:
+		return fmt.Errorf("%w: %s: unknown compression '%s'", errInvalidProfile, p.Name, p.Compression)
+	}
+
+	switch p.Format {
+	case "", FormatJPEG, FormatPNG:
+	default:
+		return fmt.Errorf("%w: %s: unknown format '%s'", errInvalidProfile, p.Name, p.Format)
+	}
+
+	return nil
+}
+
+// Dimensions returns the size of the variant of the image with the given size.
+func (p Profile) Dimensions(width, height int) (int, int) {
+	w, h := float64(width), float64(height)
+
+	if p.Scale > 0 {
+		w = w * float64(p.Scale) / fullScale
+		h = h * float64(p.Scale) / fullScale
+	}
+
+	// Fit into the bounding box keeping the aspect ratio
+	if p.MaxWidth > 0 && w > float64(p.MaxWidth) {
+		h = h * float64(p.MaxWidth) / w
+		w = float64(p.MaxWidth)
+	}
+
+	if p.MaxHeight > 0 && h > float64(p.MaxHeight) {
+		w = w * float64(p.MaxHeight) / h
+		h = float64(p.MaxHeight)
+	}
+
+	return atLeastOne(w), atLeastOne(h)
+}
+
+// ContentType returns the content type of the variant of the image with the given content type.
+func (p Profile) ContentType(original string) string {
+	switch p.Format {
+	case FormatJPEG:
+		return "image/jpeg"
+	case FormatPNG:
+		return "image/png"
+	default:
+		return original
+	}
+}
+
+func atLeastOne(value float64) int {
+	if value < 1 {
+		return 1
+	}
+
+	return int(value)
+}
+
+// Set is the ordered list of the profiles.
+type Set []Profile
+
+// Validate checks every profile and the uniqueness of the names.
+func (s Set) Validate() error {
+	names := make(map[string]struct{}, len(s))
+
+	for _, profile := range s {
+		if err := profile.Validate(); err != nil {
+			return err
+		}
+
+		if _, ok := names[profile.Name]; ok {
+			return fmt.Errorf("%w: duplicated name '%s'", errInvalidProfile, profile.Name)
+		}
+
+		names[profile.Name] = struct{}{}
+	}
+
+	return nil
+}
+
+// Key returns the storage key of the variant by its name,
+// the original may be requested as "original" or "100".
+func (s Set) Key(name string) (string, error) {
+	if name == Original || name == OriginalKey {
+		return OriginalKey, nil
+	}
+
+	for _, profile := range s {
+		if profile.Name == name {
+			return profile.Name, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: '%s'", ErrUnknown, name)
+}
+
+// Names returns the names of the original and of all profiles.
+func (s Set) Names() []string {
+	names := make([]string, 0, len(s)+1)
+	names = append(names, Original)
+
+	for _, profile := range s {
+		names = append(names, profile.Name)
+	}
+
+	return names
+}
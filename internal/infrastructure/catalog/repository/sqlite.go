@@ -0,0 +1,295 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/andrsj/go-rabbit-image/internal/domain/dto"
+	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/catalog"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// sortColumns are the columns of the images that the list can be sorted by.
+var sortColumns = map[string]string{
+	"created_at":   "created_at",
+	"size":         "size",
+	"filename":     "filename",
+	"content_type": "content_type",
+}
+
+// imageModel is the table of the images.
+type imageModel struct {
+	ImageID     string `gorm:"primaryKey"`
+	Filename    string
+	ContentType string `gorm:"index"`
+	Width       int
+	Height      int
+	Size        int64
+	Checksum    string
+	Uploader    string              `gorm:"index"`
+	Metadata    map[string]string   `gorm:"serializer:json"`
+	Variants    []imageVariantModel `gorm:"foreignKey:ImageID;references:ImageID;constraint:OnDelete:CASCADE"`
+	CreatedAt   time.Time           `gorm:"index"`
+	UpdatedAt   time.Time
+}
+
+func (imageModel) TableName() string { return "images" }
+
+// imageVariantModel is the table of the stored variants of the images.
+type imageVariantModel struct {
+	ImageID     string `gorm:"primaryKey"`
+	Level       string `gorm:"primaryKey"`
+	ContentType string
+	Width       int
+	Height      int
+	Size        int64
+	Checksum    string
+	CreatedAt   time.Time
+}
+
+func (imageVariantModel) TableName() string { return "image_variants" }
+
+type sqliteCatalogStorage struct {
+	db     *gorm.DB
+	logger logger.Logger
+}
+
+var _ catalog.Repository = (*sqliteCatalogStorage)(nil)
+
+// New migrates the tables of the catalog in the database, it's shared with the jobs, the tables don't overlap.
+func New(db *gorm.DB, log logger.Logger) (*sqliteCatalogStorage, error) {
+	log = log.Named("catalog repository")
+
+	if err := db.AutoMigrate(&imageModel{}, &imageVariantModel{}); err != nil {
+		log.Error("Can't migrate the catalog tables", logger.M{"error": err})
+
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+
+	return &sqliteCatalogStorage{
+		db:     db,
+		logger: log,
+	}, nil
+}
+
+func (s *sqliteCatalogStorage) Create(image dto.ImageDTO) error {
+	s.logger.Debug("Adding image to catalog", logger.M{"id": image.ImageID})
+
+	err := s.db.Create(&imageModel{
+		ImageID:     image.ImageID,
+		Filename:    image.Filename,
+		ContentType: image.ContentType,
+		Width:       image.Width,
+		Height:      image.Height,
+		Size:        image.Size,
+		Checksum:    image.Checksum,
+		Uploader:    image.Uploader,
+		Metadata:    image.Metadata,
+	}).Error
+	if err != nil {
+		s.logger.Error("Error on adding image to catalog", logger.M{"id": image.ImageID, "error": err})
+
+		return fmt.Errorf("create image '%s': %w", image.ImageID, err)
+	}
+
+	return nil
+}
+
+func (s *sqliteCatalogStorage) SetDimensions(imageID string, width, height int) error {
+	result := s.db.Model(&imageModel{ImageID: imageID}).Updates(map[string]interface{}{
+		"width":  width,
+		"height": height,
+	})
+	if result.Error != nil {
+		s.logger.Error("Error on updating image dimensions", logger.M{"id": imageID, "error": result.Error})
+
+		return fmt.Errorf("update image '%s': %w", imageID, result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: '%s'", catalog.ErrNotFound, imageID)
+	}
+
+	return nil
+}
+
+func (s *sqliteCatalogStorage) SetVariant(imageID string, variant dto.ImageVariantDTO) error {
+	s.logger.Debug("Updating image variant", logger.M{
+		"id":    imageID,
+		"level": variant.Level,
+	})
+
+	// Insert the variant or update it if it's written again (retry)
+	err := s.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&imageVariantModel{
+		ImageID:     imageID,
+		Level:       variant.Level,
+		ContentType: variant.ContentType,
+		Width:       variant.Width,
+		Height:      variant.Height,
+		Size:        variant.Size,
+		Checksum:    variant.Checksum,
+	}).Error
+	if err != nil {
+		s.logger.Error("Error on updating image variant", logger.M{"id": imageID, "error": err})
+
+		return fmt.Errorf("update variant '%s' of image '%s': %w", variant.Level, imageID, err)
+	}
+
+	return nil
+}
+
+func (s *sqliteCatalogStorage) Get(imageID string) (*dto.ImageDTO, error) {
+	var model imageModel
+
+	err := s.db.Preload("Variants").First(&model, "image_id = ?", imageID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: '%s'", catalog.ErrNotFound, imageID)
+	}
+
+	if err != nil {
+		s.logger.Error("Error on reading image", logger.M{"id": imageID, "error": err})
+
+		return nil, fmt.Errorf("get image '%s': %w", imageID, err)
+	}
+
+	image := model.toDTO()
+
+	return &image, nil
+}
+
+func (s *sqliteCatalogStorage) List(query dto.ImageQueryDTO) (*dto.ImagePageDTO, error) {
+	column, ok := sortColumns[query.Sort]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown sort field '%s'", catalog.ErrInvalidQuery, query.Sort)
+	}
+
+	tx := s.db.Model(&imageModel{})
+
+	if query.ContentType != "" {
+		tx = tx.Where("content_type = ?", query.ContentType)
+	}
+
+	if query.Uploader != "" {
+		tx = tx.Where("uploader = ?", query.Uploader)
+	}
+
+	if !query.CreatedAfter.IsZero() {
+		tx = tx.Where("created_at >= ?", query.CreatedAfter)
+	}
+
+	if !query.CreatedBefore.IsZero() {
+		tx = tx.Where("created_at < ?", query.CreatedBefore)
+	}
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		s.logger.Error("Error on counting images", logger.M{"error": err})
+
+		return nil, fmt.Errorf("count images: %w", err)
+	}
+
+	var models []imageModel
+
+	// The ID makes the order stable for the equal values
+	err := tx.
+		Order(clause.OrderByColumn{Column: clause.Column{Name: column}, Desc: query.Desc}).
+		Order(clause.OrderByColumn{Column: clause.Column{Name: "image_id"}, Desc: query.Desc}).
+		Offset((query.Page - 1) * query.PerPage).
+		Limit(query.PerPage).
+		Find(&models).Error
+	if err != nil {
+		s.logger.Error("Error on listing images", logger.M{"error": err})
+
+		return nil, fmt.Errorf("list images: %w", err)
+	}
+
+	items := make([]dto.ImageDTO, 0, len(models))
+	for _, m := range models {
+		items = append(items, m.toDTO())
+	}
+
+	return &dto.ImagePageDTO{
+		Items:   items,
+		Page:    query.Page,
+		PerPage: query.PerPage,
+		Total:   total,
+	}, nil
+}
+
+func (s *sqliteCatalogStorage) Usage(uploader string) (*dto.UsageDTO, error) {
+	var usage dto.UsageDTO
+
+	err := s.db.Model(&imageModel{}).
+		Select("COUNT(*) AS images, COALESCE(SUM(size), 0) AS bytes").
+		Where("uploader = ?", uploader).
+		Scan(&usage).Error
+	if err != nil {
+		s.logger.Error("Error on counting usage", logger.M{"uploader": uploader, "error": err})
+
+		return nil, fmt.Errorf("usage of '%s': %w", uploader, err)
+	}
+
+	var variants int64
+
+	err = s.db.Model(&imageVariantModel{}).
+		Select("COALESCE(SUM(image_variants.size), 0)").
+		Joins("JOIN images ON images.image_id = image_variants.image_id").
+		Where("images.uploader = ?", uploader).
+		Scan(&variants).Error
+	if err != nil {
+		s.logger.Error("Error on counting usage of variants", logger.M{"uploader": uploader, "error": err})
+
+		return nil, fmt.Errorf("usage of variants of '%s': %w", uploader, err)
+	}
+
+	usage.Bytes += variants
+
+	return &usage, nil
+}
+
+func (s *sqliteCatalogStorage) Delete(imageID string) error {
+	s.logger.Debug("Deleting image from catalog", logger.M{"id": imageID})
+
+	// The variants are removed by the foreign key
+	if err := s.db.Delete(&imageModel{ImageID: imageID}).Error; err != nil {
+		s.logger.Error("Error on deleting image from catalog", logger.M{"id": imageID, "error": err})
+
+		return fmt.Errorf("delete image '%s': %w", imageID, err)
+	}
+
+	return nil
+}
+
+// toDTO converts the database model to the DTO.
+func (m imageModel) toDTO() dto.ImageDTO {
+	variants := make([]dto.ImageVariantDTO, 0, len(m.Variants))
+	for _, v := range m.Variants {
+		variants = append(variants, dto.ImageVariantDTO{
+			Level:       v.Level,
+			ContentType: v.ContentType,
+			Width:       v.Width,
+			Height:      v.Height,
+			Size:        v.Size,
+			Checksum:    v.Checksum,
+			CreatedAt:   v.CreatedAt,
+		})
+	}
+
+	return dto.ImageDTO{
+		ImageID:     m.ImageID,
+		Filename:    m.Filename,
+		ContentType: m.ContentType,
+		Width:       m.Width,
+		Height:      m.Height,
+		Size:        m.Size,
+		Checksum:    m.Checksum,
+		Uploader:    m.Uploader,
+		Metadata:    m.Metadata,
+		Variants:    variants,
+		CreatedAt:   m.CreatedAt,
+		UpdatedAt:   m.UpdatedAt,
+	}
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrsj/go-rabbit-image/internal/domain/dto"
+	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/catalog"
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/database"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+)
+
+// day is the creation time of the first test image, the next ones are created a day later each.
+var day = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestCatalog returns the catalog with the images a (png, 300 bytes, alice), b (jpeg, 100, bob)
+// and c (jpeg, 200, alice) created in this order a day apart.
+func newTestCatalog(t *testing.T) *sqliteCatalogStorage {
+	t.Helper()
+
+	log := logger.NewLogrusLogger("error")
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "images.db"), log)
+	if err != nil {
+		t.Fatalf("database: %v", err)
+	}
+	t.Cleanup(func() { _ = database.Close(db) })
+
+	s, err := New(db, log)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+
+	images := []dto.ImageDTO{
+		{ImageID: "a", Filename: "a.png", ContentType: "image/png", Size: 300, Uploader: "alice"},
+		{ImageID: "b", Filename: "b.jpg", ContentType: "image/jpeg", Size: 100, Uploader: "bob"},
+		{ImageID: "c", Filename: "c.jpg", ContentType: "image/jpeg", Size: 200, Uploader: "alice"},
+	}
+
+	for i, image := range images {
+		if err := s.Create(image); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+
+		created := day.AddDate(0, 0, i)
+		if err := s.db.Model(&imageModel{ImageID: image.ImageID}).Update("created_at", created).Error; err != nil {
+			t.Fatalf("set created_at: %v", err)
+		}
+	}
+
+	return s
+}
+
+func TestList(t *testing.T) {
+	s := newTestCatalog(t)
+
+	tests := []struct {
+		name  string
+		query dto.ImageQueryDTO
+		// ids are the images of the page in order
+		ids   string
+		total int64
+	}{
+		{"created_at", dto.ImageQueryDTO{Sort: "created_at"}, "a,b,c", 3},
+		{"created_at desc", dto.ImageQueryDTO{Sort: "created_at", Desc: true}, "c,b,a", 3},
+		{"size", dto.ImageQueryDTO{Sort: "size"}, "b,c,a", 3},
+		{"filename desc", dto.ImageQueryDTO{Sort: "filename", Desc: true}, "c,b,a", 3},
+		// The equal values are ordered by the ID
+		{"content_type", dto.ImageQueryDTO{Sort: "content_type"}, "b,c,a", 3},
+		{"content type filter", dto.ImageQueryDTO{Sort: "size", ContentType: "image/jpeg"}, "b,c", 2},
+		{"uploader filter", dto.ImageQueryDTO{Sort: "size", Uploader: "alice"}, "c,a", 2},
+		{"created after", dto.ImageQueryDTO{Sort: "created_at", CreatedAfter: day.AddDate(0, 0, 1)}, "b,c", 2},
+		{"created before", dto.ImageQueryDTO{Sort: "created_at", CreatedBefore: day.AddDate(0, 0, 1)}, "a", 1},
+		{"no match", dto.ImageQueryDTO{Sort: "size", Uploader: "carol"}, "", 0},
+		{"second page", dto.ImageQueryDTO{Sort: "size", Page: 2, PerPage: 2}, "a", 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.query.Page == 0 {
+				test.query.Page, test.query.PerPage = 1, 10
+			}
+
+			page, err := s.List(test.query)
+			if err != nil {
+				t.Fatalf("list: %v", err)
+			}
+
+			ids := make([]string, 0, len(page.Items))
+			for _, image := range page.Items {
+				ids = append(ids, image.ImageID)
+			}
+
+			if got := strings.Join(ids, ","); got != test.ids || page.Total != test.total {
+				t.Fatalf("got %q of %d, want %q of %d", got, page.Total, test.ids, test.total)
+			}
+		})
+	}
+}
+
+func TestListRejectsUnknownSort(t *testing.T) {
+	s := newTestCatalog(t)
+
+	// Only the whitelisted fields get into the ORDER BY
+	for _, sort := range []string{"", "uploader", "checksum", "size; DROP TABLE images", "CREATED_AT"} {
+		t.Run(sort, func(t *testing.T) {
+			_, err := s.List(dto.ImageQueryDTO{Sort: sort, Page: 1, PerPage: 10})
+			if !errors.Is(err, catalog.ErrInvalidQuery) {
+				t.Fatalf("got %v, want %v", err, catalog.ErrInvalidQuery)
+			}
+		})
+	}
+
+	if page, err := s.List(dto.ImageQueryDTO{Sort: "size", Page: 1, PerPage: 10}); err != nil || page.Total != 3 {
+		t.Fatalf("the images are gone: %v", err)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	s := newTestCatalog(t)
+
+	if err := s.SetVariant("a", dto.ImageVariantDTO{Level: "50", Size: 50}); err != nil {
+		t.Fatalf("set variant: %v", err)
+	}
+
+	tests := []struct {
+		uploader string
+		images   int64
+		bytes    int64
+	}{
+		{"alice", 2, 300 + 200 + 50},
+		{"bob", 1, 100},
+		{"carol", 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.uploader, func(t *testing.T) {
+			usage, err := s.Usage(test.uploader)
+			if err != nil {
+				t.Fatalf("usage: %v", err)
+			}
+
+			if usage.Images != test.images || usage.Bytes != test.bytes {
+				t.Fatalf("got %d images of %d bytes, want %d of %d", usage.Images, usage.Bytes, test.images, test.bytes)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/exif"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// SniffLen is the size of the header that is enough to detect the format, like in http.DetectContentType.
+const SniffLen = 512
+
+// The content types of the variants, only they can be encoded.
+const (
+	JPEGType = "image/jpeg"
+	PNGType  = "image/png"
+	// GIFType is used only by the animated variants.
+	GIFType = "image/gif"
+)
+
+// ErrUnsupported is returned if the format of the image isn't registered.
+var ErrUnsupported = errors.New("unsupported image format")
+
+// OutputPolicy selects the format of the variants created from the images of the format.
+type OutputPolicy int
+
+const (
+	// OutputSame keeps the format of the original, only for the formats that can be encoded.
+	OutputSame OutputPolicy = iota
+	// OutputJPEG encodes the variants as JPEG.
+	OutputJPEG
+	// OutputPNG encodes the variants as PNG.
+	OutputPNG
+	// OutputByAlpha encodes the opaque images as JPEG and the images with transparency as PNG.
+	OutputByAlpha
+)
+
+// Codec describes an input format of the images.
+type Codec struct {
+	// ContentType is the MIME type of the format, e.g. image/webp.
+	ContentType string
+	// Ext is the file extension of the stored originals.
+	Ext string
+	// Magic are the signatures at the start of the file, '?' matches any byte.
+	Magic []string
+	// Decode decodes the image, the animated formats are decoded to the first frame.
+	Decode func(r io.Reader) (image.Image, error)
+	// DecodeConfig reads the dimensions of the image without decoding it.
+	DecodeConfig func(r io.Reader) (image.Config, error)
+	// DecodeAnimation decodes all frames of the image, it's nil for the still formats.
+	DecodeAnimation func(r io.Reader) (*gif.GIF, error)
+	// CountFrames counts the frames of the animation without decoding them, it's set with DecodeAnimation.
+	CountFrames func(r io.Reader) (int, error)
+	// FilterMetadata applies the metadata policy to the original, it's nil for the formats without the metadata.
+	FilterMetadata MetadataFilter
+	// Output is the policy of the format of the variants.
+	Output OutputPolicy
+}
+
+// Match reports whether the header starts with one of the signatures of the format.
+func (c Codec) Match(header []byte) bool {
+	for _, magic := range c.Magic {
+		if matchMagic(magic, header) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// OutputType returns the content type of the variants of the decoded image.
+func (c Codec) OutputType(img image.Image) string {
+	switch c.Output {
+	case OutputJPEG:
+		return JPEGType
+	case OutputPNG:
+		return PNGType
+	case OutputByAlpha:
+		if isOpaque(img) {
+			return JPEGType
+		}
+
+		return PNGType
+	default:
+		return c.ContentType
+	}
+}
+
+// matchMagic reports whether the header starts with the signature, '?' matches any byte.
+func matchMagic(magic string, header []byte) bool {
+	if len(header) < len(magic) {
+		return false
+	}
+
+	for i := 0; i < len(magic); i++ {
+		if magic[i] != '?' && magic[i] != header[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isOpaque reports whether the image has no transparent pixels,
+// the images that can't tell it are treated as transparent.
+func isOpaque(img image.Image) bool {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok {
+		return opaque.Opaque()
+	}
+
+	return false
+}
+
+// The built-in formats.
+var (
+	JPEG = Codec{
+		ContentType:    JPEGType,
+		Ext:            "jpeg",
+		Magic:          []string{"\xff\xd8\xff"},
+		Decode:         jpeg.Decode,
+		DecodeConfig:   jpeg.DecodeConfig,
+		FilterMetadata: filterChunks(exif.FilterJPEG),
+		Output:         OutputSame,
+	}
+	PNG = Codec{
+		ContentType:    PNGType,
+		Ext:            "png",
+		Magic:          []string{"\x89PNG\r\n\x1a\n"},
+		Decode:         png.Decode,
+		DecodeConfig:   png.DecodeConfig,
+		FilterMetadata: filterChunks(exif.FilterPNG),
+		Output:         OutputSame,
+	}
+	// GIF images keep the palette and the transparency in PNG,
+	// the animated ones get animated GIF variants.
+	GIF = Codec{
+		ContentType:     GIFType,
+		Ext:             "gif",
+		Magic:           []string{"GIF87a", "GIF89a"},
+		Decode:          gif.Decode,
+		DecodeConfig:    gif.DecodeConfig,
+		DecodeAnimation: gif.DecodeAll,
+		CountFrames:     countGIFFrames,
+		Output:          OutputPNG,
+	}
+	// BMP images are mostly screenshots, so they stay lossless.
+	BMP = Codec{
+		ContentType:  "image/bmp",
+		Ext:          "bmp",
+		Magic:        []string{"BM"},
+		Decode:       bmp.Decode,
+		DecodeConfig: bmp.DecodeConfig,
+		Output:       OutputPNG,
+	}
+	// TIFF images are mostly scans and photos.
+	TIFF = Codec{
+		ContentType:    "image/tiff",
+		Ext:            "tiff",
+		Magic:          []string{"II*\x00", "MM\x00*"},
+		Decode:         tiff.Decode,
+		DecodeConfig:   tiff.DecodeConfig,
+		FilterMetadata: filterTIFF,
+		Output:         OutputByAlpha,
+	}
+	// WebP images are mostly photos, the transparent ones are kept in PNG.
+	WebP = Codec{
+		ContentType:    "image/webp",
+		Ext:            "webp",
+		Magic:          []string{"RIFF????WEBPVP8"},
+		Decode:         webp.Decode,
+		DecodeConfig:   webp.DecodeConfig,
+		FilterMetadata: filterChunks(exif.FilterWebP),
+		Output:         OutputByAlpha,
+	}
+)
+
+/*
+Registry is the set of the accepted input formats.
+
+It's used by the API to validate the uploads, by the storage to name the files
+and by the worker to decode the originals, so a registered format is accepted everywhere.
+*/
+type Registry struct {
+	mu     sync.RWMutex
+	codecs []Codec
+}
+
+// NewRegistry creates a registry of the codecs.
+func NewRegistry(codecs ...Codec) *Registry {
+	registry := &Registry{}
+
+	for _, codec := range codecs {
+		registry.Register(codec)
+	}
+
+	return registry
+}
+
+// Register adds the codec or replaces the codec of the same content type.
+func (r *Registry) Register(codec Codec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.codecs {
+		if r.codecs[i].ContentType == codec.ContentType {
+			r.codecs[i] = codec
+
+			return
+		}
+	}
+
+	r.codecs = append(r.codecs, codec)
+}
+
+// Detect returns the codec of the image by its header.
+func (r *Registry) Detect(header []byte) (Codec, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, codec := range r.codecs {
+		if codec.Match(header) {
+			return codec, nil
+		}
+	}
+
+	return Codec{}, fmt.Errorf("%w, use one of: %s", ErrUnsupported, strings.Join(r.contentTypes(), ", "))
+}
+
+// Lookup returns the codec of the content type.
+func (r *Registry) Lookup(contentType string) (Codec, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, codec := range r.codecs {
+		if codec.ContentType == contentType {
+			return codec, true
+		}
+	}
+
+	return Codec{}, false
+}
+
+// LookupExt returns the codec of the file extension without the dot.
+func (r *Registry) LookupExt(ext string) (Codec, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, codec := range r.codecs {
+		if codec.Ext == ext {
+			return codec, true
+		}
+	}
+
+	return Codec{}, false
+}
+
+// ContentTypes returns the content types of the registered codecs.
+func (r *Registry) ContentTypes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.contentTypes()
+}
+
+func (r *Registry) contentTypes() []string {
+	types := make([]string, 0, len(r.codecs))
+	for _, codec := range r.codecs {
+		types = append(types, codec.ContentType)
+	}
+
+	return types
+}
+
+// Default is the registry of the built-in formats, the new formats are registered in it.
+var Default = NewRegistry(JPEG, PNG, GIF, BMP, TIFF, WebP)
+
+// Register adds the codec to the default registry.
+func Register(codec Codec) { Default.Register(codec) }
+
+// Detect returns the codec of the image by its header from the default registry.
+func Detect(header []byte) (Codec, error) { return Default.Detect(header) }
+
+// Lookup returns the codec of the content type from the default registry.
+func Lookup(contentType string) (Codec, bool) { return Default.Lookup(contentType) }
+
+// LookupExt returns the codec of the file extension from the default registry.
+func LookupExt(ext string) (Codec, bool) { return Default.LookupExt(ext) }
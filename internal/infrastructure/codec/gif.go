@@ -0,0 +1,117 @@
+package codec
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The blocks of the GIF stream.
+const (
+	gifExtension       = 0x21
+	gifImageDescriptor = 0x2C
+	gifTrailer         = 0x3B
+
+	// gifColorTableFlag marks the color table following the descriptor.
+	gifColorTableFlag = 0x80
+)
+
+var errGIFFormat = errors.New("gif: bad block structure")
+
+/*
+countGIFFrames counts the image descriptors of the GIF without decoding the frames.
+
+Only the block structure is read: the color tables and the data sub-blocks are skipped,
+so the count costs one pass over the file and no memory for the pixels.
+The stream that ends without the trailer is counted up to its end, the decoder reports it.
+*/
+func countGIFFrames(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+
+	// The header and the logical screen descriptor
+	screen := make([]byte, 13)
+	if _, err := io.ReadFull(br, screen); err != nil {
+		return 0, fmt.Errorf("%w: read header: %s", errGIFFormat, err)
+	}
+
+	if err := skipColorTable(br, screen[10]); err != nil {
+		return 0, err
+	}
+
+	frames := 0
+
+	for {
+		block, err := br.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return frames, nil
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		switch block {
+		case gifExtension:
+			// The label, then the sub-blocks
+			if _, err := br.Discard(1); err != nil {
+				return frames, nil
+			}
+		case gifImageDescriptor:
+			descriptor := make([]byte, 9)
+			if _, err := io.ReadFull(br, descriptor); err != nil {
+				return frames, nil
+			}
+
+			if err := skipColorTable(br, descriptor[8]); err != nil {
+				return frames, nil
+			}
+
+			// The minimum code size of LZW, then the sub-blocks of the pixels
+			if _, err := br.Discard(1); err != nil {
+				return frames, nil
+			}
+
+			frames++
+		case gifTrailer:
+			return frames, nil
+		default:
+			return 0, fmt.Errorf("%w: unknown block 0x%02x", errGIFFormat, block)
+		}
+
+		if err := skipSubBlocks(br); err != nil {
+			return frames, nil
+		}
+	}
+}
+
+// skipColorTable skips the color table described by the packed fields of the descriptor.
+func skipColorTable(br *bufio.Reader, fields byte) error {
+	if fields&gifColorTableFlag == 0 {
+		return nil
+	}
+
+	if _, err := br.Discard(3 << ((fields & 0x07) + 1)); err != nil {
+		return fmt.Errorf("%w: read color table: %s", errGIFFormat, err)
+	}
+
+	return nil
+}
+
+// skipSubBlocks skips the data sub-blocks up to the terminator.
+func skipSubBlocks(br *bufio.Reader) error {
+	for {
+		size, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		if size == 0 {
+			return nil
+		}
+
+		if _, err := br.Discard(int(size)); err != nil {
+			return err
+		}
+	}
+}
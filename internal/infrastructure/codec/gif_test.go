@@ -0,0 +1,60 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"testing"
+)
+
+func TestCountGIFFrames(t *testing.T) {
+	// The frames with their own palettes get the local color tables,
+	// the loop count and the delays add the extensions
+	anim := &gif.GIF{LoopCount: 0}
+	for i := 0; i < 5; i++ {
+		p := palette.Plan9
+		if i%2 == 1 {
+			p = color.Palette{color.Black, color.White}
+		}
+
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 30, 20), p))
+		anim.Delay = append(anim.Delay, 10)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	data := buf.Bytes()
+
+	tests := []struct {
+		name   string
+		data   []byte
+		frames int
+	}{
+		{"animation", data, 5},
+		{"without the trailer", data[:len(data)-1], 5},
+		{"truncated", data[:len(data)/2], 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frames, err := countGIFFrames(bytes.NewReader(test.data))
+			if err != nil {
+				t.Fatalf("countGIFFrames: %v", err)
+			}
+
+			if frames != test.frames {
+				t.Fatalf("got %d frames, want %d", frames, test.frames)
+			}
+		})
+	}
+
+	if _, err := countGIFFrames(bytes.NewReader([]byte("GIF89a"))); !errors.Is(err, errGIFFormat) {
+		t.Fatalf("short header: got %v, want %v", err, errGIFFormat)
+	}
+}
@@ -0,0 +1,112 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+)
+
+var (
+	// ErrTooLarge is returned for the images with the dimensions over the limits.
+	ErrTooLarge = errors.New("image dimensions exceed the limits")
+	// ErrNoConfig is returned if the dimensions can't be read within the header limit,
+	// or the format has no DecodeConfig.
+	ErrNoConfig = errors.New("image dimensions are unknown")
+)
+
+/*
+Limits are the maximum dimensions of the images that are decoded.
+
+The size of the file says nothing about the decoded image:
+a small PNG of one color may decode to gigabytes of pixels (decompression bomb),
+so the dimensions are checked by the header before decoding.
+Zero disables the limit.
+*/
+type Limits struct {
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int64
+}
+
+// Check returns ErrTooLarge if the dimensions of the image exceed the limits.
+func (l Limits) Check(config image.Config) error {
+	pixels := int64(config.Width) * int64(config.Height)
+
+	switch {
+	case config.Width <= 0 || config.Height <= 0:
+		return fmt.Errorf("%w: %dx%d is not a valid size", ErrTooLarge, config.Width, config.Height)
+	case l.MaxWidth > 0 && config.Width > l.MaxWidth:
+		return fmt.Errorf("%w: width %d is over %d", ErrTooLarge, config.Width, l.MaxWidth)
+	case l.MaxHeight > 0 && config.Height > l.MaxHeight:
+		return fmt.Errorf("%w: height %d is over %d", ErrTooLarge, config.Height, l.MaxHeight)
+	case l.MaxPixels > 0 && pixels > l.MaxPixels:
+		return fmt.Errorf("%w: %d pixels are over %d", ErrTooLarge, pixels, l.MaxPixels)
+	}
+
+	return nil
+}
+
+/*
+CheckStream reads the dimensions of the image by the header of its format,
+checks them and rewinds the stream, so it can be decoded after the check.
+
+The unknown formats and the broken headers are left to the decoder,
+only the errors of reading and rewinding the stream are returned besides ErrTooLarge.
+*/
+func (l Limits) CheckStream(src io.ReadSeeker) error {
+	header := make([]byte, SniffLen)
+
+	n, err := io.ReadFull(src, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("read header: %w", err)
+	}
+
+	format, err := Detect(header[:n])
+	if err == nil && format.DecodeConfig != nil {
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("rewind: %w", err)
+		}
+
+		if config, err := format.DecodeConfig(src); err == nil {
+			if err := l.Check(config); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("rewind: %w", err)
+	}
+
+	return nil
+}
+
+/*
+PeekConfig reads the dimensions of the image from the start of the stream.
+
+The bytes read by the decoder (up to limit) are buffered,
+the returned reader streams them again with the rest of the image,
+so the stream can be stored or decoded after the check.
+*/
+func (c Codec) PeekConfig(r io.Reader, limit int) (image.Config, io.Reader, error) {
+	if c.DecodeConfig == nil {
+		return image.Config{}, r, fmt.Errorf("%w: %s has no header decoder", ErrNoConfig, c.ContentType)
+	}
+
+	header := new(bytes.Buffer)
+
+	config, err := c.DecodeConfig(io.TeeReader(io.LimitReader(r, int64(limit)), header))
+	replay := io.MultiReader(header, r)
+
+	if err != nil && header.Len() >= limit {
+		return image.Config{}, replay, fmt.Errorf("%w: no dimensions in the first %d bytes", ErrNoConfig, limit)
+	}
+
+	if err != nil {
+		return image.Config{}, replay, fmt.Errorf("%s: %w", c.ContentType, err)
+	}
+
+	return config, replay, nil
+}
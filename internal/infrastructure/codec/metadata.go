@@ -0,0 +1,65 @@
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/exif"
+	"golang.org/x/image/tiff"
+)
+
+/*
+MetadataFilter removes the metadata of the image that the policy doesn't keep.
+
+It returns the reader of the filtered image and the kept metadata (nil if there is none).
+The images that are decoded for it are checked against the limits first.
+*/
+type MetadataFilter func(r io.Reader, policy exif.Policy, limits Limits) (io.Reader, *exif.EXIF, error)
+
+// filterChunks adapts the filters of the containers, which don't decode the image, to the MetadataFilter.
+func filterChunks(filter func(r io.Reader, policy exif.Policy) (io.Reader, *exif.EXIF, error)) MetadataFilter {
+	return func(r io.Reader, policy exif.Policy, _ Limits) (io.Reader, *exif.EXIF, error) {
+		return filter(r, policy)
+	}
+}
+
+/*
+filterTIFF re-encodes the TIFF without any metadata unless the policy keeps everything.
+
+The metadata of the TIFF lives in the same directories as the layout of the image,
+so the image is decoded and encoded again (losslessly): only the pixels stay,
+the whitelisted tags are lost as well. The whole TIFF is read into memory for it.
+*/
+func filterTIFF(r io.Reader, policy exif.Policy, limits Limits) (io.Reader, *exif.EXIF, error) {
+	if policy.Mode == exif.PolicyKeep {
+		return r, nil, nil
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read TIFF: %w", err)
+	}
+
+	// The directory may be at the end of the file, it's found only in the whole TIFF
+	config, err := tiff.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("read TIFF header: %w", err)
+	}
+
+	if err := limits.Check(config); err != nil {
+		return nil, nil, err
+	}
+
+	img, err := tiff.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode TIFF: %w", err)
+	}
+
+	encoded := new(bytes.Buffer)
+	if err := tiff.Encode(encoded, img, &tiff.Options{Compression: tiff.Deflate, Predictor: true}); err != nil {
+		return nil, nil, fmt.Errorf("encode TIFF: %w", err)
+	}
+
+	return encoded, nil, nil
+}
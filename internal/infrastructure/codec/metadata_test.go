@@ -0,0 +1,62 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"testing"
+
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/exif"
+	"golang.org/x/image/tiff"
+)
+
+func TestFilterTIFF(t *testing.T) {
+	// The bytes after the image stand for the metadata, only the pixels are encoded again
+	var encoded bytes.Buffer
+	if err := tiff.Encode(&encoded, image.NewGray(image.Rect(0, 0, 20, 10)), nil); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	original := append(encoded.Bytes(), []byte("secret-location")...)
+
+	tests := []struct {
+		name   string
+		policy exif.Policy
+		limits Limits
+		err    error
+		secret bool
+	}{
+		{"strip", exif.Policy{Mode: exif.PolicyStrip}, Limits{}, nil, false},
+		{"whitelist", exif.Policy{Mode: exif.PolicyWhitelist}, Limits{}, nil, false},
+		{"keep", exif.Policy{Mode: exif.PolicyKeep}, Limits{MaxWidth: 10}, nil, true},
+		{"over the limits", exif.Policy{Mode: exif.PolicyStrip}, Limits{MaxWidth: 10}, ErrTooLarge, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filtered, _, err := filterTIFF(bytes.NewReader(original), test.policy, test.limits)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got %v, want %v", err, test.err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			data, err := io.ReadAll(filtered)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+
+			if got := bytes.Contains(data, []byte("secret-location")); got != test.secret {
+				t.Fatalf("the filtered TIFF has the trailing data: %t, want %t", got, test.secret)
+			}
+
+			config, err := tiff.DecodeConfig(bytes.NewReader(data))
+			if err != nil || config.Width != 20 || config.Height != 10 {
+				t.Fatalf("got %dx%d (%v), want 20x10", config.Width, config.Height, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+/*
+Open opens (or creates) the SQLite database by path.
+
+One pool is shared by the jobs and the image catalog, their repositories migrate their own tables.
+The busy timeout and WAL journal let the API and the worker write at the same time.
+*/
+func Open(path string, log logger.Logger) (*gorm.DB, error) {
+	log = log.Named("database")
+	log.Info("Opening the database", logger.M{"path": path})
+
+	dsn := fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL&_foreign_keys=on", path)
+
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	if err != nil {
+		log.Error("Can't open the database", logger.M{"error": err})
+
+		return nil, fmt.Errorf("open database '%s': %w", path, err)
+	}
+
+	return db, nil
+}
+
+// Close closes the pool of the connections to the database.
+func Close(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fmt.Errorf("get connection pool: %w", err)
+	}
+
+	if err := sqlDB.Close(); err != nil {
+		return fmt.Errorf("close database: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,431 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Header is the prefix of the EXIF data in the JPEG APP1 segment.
+const Header = "Exif\x00\x00"
+
+// The orientations of the image, only the tag values 1-8 are valid.
+const (
+	OrientationNormal = 1
+	orientationMax    = 8
+)
+
+var errInvalidEXIF = errors.New("invalid EXIF data")
+
+// The directories of the tags.
+const (
+	ifd0 = iota
+	ifdExif
+	ifdGPS
+)
+
+// The tags that point to the sub-directories, they are rebuilt on encoding.
+const (
+	tagExifPointer = 0x8769
+	tagGPSPointer  = 0x8825
+	tagOrientation = 0x0112
+)
+
+// The types of the values.
+const (
+	typeByte      = 1
+	typeASCII     = 2
+	typeShort     = 3
+	typeLong      = 4
+	typeRational  = 5
+	typeUndefined = 7
+	typeSLong     = 9
+	typeSRational = 10
+)
+
+// typeSizes are the sizes of one value of the types,
+// the signed byte and short, float and double are only copied.
+var typeSizes = map[uint16]uint32{
+	typeByte: 1, typeASCII: 1, typeShort: 2, typeLong: 4, typeRational: 8,
+	6: 1, typeUndefined: 1, 8: 2, typeSLong: 4, typeSRational: 8, 11: 4, 12: 8,
+}
+
+// tagNames are the names of the known tags by the directory.
+var tagNames = map[int]map[uint16]string{
+	ifd0: {
+		0x010e: "ImageDescription", 0x010f: "Make", 0x0110: "Model", tagOrientation: "Orientation",
+		0x011a: "XResolution", 0x011b: "YResolution", 0x0128: "ResolutionUnit",
+		0x0131: "Software", 0x0132: "DateTime", 0x013b: "Artist", 0x8298: "Copyright",
+	},
+	ifdExif: {
+		0x829a: "ExposureTime", 0x829d: "FNumber", 0x8822: "ExposureProgram", 0x8827: "ISOSpeedRatings",
+		0x9003: "DateTimeOriginal", 0x9004: "DateTimeDigitized", 0x9010: "OffsetTime",
+		0x9201: "ShutterSpeedValue", 0x9202: "ApertureValue", 0x9204: "ExposureBiasValue",
+		0x9207: "MeteringMode", 0x9209: "Flash", 0x920a: "FocalLength", 0x927c: "MakerNote",
+		0x9286: "UserComment", 0xa001: "ColorSpace", 0xa002: "PixelXDimension", 0xa003: "PixelYDimension",
+		0xa402: "ExposureMode", 0xa403: "WhiteBalance", 0xa405: "FocalLengthIn35mmFilm",
+		0xa420: "ImageUniqueID", 0xa430: "CameraOwnerName", 0xa431: "BodySerialNumber",
+		0xa433: "LensMake", 0xa434: "LensModel", 0xa435: "LensSerialNumber",
+	},
+	ifdGPS: {
+		0x0000: "GPSVersionID", 0x0001: "GPSLatitudeRef", 0x0002: "GPSLatitude",
+		0x0003: "GPSLongitudeRef", 0x0004: "GPSLongitude", 0x0005: "GPSAltitudeRef", 0x0006: "GPSAltitude",
+		0x0007: "GPSTimeStamp", 0x0010: "GPSImgDirectionRef", 0x0011: "GPSImgDirection",
+		0x001d: "GPSDateStamp",
+	},
+}
+
+// Tag is an entry of a directory, the value is kept in the byte order of the data.
+type Tag struct {
+	ID    uint16
+	Type  uint16
+	Count uint32
+	Value []byte
+
+	ifd int
+}
+
+// Name returns the name of the tag, the unknown tags are named by the directory and the ID.
+func (t Tag) Name() string {
+	if name, ok := tagNames[t.ifd][t.ID]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("%s.0x%04x", [...]string{"Image", "Exif", "GPS"}[t.ifd], t.ID)
+}
+
+/*
+EXIF is the metadata of the image: the tags of the main image (IFD0),
+of the camera (Exif IFD) and of the location (GPS IFD).
+
+The thumbnail (IFD1) and the unknown directories are dropped on parsing.
+*/
+type EXIF struct {
+	order binary.ByteOrder
+	tags  []Tag
+}
+
+// Parse parses the TIFF structure of the EXIF data (without the "Exif" header).
+func Parse(data []byte) (*EXIF, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("%w: too short", errInvalidEXIF)
+	}
+
+	var order binary.ByteOrder
+
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("%w: unknown byte order", errInvalidEXIF)
+	}
+
+	if order.Uint16(data[2:]) != 42 {
+		return nil, fmt.Errorf("%w: not a TIFF header", errInvalidEXIF)
+	}
+
+	e := &EXIF{order: order}
+
+	pointers, err := e.readIFD(data, order.Uint32(data[4:]), ifd0)
+	if err != nil {
+		return nil, err
+	}
+
+	for ifd, offset := range pointers {
+		if _, err := e.readIFD(data, offset, ifd); err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+// readIFD reads the tags of the directory and returns the offsets of the sub-directories.
+func (e *EXIF) readIFD(data []byte, offset uint32, ifd int) (map[int]uint32, error) {
+	if uint64(offset)+2 > uint64(len(data)) {
+		return nil, fmt.Errorf("%w: directory out of range", errInvalidEXIF)
+	}
+
+	count := int(e.order.Uint16(data[offset:]))
+	entries := data[offset+2:]
+
+	if len(entries) < count*12 {
+		return nil, fmt.Errorf("%w: directory out of range", errInvalidEXIF)
+	}
+
+	pointers := make(map[int]uint32)
+
+	for i := 0; i < count; i++ {
+		entry := entries[i*12 : i*12+12]
+
+		tag := Tag{
+			ID:    e.order.Uint16(entry),
+			Type:  e.order.Uint16(entry[2:]),
+			Count: e.order.Uint32(entry[4:]),
+			ifd:   ifd,
+		}
+
+		if ifd == ifd0 && (tag.ID == tagExifPointer || tag.ID == tagGPSPointer) {
+			if tag.ID == tagExifPointer {
+				pointers[ifdExif] = e.order.Uint32(entry[8:])
+			} else {
+				pointers[ifdGPS] = e.order.Uint32(entry[8:])
+			}
+
+			continue
+		}
+
+		size, ok := typeSizes[tag.Type]
+		if !ok {
+			// The values of the unknown types can't be copied
+			continue
+		}
+
+		length := uint64(size) * uint64(tag.Count)
+
+		// The values up to 4 bytes are stored in the entry itself
+		value := entry[8:12]
+		if length > 4 {
+			valueOffset := uint64(e.order.Uint32(entry[8:]))
+			if valueOffset+length > uint64(len(data)) {
+				continue
+			}
+
+			value = data[valueOffset : valueOffset+length]
+		}
+
+		tag.Value = append([]byte(nil), value[:length]...)
+		e.tags = append(e.tags, tag)
+	}
+
+	return pointers, nil
+}
+
+// Tags returns the tags of all directories.
+func (e *EXIF) Tags() []Tag {
+	return e.tags
+}
+
+// Orientation returns the orientation of the image (1-8), it's OrientationNormal if it isn't set.
+func (e *EXIF) Orientation() int {
+	if e == nil {
+		return OrientationNormal
+	}
+
+	for _, tag := range e.tags {
+		if tag.ifd == ifd0 && tag.ID == tagOrientation && tag.Type == typeShort && tag.Count == 1 {
+			if o := int(e.order.Uint16(tag.Value)); o >= OrientationNormal && o <= orientationMax {
+				return o
+			}
+		}
+	}
+
+	return OrientationNormal
+}
+
+// Filter returns the metadata with only the tags that the keep function accepts.
+func (e *EXIF) Filter(keep func(tag Tag) bool) *EXIF {
+	if e == nil {
+		return nil
+	}
+
+	filtered := &EXIF{order: e.order}
+
+	for _, tag := range e.tags {
+		if keep(tag) {
+			filtered.tags = append(filtered.tags, tag)
+		}
+	}
+
+	return filtered
+}
+
+// WithoutOrientation returns the metadata of the image that is already rotated.
+func (e *EXIF) WithoutOrientation() *EXIF {
+	return e.Filter(func(tag Tag) bool {
+		return tag.ifd != ifd0 || tag.ID != tagOrientation
+	})
+}
+
+// Empty reports whether there are no tags.
+func (e *EXIF) Empty() bool {
+	return e == nil || len(e.tags) == 0
+}
+
+// Fields returns the readable values of the tags by their names, the binary values are skipped.
+func (e *EXIF) Fields() map[string]string {
+	fields := make(map[string]string)
+
+	if e == nil {
+		return fields
+	}
+
+	for _, tag := range e.tags {
+		if value, ok := e.format(tag); ok {
+			fields[tag.Name()] = value
+		}
+	}
+
+	return fields
+}
+
+// format returns the readable value of the tag.
+func (e *EXIF) format(tag Tag) (string, bool) {
+	const maxValues = 8
+
+	if tag.Type == typeASCII {
+		return strings.TrimSpace(strings.TrimRight(string(tag.Value), "\x00")), true
+	}
+
+	if tag.Count > maxValues {
+		return "", false
+	}
+
+	values := make([]string, 0, tag.Count)
+
+	for i := uint32(0); i < tag.Count; i++ {
+		switch tag.Type {
+		case typeByte:
+			values = append(values, fmt.Sprint(tag.Value[i]))
+		case typeShort:
+			values = append(values, fmt.Sprint(e.order.Uint16(tag.Value[i*2:])))
+		case typeLong:
+			values = append(values, fmt.Sprint(e.order.Uint32(tag.Value[i*4:])))
+		case typeSLong:
+			values = append(values, fmt.Sprint(int32(e.order.Uint32(tag.Value[i*4:]))))
+		case typeRational:
+			values = append(values, fmt.Sprintf("%d/%d", e.order.Uint32(tag.Value[i*8:]), e.order.Uint32(tag.Value[i*8+4:])))
+		case typeSRational:
+			values = append(values, fmt.Sprintf("%d/%d",
+				int32(e.order.Uint32(tag.Value[i*8:])), int32(e.order.Uint32(tag.Value[i*8+4:]))))
+		default:
+			return "", false
+		}
+	}
+
+	return strings.Join(values, " "), true
+}
+
+/*
+Encode returns the TIFF structure of the metadata (without the "Exif" header).
+
+The directories are written one after another, each followed by the values that don't fit into the entries.
+*/
+func (e *EXIF) Encode() []byte {
+	const headerSize = 8
+
+	byIFD := make(map[int][]Tag)
+	for _, tag := range e.tags {
+		byIFD[tag.ifd] = append(byIFD[tag.ifd], tag)
+	}
+
+	// The sub-directories are referenced from IFD0 by the pointer tags
+	for _, sub := range []struct {
+		ifd int
+		id  uint16
+	}{{ifdExif, tagExifPointer}, {ifdGPS, tagGPSPointer}} {
+		if len(byIFD[sub.ifd]) > 0 {
+			byIFD[ifd0] = append(byIFD[ifd0], Tag{ID: sub.id, Type: typeLong, Count: 1, Value: make([]byte, 4), ifd: ifd0})
+		}
+	}
+
+	// The offsets of the directories are known before writing
+	offsets := make(map[int]uint32)
+	offset := uint32(headerSize)
+
+	for _, ifd := range []int{ifd0, ifdExif, ifdGPS} {
+		tags := byIFD[ifd]
+		if len(tags) == 0 && ifd != ifd0 {
+			continue
+		}
+
+		sort.Slice(tags, func(i, j int) bool { return tags[i].ID < tags[j].ID })
+
+		offsets[ifd] = offset
+		offset += dirSize(tags)
+	}
+
+	buffer := new(bytes.Buffer)
+
+	if e.order == binary.LittleEndian {
+		buffer.WriteString("II")
+	} else {
+		buffer.WriteString("MM")
+	}
+
+	_ = binary.Write(buffer, e.order, uint16(42))
+	_ = binary.Write(buffer, e.order, offsets[ifd0])
+
+	for _, ifd := range []int{ifd0, ifdExif, ifdGPS} {
+		dirOffset, ok := offsets[ifd]
+		if !ok {
+			continue
+		}
+
+		tags := byIFD[ifd]
+		values := new(bytes.Buffer)
+		valuesOffset := dirOffset + 2 + uint32(len(tags))*12 + 4
+
+		_ = binary.Write(buffer, e.order, uint16(len(tags)))
+
+		for _, tag := range tags {
+			_ = binary.Write(buffer, e.order, tag.ID)
+			_ = binary.Write(buffer, e.order, tag.Type)
+			_ = binary.Write(buffer, e.order, tag.Count)
+
+			switch {
+			case tag.ID == tagExifPointer && tag.ifd == ifd0:
+				_ = binary.Write(buffer, e.order, offsets[ifdExif])
+			case tag.ID == tagGPSPointer && tag.ifd == ifd0:
+				_ = binary.Write(buffer, e.order, offsets[ifdGPS])
+			case len(tag.Value) <= 4:
+				entry := make([]byte, 4)
+				copy(entry, tag.Value)
+				buffer.Write(entry)
+			default:
+				_ = binary.Write(buffer, e.order, valuesOffset+uint32(values.Len()))
+				values.Write(tag.Value)
+
+				// The values start on the word boundary
+				if values.Len()%2 == 1 {
+					values.WriteByte(0)
+				}
+			}
+		}
+
+		// There is no next directory (the thumbnail is dropped)
+		_ = binary.Write(buffer, e.order, uint32(0))
+		buffer.Write(values.Bytes())
+	}
+
+	return buffer.Bytes()
+}
+
+// dirSize returns the size of the directory with its values.
+func dirSize(tags []Tag) uint32 {
+	size := uint32(2 + len(tags)*12 + 4)
+
+	for _, tag := range tags {
+		if length := uint32(len(tag.Value)); length > 4 {
+			size += length + length%2
+		}
+	}
+
+	return size
+}
+
+// New creates the metadata with only the orientation of the image.
+func New(orientation int) *EXIF {
+	value := make([]byte, 2)
+	binary.BigEndian.PutUint16(value, uint16(orientation))
+
+	return &EXIF{
+		order: binary.BigEndian,
+		tags:  []Tag{{ID: tagOrientation, Type: typeShort, Count: 1, Value: value, ifd: ifd0}},
+	}
+}
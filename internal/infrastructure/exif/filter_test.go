@@ -0,0 +1,204 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// secret is the text of the XMP, the comments and the PNG texts that no policy but keep leaves.
+const secret = "secret-location"
+
+// testEXIF returns the EXIF (without the header) with the Make, the orientation 6 and the GPS latitude reference.
+func testEXIF() []byte {
+	var b bytes.Buffer
+
+	le := binary.LittleEndian
+	entry := func(tag, typ uint16, count, value uint32) {
+		_ = binary.Write(&b, le, tag)
+		_ = binary.Write(&b, le, typ)
+		_ = binary.Write(&b, le, count)
+		_ = binary.Write(&b, le, value)
+	}
+
+	// The header, IFD0 at 8 with 3 entries up to 50, the Make at 50, the GPS IFD at 58
+	b.WriteString("II*\x00")
+	_ = binary.Write(&b, le, uint32(8))
+	_ = binary.Write(&b, le, uint16(3))
+	entry(0x010f, typeASCII, 7, 50)
+	entry(tagOrientation, typeShort, 1, 6)
+	entry(tagGPSPointer, typeLong, 1, 58)
+	_ = binary.Write(&b, le, uint32(0))
+	b.WriteString("Camera\x00\x00")
+	_ = binary.Write(&b, le, uint16(1))
+	entry(0x0001, typeASCII, 2, 'N')
+	_ = binary.Write(&b, le, uint32(0))
+
+	return b.Bytes()
+}
+
+func testImage() image.Image {
+	return image.NewGray(image.Rect(0, 0, 8, 8))
+}
+
+// testJPEG returns the JPEG with the EXIF, the XMP and the comment segments.
+func testJPEG(t *testing.T) []byte {
+	t.Helper()
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, testImage(), nil); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	var b bytes.Buffer
+	b.Write(encoded.Bytes()[:2])
+	writeSegment(&b, segment{markerAPP1, append([]byte(Header), testEXIF()...)})
+	writeSegment(&b, segment{markerAPP1, []byte("http://ns.adobe.com/xap/1.0/\x00" + secret)})
+	writeSegment(&b, segment{markerCOM, []byte(secret)})
+	b.Write(encoded.Bytes()[2:])
+
+	return b.Bytes()
+}
+
+// testPNG returns the PNG with the eXIf chunk and the texts before and after the image data.
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, testImage()); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	data := encoded.Bytes()
+
+	// The signature and IHDR, then the image data and IEND (12 bytes)
+	const headerLen = 8 + 4 + 4 + 13 + 4
+
+	var b bytes.Buffer
+	b.Write(data[:headerLen])
+	writeChunk(&b, "eXIf", testEXIF())
+	writeChunk(&b, "tEXt", []byte("Comment\x00"+secret))
+	b.Write(data[headerLen : len(data)-12])
+	writeChunk(&b, "iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"+secret))
+	b.Write(data[len(data)-12:])
+
+	return b.Bytes()
+}
+
+// testWebP returns the extended lossless WebP of 1x1 pixel with the EXIF and the XMP chunks.
+func testWebP(t *testing.T) []byte {
+	t.Helper()
+
+	simple, err := base64.StdEncoding.DecodeString("UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA==")
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("RIFF\x00\x00\x00\x00WEBP")
+	writeRIFFChunk(&b, "VP8X", []byte{webpFlagEXIF | webpFlagXMP, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	b.Write(simple[12:])
+	writeRIFFChunk(&b, "EXIF", testEXIF())
+	writeRIFFChunk(&b, "XMP ", []byte(secret))
+
+	data := b.Bytes()
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+
+	return data
+}
+
+func TestFilter(t *testing.T) {
+	containers := []struct {
+		name   string
+		data   func(t *testing.T) []byte
+		filter func(r io.Reader, policy Policy) (io.Reader, *EXIF, error)
+		decode func(r io.Reader) (image.Image, error)
+	}{
+		{"jpeg", testJPEG, FilterJPEG, jpeg.Decode},
+		{"png", testPNG, FilterPNG, png.Decode},
+		{"webp", testWebP, FilterWebP, webp.Decode},
+	}
+
+	policies := []struct {
+		policy Policy
+		// tags are the names of the tags left in the filtered image
+		tags   []string
+		secret bool
+	}{
+		{Policy{Mode: PolicyStrip}, []string{"Orientation"}, false},
+		{Policy{Mode: PolicyKeep}, []string{"Make", "Orientation", "GPSLatitudeRef"}, true},
+		{Policy{Mode: PolicyWhitelist, Whitelist: []string{"Make"}}, []string{"Make", "Orientation"}, false},
+	}
+
+	for _, container := range containers {
+		for _, test := range policies {
+			t.Run(container.name+"/"+test.policy.Mode, func(t *testing.T) {
+				filtered, kept, err := container.filter(bytes.NewReader(container.data(t)), test.policy)
+				if err != nil {
+					t.Fatalf("filter: %v", err)
+				}
+
+				data, err := io.ReadAll(filtered)
+				if err != nil {
+					t.Fatalf("read: %v", err)
+				}
+
+				if _, err := container.decode(bytes.NewReader(data)); err != nil {
+					t.Fatalf("the filtered image is broken: %v", err)
+				}
+
+				if got := bytes.Contains(data, []byte(secret)); got != test.secret {
+					t.Fatalf("the filtered image has the texts: %t, want %t", got, test.secret)
+				}
+
+				// The kept metadata is returned and stays in the image
+				_, left, err := container.filter(bytes.NewReader(data), Policy{Mode: PolicyKeep})
+				if err != nil {
+					t.Fatalf("filter the filtered image: %v", err)
+				}
+
+				for _, e := range []*EXIF{kept, left} {
+					if names := tagNamesOf(e); !equal(names, test.tags) {
+						t.Fatalf("got tags %v, want %v", names, test.tags)
+					}
+				}
+			})
+		}
+	}
+}
+
+func tagNamesOf(e *EXIF) []string {
+	var names []string
+	for _, tag := range e.Tags() {
+		names = append(names, tag.Name())
+	}
+
+	return names
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestFilterPNGRejectsOtherFormats(t *testing.T) {
+	if _, _, err := FilterPNG(bytes.NewReader(testJPEG(t)), Policy{Mode: PolicyStrip}); err == nil {
+		t.Fatal("the JPEG is accepted as PNG")
+	}
+}
@@ -0,0 +1,196 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MaxHeaderLen is the limit of the JPEG segments and the PNG chunks before the image data that are read into memory.
+const MaxHeaderLen = 1 << 20
+
+// The JPEG markers.
+const (
+	markerSOI   = 0xd8
+	markerEOI   = 0xd9
+	markerSOS   = 0xda
+	markerAPP0  = 0xe0
+	markerAPP1  = 0xe1
+	markerAPP2  = 0xe2
+	markerAPP14 = 0xee
+	markerAPP15 = 0xef
+	markerCOM   = 0xfe
+)
+
+var (
+	errInvalidJPEG = errors.New("invalid JPEG")
+	errTooBig      = errors.New("image header is too big")
+)
+
+// segment is the marker segment of the JPEG, data is the payload without the length.
+type segment struct {
+	marker byte
+	data   []byte
+}
+
+// isMetadata reports whether the segment carries the metadata: EXIF, XMP, IPTC, comments.
+// JFIF, ICC profile and Adobe segments are needed to display the image correctly.
+func (s segment) isMetadata() bool {
+	switch {
+	case s.marker == markerAPP0, s.marker == markerAPP14:
+		return false
+	case s.marker == markerAPP2:
+		return !bytes.HasPrefix(s.data, []byte("ICC_PROFILE\x00"))
+	case s.marker >= markerAPP1 && s.marker <= markerAPP15, s.marker == markerCOM:
+		return true
+	default:
+		return false
+	}
+}
+
+// isEXIF reports whether the segment is the EXIF segment.
+func (s segment) isEXIF() bool {
+	return s.marker == markerAPP1 && bytes.HasPrefix(s.data, []byte(Header))
+}
+
+/*
+FilterJPEG reads the segments of the JPEG up to the image data and applies the policy to them.
+
+It returns the reader of the filtered JPEG that streams the rest of the image from r,
+and the metadata that is kept (nil if there is none).
+The malformed EXIF is dropped unless the policy keeps everything.
+*/
+func FilterJPEG(r io.Reader, policy Policy) (io.Reader, *EXIF, error) {
+	segments, tail, err := readSegments(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var parsed *EXIF
+
+	for _, s := range segments {
+		if s.isEXIF() && parsed == nil {
+			// The malformed EXIF is the same as the missing one
+			parsed, _ = Parse(s.data[len(Header):])
+		}
+	}
+
+	kept := policy.Apply(parsed)
+
+	buffer := new(bytes.Buffer)
+	buffer.Write([]byte{0xff, markerSOI})
+
+	if policy.Mode == PolicyKeep {
+		for _, s := range segments {
+			writeSegment(buffer, s)
+		}
+
+		return io.MultiReader(buffer, bytes.NewReader(tail), r), kept, nil
+	}
+
+	// The EXIF goes after JFIF that must be the first segment
+	inserted := kept.Empty()
+
+	for _, s := range segments {
+		if !inserted && s.marker != markerAPP0 {
+			writeSegment(buffer, segment{markerAPP1, append([]byte(Header), kept.Encode()...)})
+
+			inserted = true
+		}
+
+		if !s.isMetadata() {
+			writeSegment(buffer, s)
+		}
+	}
+
+	return io.MultiReader(buffer, bytes.NewReader(tail), r), kept, nil
+}
+
+// ReadJPEG returns the metadata of the JPEG and the reader of the whole JPEG.
+func ReadJPEG(r io.Reader) (io.Reader, *EXIF, error) {
+	return FilterJPEG(r, Policy{Mode: PolicyKeep})
+}
+
+/*
+readSegments reads the segments after SOI up to the start of the image data (SOS).
+The tail is the SOS marker, it's returned as is with the rest of the stream.
+*/
+func readSegments(r io.Reader) ([]segment, []byte, error) {
+	var (
+		segments []segment
+		read     int
+		marker   = make([]byte, 2)
+	)
+
+	if _, err := io.ReadFull(r, marker); err != nil || marker[0] != 0xff || marker[1] != markerSOI {
+		return nil, nil, fmt.Errorf("%w: no start of image", errInvalidJPEG)
+	}
+
+	for {
+		if _, err := io.ReadFull(r, marker); err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", errInvalidJPEG, err)
+		}
+
+		if marker[0] != 0xff {
+			return nil, nil, fmt.Errorf("%w: no marker", errInvalidJPEG)
+		}
+
+		// The fill bytes may go before the marker
+		for marker[1] == 0xff {
+			if _, err := io.ReadFull(r, marker[1:]); err != nil {
+				return nil, nil, fmt.Errorf("%w: %s", errInvalidJPEG, err)
+			}
+		}
+
+		if marker[1] == markerSOS || marker[1] == markerEOI {
+			return segments, []byte{0xff, marker[1]}, nil
+		}
+
+		length := make([]byte, 2)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", errInvalidJPEG, err)
+		}
+
+		size := int(binary.BigEndian.Uint16(length))
+		if size < 2 {
+			return nil, nil, fmt.Errorf("%w: wrong segment length", errInvalidJPEG)
+		}
+
+		read += size + 2
+		if read > MaxHeaderLen {
+			return nil, nil, errTooBig
+		}
+
+		data := make([]byte, size-2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", errInvalidJPEG, err)
+		}
+
+		segments = append(segments, segment{marker[1], data})
+	}
+}
+
+// writeSegment writes the marker, the length and the payload of the segment.
+func writeSegment(w *bytes.Buffer, s segment) {
+	w.Write([]byte{0xff, s.marker})
+	_ = binary.Write(w, binary.BigEndian, uint16(len(s.data)+2))
+	w.Write(s.data)
+}
+
+// InjectJPEG inserts the EXIF segment into the encoded JPEG right after SOI.
+func InjectJPEG(data []byte, e *EXIF) []byte {
+	const soiLen = 2
+
+	if e.Empty() || len(data) < soiLen {
+		return data
+	}
+
+	buffer := bytes.NewBuffer(make([]byte, 0, len(data)+1024))
+	buffer.Write(data[:soiLen])
+	writeSegment(buffer, segment{markerAPP1, append([]byte(Header), e.Encode()...)})
+	buffer.Write(data[soiLen:])
+
+	return buffer.Bytes()
+}
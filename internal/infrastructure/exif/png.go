@@ -0,0 +1,210 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// pngSignature starts every PNG.
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+var errInvalidPNG = errors.New("invalid PNG")
+
+// isPNGMetadata reports whether the chunk of the type carries the metadata: EXIF, texts (XMP goes in iTXt), time.
+// The color chunks (gAMA, cHRM, sRGB, iCCP) are needed to display the image correctly.
+func isPNGMetadata(chunkType string) bool {
+	switch chunkType {
+	case "eXIf", "tEXt", "iTXt", "zTXt", "tIME":
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+FilterPNG reads the chunks of the PNG up to the image data and applies the policy to them.
+
+It returns the reader of the filtered PNG that streams the rest of the image from r,
+the metadata chunks after the image data are dropped on the way, and the metadata that is kept (nil if there is none).
+The kept EXIF is written as the eXIf chunk right after IHDR.
+*/
+func FilterPNG(r io.Reader, policy Policy) (io.Reader, *EXIF, error) {
+	signature := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, signature); err != nil || string(signature) != pngSignature {
+		return nil, nil, fmt.Errorf("%w: no signature", errInvalidPNG)
+	}
+
+	chunks, first, err := readChunks(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var parsed *EXIF
+
+	for _, c := range chunks {
+		if c.typ == "eXIf" && parsed == nil {
+			// Some writers keep the JPEG header, the malformed EXIF is the same as the missing one
+			parsed, _ = Parse(bytes.TrimPrefix(c.data, []byte(Header)))
+		}
+	}
+
+	kept := policy.Apply(parsed)
+
+	buffer := new(bytes.Buffer)
+	buffer.WriteString(pngSignature)
+
+	if policy.Mode == PolicyKeep {
+		for _, c := range chunks {
+			writeChunk(buffer, c.typ, c.data)
+		}
+
+		return io.MultiReader(buffer, bytes.NewReader(first), r), kept, nil
+	}
+
+	for i, c := range chunks {
+		if !isPNGMetadata(c.typ) {
+			writeChunk(buffer, c.typ, c.data)
+		}
+
+		// IHDR must be the first chunk
+		if i == 0 && !kept.Empty() {
+			writeChunk(buffer, "eXIf", kept.Encode())
+		}
+	}
+
+	return io.MultiReader(buffer, &pngChunkFilter{r: r, pending: first}), kept, nil
+}
+
+// chunk is the chunk of the PNG, data is the payload without the length and the CRC.
+type chunk struct {
+	typ  string
+	data []byte
+}
+
+/*
+readChunks reads the chunks after the signature up to the image data (IDAT).
+The first is the header (length and type) of the first IDAT chunk, its data is left in the stream.
+*/
+func readChunks(r io.Reader) ([]chunk, []byte, error) {
+	var (
+		chunks []chunk
+		read   int
+	)
+
+	for {
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", errInvalidPNG, err)
+		}
+
+		typ := string(header[4:])
+		if typ == "IDAT" || typ == "IEND" {
+			return chunks, header, nil
+		}
+
+		size := int64(binary.BigEndian.Uint32(header))
+
+		read += int(size) + 12
+		if size > MaxHeaderLen || read > MaxHeaderLen {
+			return nil, nil, errTooBig
+		}
+
+		// The data and the CRC, which is computed again on writing
+		data := make([]byte, size+4)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", errInvalidPNG, err)
+		}
+
+		chunks = append(chunks, chunk{typ, data[:size]})
+	}
+}
+
+// pngChunkFilter streams the chunks of the PNG and drops the metadata chunks.
+type pngChunkFilter struct {
+	r io.Reader
+	// pending is the header of the chunk that is passed, left is the rest of its data and CRC
+	pending []byte
+	left    int64
+}
+
+func (f *pngChunkFilter) Read(p []byte) (int, error) {
+	for {
+		if len(f.pending) > 0 {
+			if len(f.pending) == 8 {
+				f.left = int64(binary.BigEndian.Uint32(f.pending)) + 4
+			}
+
+			n := copy(p, f.pending)
+			f.pending = f.pending[n:]
+
+			return n, nil
+		}
+
+		if f.left > 0 {
+			if int64(len(p)) > f.left {
+				p = p[:f.left]
+			}
+
+			n, err := f.r.Read(p)
+			f.left -= int64(n)
+
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return n, err
+		}
+
+		header := make([]byte, 8)
+		if n, err := io.ReadFull(f.r, header); err != nil {
+			if n == 0 && errors.Is(err, io.EOF) {
+				return 0, io.EOF
+			}
+
+			return 0, fmt.Errorf("%w: %s", errInvalidPNG, err)
+		}
+
+		if !isPNGMetadata(string(header[4:])) {
+			f.pending = header
+
+			continue
+		}
+
+		size := int64(binary.BigEndian.Uint32(header)) + 4
+		if _, err := io.CopyN(io.Discard, f.r, size); err != nil {
+			return 0, fmt.Errorf("%w: %s", errInvalidPNG, err)
+		}
+	}
+}
+
+// writeChunk writes the length, the type, the data and the CRC of the chunk.
+func writeChunk(w *bytes.Buffer, typ string, data []byte) {
+	_ = binary.Write(w, binary.BigEndian, uint32(len(data)))
+
+	body := append([]byte(typ), data...)
+	w.Write(body)
+	_ = binary.Write(w, binary.BigEndian, crc32.ChecksumIEEE(body))
+}
+
+// InjectPNG inserts the eXIf chunk into the encoded PNG right after IHDR.
+func InjectPNG(data []byte, e *EXIF) []byte {
+	// The signature and the IHDR chunk (length, type, 13 bytes of data, CRC)
+	const headerLen = 8 + 4 + 4 + 13 + 4
+
+	if e.Empty() || len(data) < headerLen {
+		return data
+	}
+
+	payload := e.Encode()
+
+	buffer := bytes.NewBuffer(make([]byte, 0, len(data)+len(payload)+12))
+	buffer.Write(data[:headerLen])
+	writeChunk(buffer, "eXIf", payload)
+	buffer.Write(data[headerLen:])
+
+	return buffer.Bytes()
+}
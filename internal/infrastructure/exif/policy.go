@@ -0,0 +1,66 @@
+package exif
+
+import "fmt"
+
+// The policies of the metadata in the stored images.
+const (
+	PolicyStrip     = "strip"
+	PolicyKeep      = "keep"
+	PolicyWhitelist = "whitelist"
+)
+
+// Policies are the known policies.
+var Policies = []string{PolicyStrip, PolicyKeep, PolicyWhitelist}
+
+/*
+Policy decides which metadata stays in the stored images:
+
+	strip      nothing but the orientation of the original
+	keep       everything, the variants get all tags but the thumbnail
+	whitelist  only the tags named in the whitelist (and the orientation of the original)
+
+The orientation is needed to display the original the right way,
+the variants are rotated before resizing and never carry it.
+*/
+type Policy struct {
+	Mode      string
+	Whitelist []string
+}
+
+// Validate checks the mode of the policy.
+func (p Policy) Validate() error {
+	for _, mode := range Policies {
+		if p.Mode == mode {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown metadata policy '%s', must be one of %v", p.Mode, Policies)
+}
+
+// Apply returns the metadata of the original that the policy keeps.
+func (p Policy) Apply(e *EXIF) *EXIF {
+	if e == nil {
+		return nil
+	}
+
+	switch p.Mode {
+	case PolicyKeep:
+		return e
+	case PolicyWhitelist:
+		allowed := make(map[string]bool, len(p.Whitelist))
+		for _, name := range p.Whitelist {
+			allowed[name] = true
+		}
+
+		return e.Filter(func(tag Tag) bool {
+			return allowed[tag.Name()] || (tag.ifd == ifd0 && tag.ID == tagOrientation)
+		})
+	default:
+		if o := e.Orientation(); o != OrientationNormal {
+			return New(o)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,117 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The flags of the VP8X chunk that announce the metadata chunks.
+const (
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+var errInvalidWebP = errors.New("invalid WebP")
+
+/*
+FilterWebP applies the policy to the EXIF and XMP chunks of the WebP.
+
+The metadata chunks follow the image data and the RIFF header holds the size of the file,
+so the WebP is read into memory whole. It returns the reader of the filtered WebP
+and the metadata that is kept (nil if there is none). The kept EXIF is written as the EXIF chunk,
+only the extended WebP (with the VP8X chunk) may carry it.
+*/
+func FilterWebP(r io.Reader, policy Policy) (io.Reader, *EXIF, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", errInvalidWebP, err)
+	}
+
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, nil, fmt.Errorf("%w: no RIFF header", errInvalidWebP)
+	}
+
+	var (
+		chunks []chunk
+		parsed *EXIF
+	)
+
+	for rest := data[12:]; len(rest) >= 8; {
+		size := uint64(binary.LittleEndian.Uint32(rest[4:]))
+		if size > uint64(len(rest)-8) {
+			return nil, nil, fmt.Errorf("%w: chunk out of range", errInvalidWebP)
+		}
+
+		c := chunk{string(rest[:4]), rest[8 : 8+size]}
+		chunks = append(chunks, c)
+
+		if c.typ == "EXIF" && parsed == nil {
+			// Some writers keep the JPEG header, the malformed EXIF is the same as the missing one
+			parsed, _ = Parse(bytes.TrimPrefix(c.data, []byte(Header)))
+		}
+
+		// The chunks are padded to the even size
+		next := 8 + size + size%2
+		if next > uint64(len(rest)) {
+			next = uint64(len(rest))
+		}
+
+		rest = rest[next:]
+	}
+
+	kept := policy.Apply(parsed)
+
+	if policy.Mode == PolicyKeep {
+		return bytes.NewReader(data), kept, nil
+	}
+
+	buffer := bytes.NewBuffer(make([]byte, 0, len(data)))
+	buffer.WriteString("RIFF\x00\x00\x00\x00WEBP")
+
+	extended := false
+
+	for _, c := range chunks {
+		switch c.typ {
+		case "EXIF", "XMP ":
+			continue
+		case "VP8X":
+			extended = true
+
+			if len(c.data) > 0 {
+				flags := append([]byte(nil), c.data...)
+				flags[0] &^= webpFlagEXIF | webpFlagXMP
+
+				if !kept.Empty() {
+					flags[0] |= webpFlagEXIF
+				}
+
+				c.data = flags
+			}
+		}
+
+		writeRIFFChunk(buffer, c.typ, c.data)
+	}
+
+	if extended && !kept.Empty() {
+		writeRIFFChunk(buffer, "EXIF", kept.Encode())
+	}
+
+	filtered := buffer.Bytes()
+	binary.LittleEndian.PutUint32(filtered[4:], uint32(len(filtered)-8))
+
+	return bytes.NewReader(filtered), kept, nil
+}
+
+// writeRIFFChunk writes the type, the size and the data of the chunk padded to the even size.
+func writeRIFFChunk(w *bytes.Buffer, typ string, data []byte) {
+	w.WriteString(typ)
+	_ = binary.Write(w, binary.LittleEndian, uint32(len(data)))
+	w.Write(data)
+
+	if len(data)%2 == 1 {
+		w.WriteByte(0)
+	}
+}
@@ -0,0 +1,247 @@
+package repository
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/file"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+)
+
+// derivedDirectory is the directory of the cache inside the storage, it's hidden from the image IDs.
+const derivedDirectory = ".derived"
+
+// cacheEntry is a file of the derived image.
+type cacheEntry struct {
+	path string
+	size int64
+}
+
+/*
+derivedCache is the least recently used index of the derived images on disk.
+
+The total size of the files is kept under maxBytes,
+the oldest files are removed when a new one is added.
+*/
+type derivedCache struct {
+	directoryPath string
+	maxBytes      int64
+	logger        logger.Logger
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+var _ file.Cache = (*derivedCache)(nil)
+
+// newDerivedCache creates the cache directory and indexes the files left by the previous run.
+//
+// The cache is kept on the local disk for every storage, it is only a copy of the derived data.
+func newDerivedCache(directoryPath string, maxBytes int64, log logger.Logger) (*derivedCache, error) {
+	cache := &derivedCache{
+		directoryPath: directoryPath,
+		maxBytes:      maxBytes,
+		logger:        log.Named("derived cache"),
+		order:         list.New(),
+		entries:       make(map[string]*list.Element),
+	}
+
+	if err := os.MkdirAll(directoryPath, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("MkdirAll: %w", err)
+	}
+
+	type found struct {
+		cacheEntry
+		modTime time.Time
+	}
+
+	var files []found
+
+	err := filepath.WalkDir(directoryPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		// The temporary files of the interrupted writes
+		if strings.HasPrefix(entry.Name(), ".") {
+			return os.Remove(path)
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		files = append(files, found{cacheEntry{path, info.Size()}, info.ModTime()})
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk: %w", err)
+	}
+
+	// The most recently used files are at the front
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.After(files[j].modTime)
+	})
+
+	for _, f := range files {
+		cache.entries[f.path] = cache.order.PushBack(f.cacheEntry)
+		cache.size += f.size
+	}
+
+	cache.evict()
+
+	return cache, nil
+}
+
+// path returns the path of the derived image.
+func (d *derivedCache) path(id, key string) string {
+	return filepath.Join(d.directoryPath, id, key)
+}
+
+// touch marks the file as recently used.
+func (d *derivedCache) touch(path string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	element, ok := d.entries[path]
+	if !ok {
+		return false
+	}
+
+	d.order.MoveToFront(element)
+
+	// The time of use is kept on disk for the next run
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+
+	return true
+}
+
+// add indexes the written file and evicts the least recently used ones.
+func (d *derivedCache) add(path string, size int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if element, ok := d.entries[path]; ok {
+		d.size -= element.Value.(cacheEntry).size
+		d.order.Remove(element)
+	}
+
+	d.entries[path] = d.order.PushFront(cacheEntry{path, size})
+	d.size += size
+
+	d.evict()
+}
+
+// evict removes the least recently used files until the cache fits into maxBytes, d.mu must be held.
+func (d *derivedCache) evict() {
+	for d.size > d.maxBytes && d.order.Len() > 0 {
+		element := d.order.Back()
+		entry := element.Value.(cacheEntry)
+
+		d.order.Remove(element)
+		delete(d.entries, entry.path)
+		d.size -= entry.size
+
+		_ = os.Remove(entry.path)
+	}
+}
+
+// deleteDerived removes all derived images of the image from the cache.
+func (d *derivedCache) deleteDerived(imageID string) error {
+	dir := filepath.Join(d.directoryPath, imageID)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for path, element := range d.entries {
+		if filepath.Dir(path) == dir {
+			d.size -= element.Value.(cacheEntry).size
+			d.order.Remove(element)
+			delete(d.entries, path)
+		}
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		d.logger.Error("Error on deleting derived images", logger.M{
+			"error": err,
+			"id":    imageID,
+		})
+
+		return fmt.Errorf("can't delete the derived images of '%s': %w", imageID, err)
+	}
+
+	return nil
+}
+
+// GetDerived returns the derived image from the cache or file.ErrCacheMiss.
+func (d *derivedCache) GetDerived(imageID string, key string) ([]byte, error) {
+	path := d.path(imageID, key)
+
+	if !d.touch(path) {
+		return nil, fmt.Errorf("%w: '%s' of '%s'", file.ErrCacheMiss, key, imageID)
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: '%s' of '%s'", file.ErrCacheMiss, key, imageID)
+	}
+
+	if err != nil {
+		d.logger.Error("Error reading derived image", logger.M{
+			"error": err,
+			"id":    imageID,
+			"key":   key,
+		})
+
+		return nil, fmt.Errorf("can't read the derived image '%s': %w", imageID, err)
+	}
+
+	return data, nil
+}
+
+// PutDerived stores the derived image in the cache.
+func (d *derivedCache) PutDerived(data []byte, imageID string, key string) error {
+	// The image that is bigger than the whole cache isn't stored
+	if int64(len(data)) > d.maxBytes {
+		return nil
+	}
+
+	path := d.path(imageID, key)
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("can't create a directory '%s': %w", filepath.Dir(path), err)
+	}
+
+	if _, err := writeFileAtomically(path, bytes.NewReader(data)); err != nil {
+		d.logger.Error("Error on caching derived image", logger.M{
+			"error": err,
+			"id":    imageID,
+			"key":   key,
+		})
+
+		return fmt.Errorf("can't cache the derived image '%s': %w", imageID, err)
+	}
+
+	d.add(path, int64(len(data)))
+
+	d.logger.Debug("Derived image cached", logger.M{
+		"id":  imageID,
+		"key": key,
+	})
+
+	return nil
+}
@@ -0,0 +1,350 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeObject is an object stored by the fake S3 server.
+type fakeObject struct {
+	data        []byte
+	contentType string
+	// metadata are the X-Amz-Meta-* headers of the object
+	metadata http.Header
+	etag     string
+}
+
+// fakeUpload is a multipart upload in progress.
+type fakeUpload struct {
+	key         string
+	contentType string
+	metadata    http.Header
+	parts       map[int][]byte
+}
+
+/*
+fakeS3 is an in-process S3 server that speaks just enough of the API for the storage:
+the bucket check, the multipart uploads, the copies of the objects and their reads.
+
+It ignores the signatures, but decodes the streaming (aws-chunked) payloads the client sends over HTTP.
+*/
+type fakeS3 struct {
+	t      *testing.T
+	server *httptest.Server
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	uploads map[string]*fakeUpload
+	nextID  int
+	// stall makes the uploads of the parts hang until the client gives up
+	stall bool
+}
+
+func newFakeS3(t *testing.T, bucket string) *fakeS3 {
+	t.Helper()
+
+	f := &fakeS3{
+		t:       t,
+		bucket:  bucket,
+		objects: make(map[string]fakeObject),
+		uploads: make(map[string]*fakeUpload),
+	}
+
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+
+	return f
+}
+
+// endpoint returns the host:port the client connects to.
+func (f *fakeS3) endpoint() string {
+	return strings.TrimPrefix(f.server.URL, "http://")
+}
+
+// stallParts makes the following uploads of the parts hang.
+func (f *fakeS3) stallParts() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.stall = true
+}
+
+// object returns the stored object by its key.
+func (f *fakeS3) object(key string) (fakeObject, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	object, ok := f.objects[key]
+
+	return object, ok
+}
+
+func (f *fakeS3) serve(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		f.error(w, http.StatusNotFound, "NoSuchBucket")
+
+		return
+	}
+
+	query := r.URL.Query()
+
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.initiate(w, r, key)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		f.uploadPart(w, r, query.Get("uploadId"), query.Get("partNumber"))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		f.complete(w, key, query.Get("uploadId"))
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		f.copy(w, r, key)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		f.mu.Lock()
+		delete(f.uploads, query.Get("uploadId"))
+		f.mu.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		f.get(w, r, key)
+	default:
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		f.error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) initiate(w http.ResponseWriter, r *http.Request, key string) {
+	f.mu.Lock()
+	f.nextID++
+	uploadID := strconv.Itoa(f.nextID)
+	f.uploads[uploadID] = &fakeUpload{
+		key:         key,
+		contentType: r.Header.Get("Content-Type"),
+		metadata:    userMetadata(r.Header),
+		parts:       make(map[int][]byte),
+	}
+	f.mu.Unlock()
+
+	f.xml(w, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Bucket   string
+		Key      string
+		UploadID string `xml:"UploadId"`
+	}{Bucket: f.bucket, Key: key, UploadID: uploadID})
+}
+
+func (f *fakeS3) uploadPart(w http.ResponseWriter, r *http.Request, uploadID, partNumber string) {
+	f.mu.Lock()
+	stall := f.stall
+	f.mu.Unlock()
+
+	if stall {
+		// The closed connection cancels the context only after the body is read
+		_, _ = io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+
+		return
+	}
+
+	data, err := readPayload(r)
+	if err != nil {
+		f.error(w, http.StatusBadRequest, "IncompleteBody")
+
+		return
+	}
+
+	number, _ := strconv.Atoi(partNumber)
+
+	f.mu.Lock()
+	upload, ok := f.uploads[uploadID]
+	if ok {
+		upload.parts[number] = data
+	}
+	f.mu.Unlock()
+
+	if !ok {
+		f.error(w, http.StatusNotFound, "NoSuchUpload")
+
+		return
+	}
+
+	w.Header().Set("ETag", fmt.Sprintf(`"part-%d"`, number))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (f *fakeS3) complete(w http.ResponseWriter, key, uploadID string) {
+	f.mu.Lock()
+	upload, ok := f.uploads[uploadID]
+	if ok {
+		var data []byte
+		for number := 1; number <= len(upload.parts); number++ {
+			data = append(data, upload.parts[number]...)
+		}
+
+		f.nextID++
+		f.objects[key] = fakeObject{
+			data:        data,
+			contentType: upload.contentType,
+			metadata:    upload.metadata,
+			etag:        fmt.Sprintf("object-%d", f.nextID),
+		}
+		delete(f.uploads, uploadID)
+	}
+	object := f.objects[key]
+	f.mu.Unlock()
+
+	if !ok {
+		f.error(w, http.StatusNotFound, "NoSuchUpload")
+
+		return
+	}
+
+	f.xml(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string
+		Key     string
+		ETag    string
+	}{Bucket: f.bucket, Key: key, ETag: strconv.Quote(object.etag)})
+}
+
+// copy copies the object with the metadata of the request, the source must have the ETag of x-amz-copy-source-if-match.
+func (f *fakeS3) copy(w http.ResponseWriter, r *http.Request, key string) {
+	source, err := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+	if err != nil {
+		f.error(w, http.StatusBadRequest, "InvalidArgument")
+
+		return
+	}
+
+	_, sourceKey, _ := strings.Cut(source, "/")
+
+	f.mu.Lock()
+	object, ok := f.objects[sourceKey]
+
+	match := strings.Trim(r.Header.Get("X-Amz-Copy-Source-If-Match"), `"`)
+
+	matched := ok && (match == "" || match == object.etag)
+	if matched {
+		if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+			object.contentType = r.Header.Get("Content-Type")
+			object.metadata = userMetadata(r.Header)
+		}
+
+		f.nextID++
+		object.etag = fmt.Sprintf("object-%d", f.nextID)
+		f.objects[key] = object
+	}
+	f.mu.Unlock()
+
+	switch {
+	case !ok:
+		f.error(w, http.StatusNotFound, noSuchKey)
+	case !matched:
+		f.error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+	default:
+		w.Header().Set("ETag", strconv.Quote(object.etag))
+		f.xml(w, struct {
+			XMLName      xml.Name `xml:"CopyObjectResult"`
+			ETag         string
+			LastModified string
+		}{ETag: strconv.Quote(object.etag), LastModified: time.Now().UTC().Format(time.RFC3339)})
+	}
+}
+
+// userMetadata returns the X-Amz-Meta-* headers.
+func userMetadata(header http.Header) http.Header {
+	metadata := make(http.Header)
+
+	for key, values := range header {
+		if strings.HasPrefix(key, "X-Amz-Meta-") {
+			metadata[key] = values
+		}
+	}
+
+	return metadata
+}
+
+func (f *fakeS3) get(w http.ResponseWriter, r *http.Request, key string) {
+	object, ok := f.object(key)
+	if !ok {
+		f.error(w, http.StatusNotFound, noSuchKey)
+
+		return
+	}
+
+	for key, values := range object.metadata {
+		w.Header()[key] = values
+	}
+
+	w.Header().Set("Content-Type", object.contentType)
+	w.Header().Set("ETag", strconv.Quote(object.etag))
+	w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(object.data))
+}
+
+func (f *fakeS3) xml(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+
+	if err := xml.NewEncoder(w).Encode(body); err != nil {
+		f.t.Errorf("encode response: %v", err)
+	}
+}
+
+func (f *fakeS3) error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+	}{Code: code})
+}
+
+// readPayload reads the body of the request, the streaming payload is a sequence of "<hex size>;chunk-signature=...\r\n<data>\r\n".
+func readPayload(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+
+	body := bufio.NewReader(r.Body)
+
+	var data []byte
+
+	for {
+		line, err := body.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		hexSize, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+
+		size, err := strconv.ParseInt(hexSize, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(body, chunk); err != nil {
+			return nil, err
+		}
+
+		if size == 0 {
+			return data, nil
+		}
+
+		data = append(data, chunk[:size]...)
+	}
+}
@@ -1,29 +1,55 @@
 package repository
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/file"
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/codec"
 	"github.com/andrsj/go-rabbit-image/pkg/logger"
 )
 
-var errFileNotFound = errors.New("file not found")
+const (
+	// hashExt is the extension of the hidden file with the content hash of the image.
+	hashExt = ".sha256"
+	// probePattern is the name of the hidden file written by the health checks.
+	probePattern = ".probe-*"
+)
+
+// probeData is the content of the file or the object written by the health checks.
+var probeData = []byte("probe")
+
+var (
+	errFileNotFound    = errors.New("file not found")
+	errUnsupportedType = errors.New("unsupported content type")
+)
 
 type localFileStorage struct {
+	*derivedCache
+
 	directoryPath string
 	logger        logger.Logger
 }
 
-var _ file.Repository = (*localFileStorage)(nil)
+var (
+	_ file.Repository = (*localFileStorage)(nil)
+	_ file.Cache      = (*localFileStorage)(nil)
+	_ file.Prober     = (*localFileStorage)(nil)
+)
 
-// New returns an instance of localFileStorage struct, which implements the FileRepository interface.
-func New(pathToDir string, log logger.Logger) (*localFileStorage, error) {
+// New returns an instance of localFileStorage struct, which implements the FileRepository interface
+// and the cache of the derived images limited to cacheMaxBytes.
+func New(pathToDir string, cacheMaxBytes int64, log logger.Logger) (*localFileStorage, error) {
 	lfs := &localFileStorage{
 		directoryPath: pathToDir,
 		logger:        log.Named("file repository"),
@@ -42,6 +68,15 @@ func New(pathToDir string, log logger.Logger) (*localFileStorage, error) {
 		return nil, err
 	}
 
+	lfs.derivedCache, err = newDerivedCache(filepath.Join(pathToDir, derivedDirectory), cacheMaxBytes, log)
+	if err != nil {
+		lfs.logger.Error("Error on creating cache of derived images", logger.M{
+			"error": err,
+		})
+
+		return nil, err
+	}
+
 	return lfs, nil
 }
 
@@ -59,28 +94,30 @@ func (localFileStorage) getOrCreateDir(path string) error {
 
 // getPathOfFile creates the path name for file.
 func (l *localFileStorage) getPathOfFile(data []byte, imageID string, level string) string {
-	var fileExt string
-
-	switch contentType := http.DetectContentType(data); contentType {
-	case "image/jpeg":
-		fileExt = "jpeg"
-	case "image/png":
-		fileExt = "png"
-	default:
+	// The extension of the format keeps the type of the image
+	format, err := codec.Detect(data)
+	if err != nil {
 		l.logger.Error("Not accepted content type", logger.M{
-			"content type": contentType,
+			"error": err,
 		})
 
 		return ""
 	}
 
-	filename := fmt.Sprintf("%s.%s", level, fileExt)
+	filename := fmt.Sprintf("%s.%s", level, format.Ext)
 	path := filepath.Join(l.directoryPath, imageID, filename)
 
 	return path
 }
 
 func (l *localFileStorage) CreateImage(data []byte, imageID string, level string) error {
+	_, err := l.WriteImage(bytes.NewReader(data), imageID, level)
+
+	return err
+}
+
+// WriteImage streams the image to the file, only the header is read to detect the type of the image.
+func (l *localFileStorage) WriteImage(r io.Reader, imageID string, level string) (int64, error) {
 	l.logger.Info("Creating image", logger.M{
 		"id":    imageID,
 		"level": level,
@@ -96,14 +133,31 @@ func (l *localFileStorage) CreateImage(data []byte, imageID string, level string
 			"error": err,
 		})
 
-		return fmt.Errorf("can't create a directory '%s': %w", idPath, err)
+		return 0, fmt.Errorf("can't create a directory '%s': %w", idPath, err)
+	}
+
+	// Peek the header of the image to detect its type
+	buffered := bufio.NewReaderSize(r, codec.SniffLen)
+
+	header, err := buffered.Peek(codec.SniffLen)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, fmt.Errorf("can't read an image '%s': %w", imageID, err)
 	}
 
 	// Get the file path for the image
-	path := l.getPathOfFile(data, imageID, level)
+	path := l.getPathOfFile(header, imageID, level)
+	if path == "" {
+		return 0, fmt.Errorf("%w of image '%s'", errUnsupportedType, imageID)
+	}
+
+	// Write the image data to the file, the content hash is computed on the way
+	hash := sha256.New()
+
+	size, err := writeFileAtomically(path, io.TeeReader(buffered, hash))
+	if err == nil {
+		err = writeHash(path, hex.EncodeToString(hash.Sum(nil)))
+	}
 
-	// Write the image data to the file
-	err = ioutil.WriteFile(path, data, os.ModePerm)
 	if err != nil {
 		l.logger.Error("Error on creating image", logger.M{
 			"id":    imageID,
@@ -111,17 +165,107 @@ func (l *localFileStorage) CreateImage(data []byte, imageID string, level string
 			"error": err,
 		})
 
-		return fmt.Errorf("can't create an image '%s': %w", imageID, err)
+		return 0, fmt.Errorf("can't create an image '%s': %w", imageID, err)
 	}
 
 	l.logger.Info("Image created", logger.M{
 		"id":    imageID,
 		"level": level,
+		"size":  size,
 	})
 
+	return size, nil
+}
+
+/*
+writeFileAtomically copies the reader to a temporary file in the same directory
+and renames it to the path, so the readers never see a half-written image
+even if the process is stopped in the middle of writing.
+*/
+func writeFileAtomically(path string, r io.Reader) (int64, error) {
+	dir, name := filepath.Split(path)
+
+	// Hidden temporary files are skipped by findFileByName
+	tmp, err := os.CreateTemp(dir, "."+name+".tmp-*")
+	if err != nil {
+		return 0, fmt.Errorf("create temp file: %w", err)
+	}
+
+	// Remove the temporary file if something goes wrong, after rename it does nothing
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		_ = tmp.Close()
+
+		return 0, fmt.Errorf("write temp file: %w", err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return 0, fmt.Errorf("close temp file: %w", err)
+	}
+
+	if err = os.Chmod(tmp.Name(), os.ModePerm); err != nil {
+		return 0, fmt.Errorf("chmod temp file: %w", err)
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return 0, fmt.Errorf("rename temp file: %w", err)
+	}
+
+	return size, nil
+}
+
+// hashPath returns the path of the hidden file with the content hash of the image file.
+func hashPath(path string) string {
+	dir, name := filepath.Split(path)
+
+	return filepath.Join(dir, "."+name+hashExt)
+}
+
+// writeHash stores the content hash of the image file next to it.
+func writeHash(path, hash string) error {
+	if _, err := writeFileAtomically(hashPath(path), strings.NewReader(hash)); err != nil {
+		return fmt.Errorf("write hash: %w", err)
+	}
+
 	return nil
 }
 
+/*
+readHash returns the content hash of the opened image file.
+
+The images written before the hashes were introduced have no hash file,
+their hash is computed from the content and stored for the next reads.
+*/
+func (l *localFileStorage) readHash(path string, f io.ReadSeeker) (string, error) {
+	data, err := os.ReadFile(hashPath(path))
+	if err == nil {
+		return string(data), nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("read hash: %w", err)
+	}
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, f); err != nil {
+		return "", fmt.Errorf("compute hash: %w", err)
+	}
+
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("seek: %w", err)
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if err = writeHash(path, sum); err != nil {
+		// The hash is computed again on the next read
+		l.logger.Warn("Can't store the hash of image", logger.M{"error": err, "path": path})
+	}
+
+	return sum, nil
+}
+
 func (l *localFileStorage) findFileByName(dirPath, fileName string) (string, error) {
 	var result string
 
@@ -129,8 +273,8 @@ func (l *localFileStorage) findFileByName(dirPath, fileName string) (string, err
 		if err != nil {
 			return err
 		}
-		// Check if the found item is not a directory
-		if !info.IsDir() {
+		// Check if the found item is not a directory or a temporary file being written
+		if !info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
 			// Get the name of the found item
 			name := info.Name()
 			foundedFileName := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
@@ -199,3 +343,135 @@ func (l *localFileStorage) GetImage(imageID string, level string) ([]byte, error
 	// Return the file contents as a byte slice
 	return data, nil
 }
+
+// OpenImage opens the file of the image for streaming reads.
+func (l *localFileStorage) OpenImage(imageID string, level string) (io.ReadSeekCloser, file.ImageInfo, error) {
+	l.logger.Debug("Trying to open image", logger.M{
+		"id":    imageID,
+		"level": level,
+	})
+
+	pathImage, err := l.findFileByName(filepath.Join(l.directoryPath, imageID), level)
+	if err != nil {
+		l.logger.Error("Error finding image file", logger.M{
+			"error": err,
+			"id":    imageID,
+			"level": level,
+		})
+
+		return nil, file.ImageInfo{}, err
+	}
+
+	f, err := os.Open(pathImage)
+	if err != nil {
+		return nil, file.ImageInfo{}, fmt.Errorf("can't open the file '%s': %w", imageID, err)
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+
+		return nil, file.ImageInfo{}, fmt.Errorf("can't stat the file '%s': %w", imageID, err)
+	}
+
+	hash, err := l.readHash(pathImage, f)
+	if err != nil {
+		_ = f.Close()
+
+		return nil, file.ImageInfo{}, fmt.Errorf("can't get the hash of the file '%s': %w", imageID, err)
+	}
+
+	return f, file.ImageInfo{
+		Size:        stat.Size(),
+		ContentType: contentTypeOfFile(pathImage),
+		ModTime:     stat.ModTime(),
+		Hash:        hash,
+	}, nil
+}
+
+// DeleteImage removes the directory of the image with all levels and the derived images.
+func (l *localFileStorage) DeleteImage(imageID string) error {
+	l.logger.Info("Deleting image", logger.M{"id": imageID})
+
+	if err := os.RemoveAll(filepath.Join(l.directoryPath, imageID)); err != nil {
+		l.logger.Error("Error on deleting image", logger.M{
+			"error": err,
+			"id":    imageID,
+		})
+
+		return fmt.Errorf("can't delete the image '%s': %w", imageID, err)
+	}
+
+	return l.deleteDerived(imageID)
+}
+
+// Exists reports whether the file of the level of the image exists.
+func (l *localFileStorage) Exists(imageID string, level string) (bool, error) {
+	dirPath := filepath.Join(l.directoryPath, imageID)
+
+	if _, err := os.Stat(dirPath); errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	_, err := l.findFileByName(dirPath, level)
+	if errors.Is(err, errFileNotFound) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("can't find the image '%s': %w", imageID, err)
+	}
+
+	return true, nil
+}
+
+/*
+Probe writes a hidden file to the directory of the images, syncs and removes it,
+so a full or read-only disk fails the probe before the uploads fail.
+
+Every probe writes its own file, the probes may run at the same time.
+*/
+func (l *localFileStorage) Probe(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	probe, err := os.CreateTemp(l.directoryPath, probePattern)
+	if err != nil {
+		return fmt.Errorf("create probe file: %w", err)
+	}
+
+	defer os.Remove(probe.Name())
+
+	if _, err = probe.Write(probeData); err != nil {
+		_ = probe.Close()
+
+		return fmt.Errorf("write probe file: %w", err)
+	}
+
+	// The write may succeed in the page cache and fail on the disk
+	if err = probe.Sync(); err != nil {
+		_ = probe.Close()
+
+		return fmt.Errorf("sync probe file: %w", err)
+	}
+
+	if err = probe.Close(); err != nil {
+		return fmt.Errorf("close probe file: %w", err)
+	}
+
+	if err = os.Remove(probe.Name()); err != nil {
+		return fmt.Errorf("remove probe file: %w", err)
+	}
+
+	return nil
+}
+
+// contentTypeOfFile returns the content type by the extension given by getPathOfFile.
+func contentTypeOfFile(path string) string {
+	if format, ok := codec.LookupExt(strings.TrimPrefix(filepath.Ext(path), ".")); ok {
+		return format.ContentType
+	}
+
+	return "application/octet-stream"
+}
@@ -0,0 +1,539 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/file"
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/codec"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	// The user metadata of the objects (X-Amz-Meta-*)
+	metaImageID = "Image-Id"
+	metaLevel   = "Level"
+	// metaHash is the hex SHA-256 of the content, the ETag of the multipart upload isn't the content hash
+	metaHash = "Sha256"
+
+	noSuchKey = "NoSuchKey"
+
+	// probeDirectory keeps the objects written by the health checks apart from the images.
+	probeDirectory = ".probe"
+)
+
+var (
+	errObjectNotFound = errors.New("object not found")
+	errNoProgress     = errors.New("the upload makes no progress")
+)
+
+// S3Config holds the settings of the S3-compatible storage (AWS S3, MinIO, etc.).
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+	// Prefix is prepended to the keys of all objects, e.g. "images/".
+	Prefix string
+	// PartSize is the size of the parts of the multipart upload, bigger objects are uploaded in parts.
+	PartSize uint64
+	// Timeout limits every request to the storage,
+	// the streamed uploads are limited only by the time without progress, so the big images are not cut off.
+	Timeout time.Duration
+	// CreateBucket creates the bucket if it doesn't exist.
+	CreateBucket bool
+}
+
+// s3FileStorage stores the images as "<prefix><id>/<level>" objects of the bucket.
+type s3FileStorage struct {
+	*derivedCache
+
+	client   *minio.Client
+	bucket   string
+	prefix   string
+	partSize uint64
+	timeout  time.Duration
+	logger   logger.Logger
+}
+
+var (
+	_ file.Repository = (*s3FileStorage)(nil)
+	_ file.Cache      = (*s3FileStorage)(nil)
+	_ file.Prober     = (*s3FileStorage)(nil)
+)
+
+// NewS3 returns an instance of s3FileStorage struct, which implements the FileRepository interface.
+//
+// The derived images are cached on the local disk in the directory of pathToDir, limited to cacheMaxBytes.
+func NewS3(cfg S3Config, pathToDir string, cacheMaxBytes int64, log logger.Logger) (*s3FileStorage, error) {
+	log = log.Named("S3 file repository")
+	log.Info("Connecting to S3 storage", logger.M{
+		"endpoint": cfg.Endpoint,
+		"bucket":   cfg.Bucket,
+	})
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		log.Error("Can't create S3 client", logger.M{"error": err})
+
+		return nil, fmt.Errorf("create S3 client: %w", err)
+	}
+
+	s := &s3FileStorage{
+		client:   client,
+		bucket:   cfg.Bucket,
+		prefix:   cfg.Prefix,
+		partSize: cfg.PartSize,
+		timeout:  cfg.Timeout,
+		logger:   log,
+	}
+
+	if err := s.checkBucket(cfg.Region, cfg.CreateBucket); err != nil {
+		log.Error("S3 bucket is not available", logger.M{"error": err, "bucket": cfg.Bucket})
+
+		return nil, err
+	}
+
+	s.derivedCache, err = newDerivedCache(filepath.Join(pathToDir, derivedDirectory), cacheMaxBytes, log)
+	if err != nil {
+		log.Error("Error on creating cache of derived images", logger.M{"error": err})
+
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// checkBucket checks that the bucket exists and creates it if it's allowed.
+func (s *s3FileStorage) checkBucket(region string, create bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	exists, err := s.client.BucketExists(ctx, s.bucket)
+	if err != nil {
+		return fmt.Errorf("check bucket '%s': %w", s.bucket, err)
+	}
+
+	if exists {
+		return nil
+	}
+
+	if !create {
+		return fmt.Errorf("bucket '%s' doesn't exist", s.bucket)
+	}
+
+	s.logger.Info("Creating bucket", logger.M{"bucket": s.bucket})
+
+	if err := s.client.MakeBucket(ctx, s.bucket, minio.MakeBucketOptions{Region: region}); err != nil {
+		return fmt.Errorf("create bucket '%s': %w", s.bucket, err)
+	}
+
+	return nil
+}
+
+// objectKey returns the key of the object of the image.
+func (s *s3FileStorage) objectKey(imageID, level string) string {
+	return s.prefix + path.Join(imageID, level)
+}
+
+func (s *s3FileStorage) CreateImage(data []byte, imageID string, level string) error {
+	_, err := s.WriteImage(bytes.NewReader(data), imageID, level)
+
+	return err
+}
+
+/*
+WriteImage streams the image to the object,
+the size is unknown, so the image is uploaded by the multipart upload in parts of PartSize.
+
+The upload lasts as long as the client sends the image, so it's not limited by the timeout as a whole:
+it's aborted when neither the image is read nor the part is sent for the timeout.
+
+The SHA-256 of the image is computed on the way and added to the metadata of the uploaded object.
+*/
+func (s *s3FileStorage) WriteImage(r io.Reader, imageID string, level string) (int64, error) {
+	s.logger.Info("Creating image", logger.M{
+		"id":    imageID,
+		"level": level,
+	})
+
+	// Peek the header of the image to detect its type
+	buffered := bufio.NewReaderSize(r, codec.SniffLen)
+
+	header, err := buffered.Peek(codec.SniffLen)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, fmt.Errorf("can't read an image '%s': %w", imageID, err)
+	}
+
+	format, err := codec.Detect(header)
+	if err != nil {
+		return 0, fmt.Errorf("%w of image '%s': %s", errUnsupportedType, imageID, err)
+	}
+
+	ctx, progress, cancel := watchProgress(s.timeout)
+	defer cancel()
+
+	hash := sha256.New()
+	metadata := map[string]string{
+		metaImageID: imageID,
+		metaLevel:   level,
+	}
+
+	source := progress.reader(io.TeeReader(buffered, hash))
+
+	info, err := s.client.PutObject(ctx, s.bucket, s.objectKey(imageID, level), source, -1,
+		minio.PutObjectOptions{
+			ContentType:  format.ContentType,
+			UserMetadata: metadata,
+			PartSize:     s.partSize,
+			// The client reports the sent bytes of the parts here
+			Progress: progress,
+		},
+	)
+	if err != nil && progress.stalled() {
+		err = fmt.Errorf("%w for %s", errNoProgress, s.timeout)
+	}
+
+	if err != nil {
+		s.logger.Error("Error on creating image", logger.M{
+			"id":    imageID,
+			"level": level,
+			"error": err,
+		})
+
+		return 0, fmt.Errorf("can't create an image '%s': %w", imageID, err)
+	}
+
+	metadata[metaHash] = hex.EncodeToString(hash.Sum(nil))
+	if err := s.setMetadata(info, format.ContentType, metadata); err != nil {
+		// The image is served without the ETag
+		s.logger.Warn("Can't store the hash of image", logger.M{
+			"id":    imageID,
+			"level": level,
+			"error": err,
+		})
+	}
+
+	s.logger.Info("Image created", logger.M{
+		"id":    imageID,
+		"level": level,
+		"size":  info.Size,
+	})
+
+	return info.Size, nil
+}
+
+/*
+setMetadata replaces the metadata of the uploaded object by copying it onto itself,
+the storage copies the object without sending it again.
+
+The hash is known only when the image is uploaded, while the metadata is set when the upload starts.
+The copy is made only if the object isn't replaced since the upload.
+*/
+func (s *s3FileStorage) setMetadata(uploaded minio.UploadInfo, contentType string, metadata map[string]string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	// The content type is replaced with the metadata
+	replaced := map[string]string{"Content-Type": contentType}
+	for key, value := range metadata {
+		replaced[key] = value
+	}
+
+	_, err := s.client.CopyObject(ctx,
+		minio.CopyDestOptions{
+			Bucket:          s.bucket,
+			Object:          uploaded.Key,
+			UserMetadata:    replaced,
+			ReplaceMetadata: true,
+		},
+		minio.CopySrcOptions{
+			Bucket:    s.bucket,
+			Object:    uploaded.Key,
+			MatchETag: uploaded.ETag,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("copy object '%s': %w", uploaded.Key, err)
+	}
+
+	return nil
+}
+
+func (s *s3FileStorage) GetImage(imageID string, level string) ([]byte, error) {
+	s.logger.Debug("Trying to get image", logger.M{
+		"id":    imageID,
+		"level": level,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	object, err := s.client.GetObject(ctx, s.bucket, s.objectKey(imageID, level), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s.readError(err, imageID, level)
+	}
+	defer object.Close()
+
+	// The request is sent on the first read, so the missing object is reported here
+	data, err := io.ReadAll(object)
+	if err != nil {
+		return nil, s.readError(err, imageID, level)
+	}
+
+	s.logger.Info("Successfully retrieved image", logger.M{
+		"id":    imageID,
+		"level": level,
+	})
+
+	return data, nil
+}
+
+// uploadProgress tracks the time of the last progress of the upload.
+type uploadProgress struct {
+	// last is the time of the last progress in Unix nanoseconds
+	last int64
+	// expired is set when the upload is aborted by the watchdog
+	expired int32
+}
+
+// watchProgress returns the context of the upload, which is canceled when the upload makes no progress for the timeout.
+func watchProgress(timeout time.Duration) (context.Context, *uploadProgress, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	progress := &uploadProgress{}
+	progress.touch()
+
+	go func() {
+		ticker := time.NewTicker(timeout / 10)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if time.Since(time.Unix(0, atomic.LoadInt64(&progress.last))) > timeout {
+					atomic.StoreInt32(&progress.expired, 1)
+					cancel()
+
+					return
+				}
+			}
+		}
+	}()
+
+	return ctx, progress, cancel
+}
+
+func (p *uploadProgress) touch() {
+	atomic.StoreInt64(&p.last, time.Now().UnixNano())
+}
+
+func (p *uploadProgress) stalled() bool {
+	return atomic.LoadInt32(&p.expired) == 1
+}
+
+// Read receives the sent bytes from the S3 client.
+func (p *uploadProgress) Read(b []byte) (int, error) {
+	p.touch()
+
+	return len(b), nil
+}
+
+// reader returns the reader of the image, which counts the reads as the progress.
+func (p *uploadProgress) reader(r io.Reader) io.Reader {
+	return progressReader{r, p}
+}
+
+type progressReader struct {
+	io.Reader
+	progress *uploadProgress
+}
+
+func (r progressReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	if n > 0 {
+		r.progress.touch()
+	}
+
+	return n, err
+}
+
+// readError logs the error of reading the object and converts the missing object to errObjectNotFound.
+func (s *s3FileStorage) readError(err error, imageID, level string) error {
+	if minio.ToErrorResponse(err).Code == noSuchKey {
+		s.logger.Error("Image not found", logger.M{
+			"id":    imageID,
+			"level": level,
+		})
+
+		return fmt.Errorf("%w: '%s'", errObjectNotFound, s.objectKey(imageID, level))
+	}
+
+	s.logger.Error("Error reading image object", logger.M{
+		"error": err,
+		"id":    imageID,
+		"level": level,
+	})
+
+	return fmt.Errorf("can't read the object '%s': %w", s.objectKey(imageID, level), err)
+}
+
+// DeleteImage removes all objects of the image and the derived images.
+func (s *s3FileStorage) DeleteImage(imageID string) error {
+	s.logger.Info("Deleting image", logger.M{"id": imageID})
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	objects := s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    s.objectKey(imageID, "") + "/",
+		Recursive: true,
+	})
+
+	// The objects are removed by the batches of the listed keys
+	keys := make(chan minio.ObjectInfo)
+	listErr := make(chan error, 1)
+
+	go func() {
+		defer close(keys)
+
+		for object := range objects {
+			if object.Err != nil {
+				listErr <- object.Err
+
+				return
+			}
+
+			keys <- object
+		}
+	}()
+
+	for result := range s.client.RemoveObjects(ctx, s.bucket, keys, minio.RemoveObjectsOptions{}) {
+		if result.Err != nil {
+			s.logger.Error("Error on deleting image object", logger.M{
+				"error": result.Err,
+				"key":   result.ObjectName,
+			})
+
+			return fmt.Errorf("can't delete the object '%s': %w", result.ObjectName, result.Err)
+		}
+	}
+
+	select {
+	case err := <-listErr:
+		s.logger.Error("Error on listing image objects", logger.M{"error": err, "id": imageID})
+
+		return fmt.Errorf("can't list the objects of '%s': %w", imageID, err)
+	default:
+	}
+
+	return s.deleteDerived(imageID)
+}
+
+// Exists reports whether the object of the level of the image exists.
+func (s *s3FileStorage) Exists(imageID string, level string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	_, err := s.client.StatObject(ctx, s.bucket, s.objectKey(imageID, level), minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == noSuchKey {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, s.readError(err, imageID, level)
+	}
+
+	return true, nil
+}
+
+// Probe writes a small object to the bucket and removes it, the requests are limited by the timeout of the storage.
+func (s *s3FileStorage) Probe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	// Every probe writes its own object, the probes may run at the same time
+	key := s.objectKey(probeDirectory, uuid.New().String())
+
+	_, err := s.client.PutObject(ctx, s.bucket, key, bytes.NewReader(probeData), int64(len(probeData)),
+		minio.PutObjectOptions{ContentType: "application/octet-stream"},
+	)
+	if err != nil {
+		return fmt.Errorf("write probe object: %w", err)
+	}
+
+	if err := s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("remove probe object: %w", err)
+	}
+
+	return nil
+}
+
+// s3Object is the object being read, closing it stops the request.
+type s3Object struct {
+	*minio.Object
+	cancel context.CancelFunc
+}
+
+func (o s3Object) Close() error {
+	defer o.cancel()
+
+	return o.Object.Close()
+}
+
+// OpenImage opens the object of the image for streaming reads, the object supports seeking (range requests).
+func (s *s3FileStorage) OpenImage(imageID string, level string) (io.ReadSeekCloser, file.ImageInfo, error) {
+	s.logger.Debug("Trying to open image", logger.M{
+		"id":    imageID,
+		"level": level,
+	})
+
+	// The object is read by the caller, so the request lives until it's closed
+	ctx, cancel := context.WithCancel(context.Background())
+
+	object, err := s.client.GetObject(ctx, s.bucket, s.objectKey(imageID, level), minio.GetObjectOptions{})
+	if err != nil {
+		cancel()
+
+		return nil, file.ImageInfo{}, s.readError(err, imageID, level)
+	}
+
+	// Stat sends the request, so the missing object is reported here
+	stat, err := object.Stat()
+	if err != nil {
+		_ = object.Close()
+		cancel()
+
+		return nil, file.ImageInfo{}, s.readError(err, imageID, level)
+	}
+
+	// The hash is stored by WriteImage, the ETag of the object isn't the content hash for the multipart uploads,
+	// so the objects without the hash have none
+	return s3Object{object, cancel}, file.ImageInfo{
+		Size:        stat.Size,
+		ContentType: stat.ContentType,
+		ModTime:     stat.LastModified,
+		Hash:        stat.UserMetadata[metaHash],
+	}, nil
+}
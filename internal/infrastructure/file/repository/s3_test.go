@@ -0,0 +1,202 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+)
+
+// testTimeout is the timeout of the requests to the fake storage.
+const testTimeout = 100 * time.Millisecond
+
+func newTestS3(t *testing.T, fake *fakeS3) *s3FileStorage {
+	t.Helper()
+
+	s, err := NewS3(S3Config{
+		Endpoint:  fake.endpoint(),
+		Region:    "us-east-1",
+		Bucket:    fake.bucket,
+		AccessKey: "access",
+		SecretKey: "secret",
+		PartSize:  5 << 20,
+		Timeout:   testTimeout,
+	}, t.TempDir(), 1<<20, logger.NewLogrusLogger("error"))
+	if err != nil {
+		t.Fatalf("NewS3: %v", err)
+	}
+
+	return s
+}
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 64, 64))); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+// slowReader returns the data in small chunks with the delay before each one, like a slow client.
+type slowReader struct {
+	data  []byte
+	chunk int
+	delay time.Duration
+}
+
+func (r *slowReader) Read(b []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+
+	time.Sleep(r.delay)
+
+	n := copy(b[:min(len(b), r.chunk)], r.data)
+	r.data = r.data[n:]
+
+	return n, nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+func TestS3WriteImageOutlivesTimeout(t *testing.T) {
+	fake := newFakeS3(t, "images")
+	s := newTestS3(t, fake)
+
+	data := testPNG(t)
+	chunk := len(data)/8 + 1
+	delay := testTimeout / 2
+
+	// The upload lasts about four timeouts, but the image is read all the time
+	start := time.Now()
+
+	size, err := s.WriteImage(&slowReader{data: data, chunk: chunk, delay: delay}, "a", "original")
+	if err != nil {
+		t.Fatalf("WriteImage: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 2*testTimeout {
+		t.Fatalf("the upload took %s, want it longer than the timeout %s", elapsed, testTimeout)
+	}
+
+	if size != int64(len(data)) {
+		t.Fatalf("got size %d, want %d", size, len(data))
+	}
+
+	object, ok := fake.object("a/original")
+	if !ok {
+		t.Fatal("the object is not stored")
+	}
+
+	if object.contentType != "image/png" {
+		t.Fatalf("got content type %q, want image/png", object.contentType)
+	}
+
+	stored, err := s.GetImage("a", "original")
+	if err != nil {
+		t.Fatalf("GetImage: %v", err)
+	}
+
+	if !bytes.Equal(stored, data) {
+		t.Fatal("the stored image differs from the written one")
+	}
+}
+
+func TestS3WriteImageAbortsStalledUpload(t *testing.T) {
+	fake := newFakeS3(t, "images")
+	s := newTestS3(t, fake)
+
+	fake.stallParts()
+
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := s.WriteImage(bytes.NewReader(testPNG(t)), "a", "original")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, errNoProgress) {
+			t.Fatalf("got %v, want %v", err, errNoProgress)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stalled upload is not aborted")
+	}
+
+	if _, ok := fake.object("a/original"); ok {
+		t.Fatal("the aborted upload is stored")
+	}
+}
+
+func TestS3GetMissingImage(t *testing.T) {
+	s := newTestS3(t, newFakeS3(t, "images"))
+
+	if _, err := s.GetImage("missing", "original"); !errors.Is(err, errObjectNotFound) {
+		t.Fatalf("got %v, want %v", err, errObjectNotFound)
+	}
+}
+
+func TestS3OpenImageHash(t *testing.T) {
+	fake := newFakeS3(t, "images")
+	s := newTestS3(t, fake)
+
+	data := testPNG(t)
+	sum := sha256.Sum256(data)
+
+	if _, err := s.WriteImage(bytes.NewReader(data), "a", "original"); err != nil {
+		t.Fatalf("WriteImage: %v", err)
+	}
+
+	// The object written before the hashes were stored
+	fake.mu.Lock()
+	fake.objects["b/original"] = fakeObject{data: data, contentType: "image/png", etag: "old"}
+	fake.mu.Unlock()
+
+	tests := []struct {
+		imageID string
+		hash    string
+	}{
+		{"a", hex.EncodeToString(sum[:])},
+		{"b", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.imageID, func(t *testing.T) {
+			object, info, err := s.OpenImage(test.imageID, "original")
+			if err != nil {
+				t.Fatalf("OpenImage: %v", err)
+			}
+			defer object.Close()
+
+			if info.Hash != test.hash {
+				t.Fatalf("got hash %q, want %q", info.Hash, test.hash)
+			}
+
+			// The metadata is replaced together with the content type
+			if info.ContentType != "image/png" || info.Size != int64(len(data)) {
+				t.Fatalf("got %s of %d bytes, want image/png of %d", info.ContentType, info.Size, len(data))
+			}
+		})
+	}
+
+	if stored, _ := fake.object("a/original"); stored.metadata.Get("X-Amz-Meta-Image-Id") != "a" {
+		t.Fatalf("the metadata of the image is lost: %v", stored.metadata)
+	}
+}
@@ -0,0 +1,216 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/andrsj/go-rabbit-image/internal/domain/dto"
+	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/job"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// jobModel is the table of the jobs.
+type jobModel struct {
+	ImageID   string `gorm:"primaryKey"`
+	Status    string `gorm:"index"`
+	Error     string
+	Variants  []variantModel `gorm:"foreignKey:ImageID;references:ImageID;constraint:OnDelete:CASCADE"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (jobModel) TableName() string { return "jobs" }
+
+// variantModel is the table of the variants results of the jobs.
+type variantModel struct {
+	ImageID   string `gorm:"primaryKey"`
+	Level     string `gorm:"primaryKey"`
+	Status    string
+	Size      int
+	Ratio     float64
+	Error     string
+	UpdatedAt time.Time
+}
+
+func (variantModel) TableName() string { return "job_variants" }
+
+// tombstoneModel is the table of the deleted images.
+type tombstoneModel struct {
+	ImageID   string `gorm:"primaryKey"`
+	CreatedAt time.Time
+}
+
+func (tombstoneModel) TableName() string { return "tombstones" }
+
+type sqliteJobStorage struct {
+	db     *gorm.DB
+	logger logger.Logger
+}
+
+var _ job.Repository = (*sqliteJobStorage)(nil)
+
+// New migrates the tables of the jobs in the database.
+func New(db *gorm.DB, log logger.Logger) (*sqliteJobStorage, error) {
+	log = log.Named("job repository")
+
+	if err := db.AutoMigrate(&jobModel{}, &variantModel{}, &tombstoneModel{}); err != nil {
+		log.Error("Can't migrate the job tables", logger.M{"error": err})
+
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+
+	return &sqliteJobStorage{
+		db:     db,
+		logger: log,
+	}, nil
+}
+
+func (s *sqliteJobStorage) Create(imageID string) error {
+	s.logger.Debug("Creating job", logger.M{"id": imageID})
+
+	err := s.db.Create(&jobModel{
+		ImageID: imageID,
+		Status:  string(dto.JobQueued),
+	}).Error
+	if err != nil {
+		s.logger.Error("Error on creating job", logger.M{"id": imageID, "error": err})
+
+		return fmt.Errorf("create job '%s': %w", imageID, err)
+	}
+
+	return nil
+}
+
+func (s *sqliteJobStorage) SetStatus(imageID string, status dto.JobStatus, errText string) error {
+	s.logger.Debug("Updating job status", logger.M{
+		"id":     imageID,
+		"status": status,
+	})
+
+	result := s.db.Model(&jobModel{ImageID: imageID}).Updates(map[string]interface{}{
+		"status": string(status),
+		"error":  errText,
+	})
+	if result.Error != nil {
+		s.logger.Error("Error on updating job status", logger.M{"id": imageID, "error": result.Error})
+
+		return fmt.Errorf("update job '%s': %w", imageID, result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: '%s'", job.ErrNotFound, imageID)
+	}
+
+	return nil
+}
+
+func (s *sqliteJobStorage) SetVariant(imageID string, variant dto.VariantDTO) error {
+	s.logger.Debug("Updating job variant", logger.M{
+		"id":     imageID,
+		"level":  variant.Level,
+		"status": variant.Status,
+	})
+
+	// Insert the variant or update it if it already exists
+	err := s.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&variantModel{
+		ImageID: imageID,
+		Level:   variant.Level,
+		Status:  string(variant.Status),
+		Size:    variant.Size,
+		Ratio:   variant.Ratio,
+		Error:   variant.Error,
+	}).Error
+	if err != nil {
+		s.logger.Error("Error on updating job variant", logger.M{"id": imageID, "error": err})
+
+		return fmt.Errorf("update variant '%s' of job '%s': %w", variant.Level, imageID, err)
+	}
+
+	return nil
+}
+
+func (s *sqliteJobStorage) Get(imageID string) (*dto.JobDTO, error) {
+	var model jobModel
+
+	err := s.db.Preload("Variants").First(&model, "image_id = ?", imageID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: '%s'", job.ErrNotFound, imageID)
+	}
+
+	if err != nil {
+		s.logger.Error("Error on reading job", logger.M{"id": imageID, "error": err})
+
+		return nil, fmt.Errorf("get job '%s': %w", imageID, err)
+	}
+
+	return model.toDTO(), nil
+}
+
+func (s *sqliteJobStorage) Delete(imageID string) error {
+	s.logger.Debug("Deleting job", logger.M{"id": imageID})
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// The variants are removed by the foreign key
+		result := tx.Delete(&jobModel{ImageID: imageID})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: '%s'", job.ErrNotFound, imageID)
+		}
+
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&tombstoneModel{ImageID: imageID}).Error
+	})
+	if errors.Is(err, job.ErrNotFound) {
+		return err
+	}
+
+	if err != nil {
+		s.logger.Error("Error on deleting job", logger.M{"id": imageID, "error": err})
+
+		return fmt.Errorf("delete job '%s': %w", imageID, err)
+	}
+
+	return nil
+}
+
+func (s *sqliteJobStorage) IsDeleted(imageID string) (bool, error) {
+	var count int64
+
+	err := s.db.Model(&tombstoneModel{}).Where("image_id = ?", imageID).Count(&count).Error
+	if err != nil {
+		s.logger.Error("Error on reading tombstone", logger.M{"id": imageID, "error": err})
+
+		return false, fmt.Errorf("read tombstone '%s': %w", imageID, err)
+	}
+
+	return count > 0, nil
+}
+
+// toDTO converts the database model to the DTO.
+func (m jobModel) toDTO() *dto.JobDTO {
+	variants := make([]dto.VariantDTO, 0, len(m.Variants))
+	for _, v := range m.Variants {
+		variants = append(variants, dto.VariantDTO{
+			Level:     v.Level,
+			Status:    dto.JobStatus(v.Status),
+			Size:      v.Size,
+			Ratio:     v.Ratio,
+			Error:     v.Error,
+			UpdatedAt: v.UpdatedAt,
+		})
+	}
+
+	return &dto.JobDTO{
+		ImageID:   m.ImageID,
+		Status:    dto.JobStatus(m.Status),
+		Error:     m.Error,
+		Variants:  variants,
+		CreatedAt: m.CreatedAt,
+		UpdatedAt: m.UpdatedAt,
+	}
+}
@@ -0,0 +1,182 @@
+package compressor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"math"
+
+	"github.com/andrsj/go-rabbit-image/internal/domain/variant"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+	"github.com/nfnt/resize"
+)
+
+// opaqueThreshold is the alpha below which the resized pixel becomes transparent.
+const opaqueThreshold = 0x8000
+
+var errEncodeAnimation = errors.New("can't encode animation")
+
+/*
+DecodeAnimationFrom decodes the image like DecodeImageFrom,
+but the images of the animated formats are decoded with all frames,
+unless their frames × width × height are over maxPixels (0 decodes no animations).
+
+The animation is nil for the still images, the animations with a single frame
+and the animations over the limit, the image is the first frame of the animation then.
+The frames of the animation over the limit are counted, but not decoded.
+*/
+func DecodeAnimationFrom(r io.Reader, maxPixels int) (Decoded, error) {
+	return decode(r, maxPixels)
+}
+
+/*
+CompressAnimation resizes all frames of the animation to the size of the profile.
+
+Every frame keeps its palette, delay and disposal method,
+so the frames are resized as they are stored: the partial frames are scaled
+together with their position on the canvas, and they are drawn over the previous ones as before.
+*/
+func (c *compressorService) CompressAnimation(anim *gif.GIF, profile variant.Profile) *gif.GIF {
+	newX, newY := profile.Dimensions(anim.Config.Width, anim.Config.Height)
+
+	c.logger.Info("Compressing animation", logger.M{
+		"variant": profile.Name,
+		"frames":  len(anim.Image),
+		"width":   newX,
+		"height":  newY,
+	})
+
+	scaleX := float64(newX) / float64(anim.Config.Width)
+	scaleY := float64(newY) / float64(anim.Config.Height)
+
+	frames := make([]*image.Paletted, 0, len(anim.Image))
+	for _, frame := range anim.Image {
+		frames = append(frames, resizeFrame(frame, scaleX, scaleY, newX, newY))
+	}
+
+	config := anim.Config
+	config.Width, config.Height = newX, newY
+
+	c.logger.Info("Animation compressed successfully", logger.M{"variant": profile.Name})
+
+	return &gif.GIF{
+		Image:           frames,
+		Delay:           anim.Delay,
+		Disposal:        anim.Disposal,
+		LoopCount:       anim.LoopCount,
+		Config:          config,
+		BackgroundIndex: anim.BackgroundIndex,
+	}
+}
+
+// EncodeAnimation encodes the animation to GIF.
+func (c *compressorService) EncodeAnimation(anim *gif.GIF) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+
+	if err := gif.EncodeAll(buffer, anim); err != nil {
+		return nil, fmt.Errorf("%w: %s", errEncodeAnimation, err)
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// resizeFrame scales the frame and its position on the canvas of the given size
+// and maps the resized pixels back to the palette of the frame.
+func resizeFrame(frame *image.Paletted, scaleX, scaleY float64, width, height int) *image.Paletted {
+	bounds := frame.Bounds()
+
+	rect := image.Rect(
+		int(math.Floor(float64(bounds.Min.X)*scaleX)),
+		int(math.Floor(float64(bounds.Min.Y)*scaleY)),
+		int(math.Ceil(float64(bounds.Max.X)*scaleX)),
+		int(math.Ceil(float64(bounds.Max.Y)*scaleY)),
+	).Intersect(image.Rect(0, 0, width, height))
+
+	if rect.Empty() {
+		rect = image.Rect(0, 0, 1, 1)
+	}
+
+	resized := resize.Resize(uint(rect.Dx()), uint(rect.Dy()), frame, resize.Lanczos3)
+
+	result := image.NewPaletted(rect, frame.Palette)
+	transparent := transparentIndex(frame.Palette)
+	// The frames have few colors, so the nearest colors are cached
+	indexes := make(map[color.RGBA64]uint8)
+
+	resizedBounds := resized.Bounds()
+
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			r, g, b, a := resized.At(resizedBounds.Min.X+x, resizedBounds.Min.Y+y).RGBA()
+
+			if a < opaqueThreshold && transparent >= 0 {
+				result.SetColorIndex(rect.Min.X+x, rect.Min.Y+y, uint8(transparent))
+
+				continue
+			}
+
+			// The edges of the transparent areas are blended by the resizing, they become opaque
+			pixel := color.RGBA64{R: unpremultiply(r, a), G: unpremultiply(g, a), B: unpremultiply(b, a), A: 0xffff}
+
+			index, ok := indexes[pixel]
+			if !ok {
+				index = uint8(nearestOpaque(frame.Palette, pixel, transparent))
+				indexes[pixel] = index
+			}
+
+			result.SetColorIndex(rect.Min.X+x, rect.Min.Y+y, index)
+		}
+	}
+
+	return result
+}
+
+// transparentIndex returns the index of the transparent color of the palette or -1.
+func transparentIndex(palette color.Palette) int {
+	for i, c := range palette {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// nearestOpaque returns the index of the palette color closest to the pixel, skipping the transparent color.
+func nearestOpaque(palette color.Palette, pixel color.RGBA64, transparent int) int {
+	best, bestDistance := 0, uint64(math.MaxUint64)
+
+	for i, c := range palette {
+		if i == transparent {
+			continue
+		}
+
+		r, g, b, _ := c.RGBA()
+		distance := sqDiff(r, uint32(pixel.R)) + sqDiff(g, uint32(pixel.G)) + sqDiff(b, uint32(pixel.B))
+
+		if distance < bestDistance {
+			best, bestDistance = i, distance
+		}
+	}
+
+	return best
+}
+
+// unpremultiply returns the straight value of the alpha-premultiplied color channel.
+func unpremultiply(value, alpha uint32) uint16 {
+	if alpha == 0 {
+		return 0
+	}
+
+	return uint16(value * 0xffff / alpha)
+}
+
+func sqDiff(x, y uint32) uint64 {
+	d := int64(x) - int64(y)
+
+	return uint64(d * d)
+}
@@ -0,0 +1,76 @@
+package compressor
+
+import (
+	"bytes"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"testing"
+)
+
+// encodeAnimation encodes the GIF of the frames of 10x10 pixels.
+func encodeAnimation(t *testing.T, frames int) []byte {
+	t.Helper()
+
+	anim := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 10, 10), palette.Plan9)
+		frame.Pix[0] = uint8(i)
+
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 1)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestDecodeAnimationLimit(t *testing.T) {
+	// 1000 frames of 100 pixels
+	data := encodeAnimation(t, 1000)
+
+	tests := []struct {
+		name      string
+		maxPixels int
+		animated  bool
+		// frames are counted only for the animated variants
+		frames int
+	}{
+		{"under the limit", 100_000, true, 1000},
+		{"over the limit", 99_999, false, 1000},
+		{"disabled", 0, false, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decoded, err := DecodeAnimationFrom(bytes.NewReader(data), test.maxPixels)
+			if err != nil {
+				t.Fatalf("DecodeAnimationFrom: %v", err)
+			}
+
+			if bounds := decoded.Image.Bounds(); bounds.Dx() != 10 || bounds.Dy() != 10 {
+				t.Fatalf("got the image of %dx%d, want 10x10", bounds.Dx(), bounds.Dy())
+			}
+
+			if decoded.Frames != test.frames || decoded.Pixels != test.frames*100 {
+				t.Fatalf("got %d frames of %d pixels, want %d frames", decoded.Frames, decoded.Pixels, test.frames)
+			}
+
+			if !test.animated {
+				if decoded.Animation != nil {
+					t.Fatalf("got the animation of %d frames, want the still image", len(decoded.Animation.Image))
+				}
+
+				return
+			}
+
+			if decoded.Animation == nil || len(decoded.Animation.Image) != 1000 {
+				t.Fatal("want the animation of 1000 frames")
+			}
+		})
+	}
+}
@@ -2,16 +2,21 @@ package compressor
 
 import (
 	"image"
+	"image/draw"
+	"image/gif"
 
+	"github.com/andrsj/go-rabbit-image/internal/domain/variant"
 	"github.com/andrsj/go-rabbit-image/pkg/logger"
 	"github.com/nfnt/resize"
 )
 
-const originalSizePercentage = 100
-
-// Compressor is an interface that defines the CompressImage method.
+// Compressor is an interface that defines the resizing and the encoding of the variants.
 type Compressor interface {
-	CompressImage(img image.Image, percentage int) image.Image
+	CompressImage(img image.Image, profile variant.Profile) image.Image
+	EncodeImage(img image.Image, contentType string, profile variant.Profile) ([]byte, error)
+	TransformImage(img image.Image, transform variant.Transform) image.Image
+	CompressAnimation(anim *gif.GIF, profile variant.Profile) *gif.GIF
+	EncodeAnimation(anim *gif.GIF) ([]byte, error)
 }
 
 // compressorService is a struct that holds a logger and implements the Compressor interface.
@@ -26,22 +31,33 @@ func New(logger logger.Logger) *compressorService {
 	}
 }
 
-// CompressImage is a method for compressing images by github.com/nfnt/resize package.
-func (c *compressorService) CompressImage(img image.Image, percentage int) image.Image {
-	c.logger.Info("Compressing image", logger.M{"%": percentage})
+// CompressImage is a method for compressing images to the size of the profile by github.com/nfnt/resize package.
+func (c *compressorService) CompressImage(img image.Image, profile variant.Profile) image.Image {
+	newX, newY := profile.Dimensions(img.Bounds().Dx(), img.Bounds().Dy())
 
-	coefficient := float64(percentage) / originalSizePercentage
-	newX, newY := float64(img.Bounds().Dx()), float64(img.Bounds().Dy())
+	c.logger.Info("Compressing image", logger.M{
+		"variant": profile.Name,
+		"width":   newX,
+		"height":  newY,
+	})
 
-	// It resizes the image using the given percentage and the Lanczos3 interpolation method
+	// It resizes the image to the size of the profile using the Lanczos3 interpolation method
 	newIMG := resize.Resize(
-		uint(newX*coefficient),
-		uint(newY*coefficient),
+		uint(newX),
+		uint(newY),
 		img,
 		resize.Lanczos3,
 	)
 
-	c.logger.Info("Image compressed successfully", logger.M{"%": percentage})
+	// The PNG variants of the paletted images keep the palette of the original,
+	// the interpolated colors in between compress much worse than its few colors
+	if paletted, ok := img.(*image.Paletted); ok && profile.Format != variant.FormatJPEG {
+		remapped := image.NewPaletted(newIMG.Bounds(), paletted.Palette)
+		draw.Draw(remapped, remapped.Bounds(), newIMG, newIMG.Bounds().Min, draw.Src)
+		newIMG = remapped
+	}
+
+	c.logger.Info("Image compressed successfully", logger.M{"variant": profile.Name})
 
 	return newIMG
 }
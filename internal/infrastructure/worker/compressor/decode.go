@@ -0,0 +1,148 @@
+package compressor
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"io"
+
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/codec"
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/exif"
+)
+
+var errDecodeImage = errors.New("can't decode image")
+
+/*
+The DecodeImage function decodes a byte slice to an image.Image
+and returns the decoded image, the content type
+of its variants, and an error (if any).
+
+It takes a byte slice as input and first detects
+the format of the image by the codec registry.
+
+If the format is registered, the function decodes
+the byte slice to an image using the decoder of the format,
+and the content type of the variants is chosen by the output policy of the format
+(e.g. a WebP image gets JPEG variants, or PNG ones if it is transparent).
+
+If the format is not registered,
+the function returns an error with a message that the format is unsupported.
+*/
+func DecodeImage(buf []byte) (image.Image, string, error) {
+	return DecodeImageFrom(bytes.NewReader(buf))
+}
+
+// DecodeImageFrom decodes the image from the reader like DecodeImage,
+// only the header is buffered to detect the format.
+//
+// The JPEG images are rotated by their EXIF orientation.
+func DecodeImageFrom(r io.Reader) (image.Image, string, error) {
+	decoded, err := decode(r, 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return decoded.Image, decoded.ContentType, nil
+}
+
+// Decoded is the decoded image with the details of its original.
+type Decoded struct {
+	// Image is the image rotated by its orientation, or the first frame of the animation
+	Image image.Image
+	// Animation has all frames of the animated image, it's nil for the still images
+	Animation *gif.GIF
+	// ContentType is the content type of the variants
+	ContentType string
+	// EXIF is the metadata of the original, it's nil if there is none
+	EXIF *exif.EXIF
+	// Frames and Pixels are the frames and their frames × width × height of the animated original,
+	// they are counted before decoding, the animation over the limit has no Animation
+	Frames int
+	Pixels int
+}
+
+/*
+decode detects the format of the image and decodes it,
+the animations up to maxPixels frames × width × height are decoded with all frames.
+
+The frames are counted by their descriptors first,
+so the animation over the limit is never decoded whole, only its first frame is.
+*/
+func decode(r io.Reader, maxPixels int) (Decoded, error) {
+	buffered := bufio.NewReaderSize(r, codec.SniffLen)
+
+	header, err := buffered.Peek(codec.SniffLen)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return Decoded{}, fmt.Errorf("%w: read header: %s", errDecodeImage, err)
+	}
+
+	format, err := codec.Detect(header)
+	if err != nil {
+		return Decoded{}, fmt.Errorf("%w: %s", errDecodeImage, err)
+	}
+
+	var decoded Decoded
+
+	src := io.Reader(buffered)
+
+	// Only the JPEG images carry the orientation in practice
+	if format.ContentType == codec.JPEGType {
+		if src, decoded.EXIF, err = exif.ReadJPEG(buffered); err != nil {
+			return Decoded{}, fmt.Errorf("%w: %s: %s", errDecodeImage, format.ContentType, err)
+		}
+	}
+
+	if maxPixels > 0 && format.DecodeAnimation != nil {
+		if src, err = countFrames(src, format, &decoded); err != nil {
+			return Decoded{}, fmt.Errorf("%w: %s: %s", errDecodeImage, format.ContentType, err)
+		}
+	}
+
+	if decoded.Frames > 1 && decoded.Pixels <= maxPixels {
+		anim, err := format.DecodeAnimation(src)
+		if err != nil {
+			return Decoded{}, fmt.Errorf("%w: %s: %s", errDecodeImage, format.ContentType, err)
+		}
+
+		decoded.Image = anim.Image[0]
+		if len(anim.Image) > 1 {
+			decoded.Animation = anim
+		}
+	} else {
+		img, err := format.Decode(src)
+		if err != nil {
+			return Decoded{}, fmt.Errorf("%w: %s: %s", errDecodeImage, format.ContentType, err)
+		}
+
+		decoded.Image = Orient(img, decoded.EXIF.Orientation())
+	}
+
+	decoded.ContentType = format.OutputType(decoded.Image)
+
+	return decoded, nil
+}
+
+// countFrames counts the frames and the pixels of the animation into the decoded image,
+// the animation is read into memory for it, the returned reader reads it again.
+func countFrames(r io.Reader, format codec.Codec, decoded *Decoded) (io.Reader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read animation: %w", err)
+	}
+
+	config, err := format.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if decoded.Frames, err = format.CountFrames(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	decoded.Pixels = decoded.Frames * config.Width * config.Height
+
+	return bytes.NewReader(data), nil
+}
@@ -0,0 +1,91 @@
+package compressor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/andrsj/go-rabbit-image/internal/domain/variant"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+)
+
+var errEncodeImage = errors.New("can't encode image")
+
+/*
+EncodeImage encodes the image to the content type with the encoder settings of the profile
+and returns the encoded bytes.
+
+The knobs are separate from the resizing:
+  - JPEG images are encoded with the quality of the profile (the default quality if it's zero);
+  - PNG images are encoded with the compression level of the profile
+    and, optionally, quantized to the palette of Colors colors,
+    they are always encoded with 8 bits per channel.
+*/
+func (c *compressorService) EncodeImage(img image.Image, contentType string, profile variant.Profile) ([]byte, error) {
+	var err error
+
+	buffer := new(bytes.Buffer)
+
+	switch contentType {
+	case "image/jpeg", "image/jpg":
+		var options *jpeg.Options
+		if profile.Quality > 0 {
+			options = &jpeg.Options{Quality: profile.Quality}
+		}
+
+		err = jpeg.Encode(buffer, img, options)
+	case "image/png":
+		if profile.Colors > 0 {
+			c.logger.Debug("Quantizing image", logger.M{"variant": profile.Name, "colors": profile.Colors})
+
+			img = quantize(img, profile.Colors)
+		}
+
+		encoder := png.Encoder{CompressionLevel: pngCompressionLevel(profile.Compression)}
+		err = encoder.Encode(buffer, eightBit(img))
+	default:
+		err = fmt.Errorf("%w: unknown content type: '%s'", errEncodeImage, contentType)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+/*
+eightBit returns the image that the PNG encoder writes with 8 bits per channel.
+
+The resized images are RGBA64 or YCbCr, the encoder writes them with 16 bits per channel,
+which doubles the size of the variant without any visible difference.
+*/
+func eightBit(img image.Image) image.Image {
+	switch img.(type) {
+	case *image.Paletted, *image.Gray, *image.RGBA, *image.NRGBA:
+		return img
+	}
+
+	converted := image.NewNRGBA(img.Bounds())
+	draw.Draw(converted, converted.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	return converted
+}
+
+// pngCompressionLevel maps the compression of the profile to the level of the PNG encoder.
+func pngCompressionLevel(compression string) png.CompressionLevel {
+	switch compression {
+	case variant.CompressionNone:
+		return png.NoCompression
+	case variant.CompressionSpeed:
+		return png.BestSpeed
+	case variant.CompressionBest:
+		return png.BestCompression
+	default:
+		return png.DefaultCompression
+	}
+}
@@ -0,0 +1,75 @@
+package compressor
+
+import (
+	"image"
+	"image/draw"
+)
+
+/*
+Orient rotates and flips the image by the EXIF orientation,
+so the image is displayed the right way without the tag:
+
+	1 as is                  5 transposed (flipped over the main diagonal)
+	2 flipped horizontally   6 rotated 90° clockwise
+	3 rotated 180°           7 transversed (flipped over the anti-diagonal)
+	4 flipped vertically     8 rotated 90° counterclockwise
+
+The image is returned as is for the normal and unknown orientations.
+*/
+func Orient(img image.Image, orientation int) image.Image {
+	const (
+		flipH = iota + 2
+		rot180
+		flipV
+		transpose
+		rot90
+		transverse
+		rot270
+	)
+
+	if orientation < flipH || orientation > rot270 {
+		return img
+	}
+
+	src := image.NewNRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+
+	// The orientations 5-8 swap the sides of the image
+	dstWidth, dstHeight := width, height
+	if orientation >= transpose {
+		dstWidth, dstHeight = height, width
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+
+			switch orientation {
+			case flipH:
+				dx, dy = width-1-x, y
+			case rot180:
+				dx, dy = width-1-x, height-1-y
+			case flipV:
+				dx, dy = x, height-1-y
+			case transpose:
+				dx, dy = y, x
+			case rot90:
+				dx, dy = height-1-y, x
+			case transverse:
+				dx, dy = height-1-y, width-1-x
+			case rot270:
+				dx, dy = y, width-1-x
+			}
+
+			srcOffset := src.PixOffset(x, y)
+			dstOffset := dst.PixOffset(dx, dy)
+			copy(dst.Pix[dstOffset:dstOffset+4], src.Pix[srcOffset:srcOffset+4])
+		}
+	}
+
+	return dst
+}
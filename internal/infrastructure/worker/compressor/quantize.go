@@ -0,0 +1,162 @@
+package compressor
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+)
+
+// maxSamples limits the number of pixels used to build the palette of big images.
+const maxSamples = 1 << 16
+
+// box is a group of pixels that becomes one color of the palette.
+type box []color.NRGBA
+
+/*
+quantize reduces the image to the palette of the given number of colors.
+
+The palette is built by the median cut algorithm on a sample of the pixels:
+the box with the widest channel is split at its median until there are enough boxes,
+the average colors of the boxes are the palette.
+The image is drawn with Floyd-Steinberg dithering to hide the banding.
+*/
+func quantize(img image.Image, colors int) *image.Paletted {
+	bounds := img.Bounds()
+	palette := medianCut(samplePixels(img), colors)
+
+	paletted := image.NewPaletted(bounds, palette)
+	draw.FloydSteinberg.Draw(paletted, bounds, img, bounds.Min)
+
+	return paletted
+}
+
+// samplePixels returns at most maxSamples pixels evenly taken from the image.
+func samplePixels(img image.Image) box {
+	bounds := img.Bounds()
+
+	step := 1
+	for (bounds.Dx()/step)*(bounds.Dy()/step) > maxSamples {
+		step++
+	}
+
+	pixels := make(box, 0, (bounds.Dx()/step+1)*(bounds.Dy()/step+1))
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			pixels = append(pixels, color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA))
+		}
+	}
+
+	return pixels
+}
+
+// medianCut splits the pixels into at most colors boxes and returns their average colors.
+func medianCut(pixels box, colors int) color.Palette {
+	boxes := []box{pixels}
+
+	for len(boxes) < colors {
+		// Split the box with the widest channel
+		index, channel, width := -1, 0, uint8(0)
+
+		for i, b := range boxes {
+			if len(b) < 2 {
+				continue
+			}
+
+			if c, w := b.widestChannel(); index == -1 || w > width {
+				index, channel, width = i, c, w
+			}
+		}
+
+		// All boxes have one color, the palette can't be better
+		if index == -1 || width == 0 {
+			break
+		}
+
+		b := boxes[index]
+		sort.Slice(b, func(i, j int) bool {
+			return channelOf(b[i], channel) < channelOf(b[j], channel)
+		})
+
+		median := len(b) / 2
+		boxes[index] = b[:median]
+		boxes = append(boxes, b[median:])
+	}
+
+	palette := make(color.Palette, 0, len(boxes))
+	for _, b := range boxes {
+		if len(b) > 0 {
+			palette = append(palette, b.average())
+		}
+	}
+
+	return palette
+}
+
+// widestChannel returns the channel (0-3 for R, G, B, A) with the widest range and the range.
+func (b box) widestChannel() (int, uint8) {
+	var minimum, maximum [4]uint8
+
+	for i := range minimum {
+		minimum[i] = 255
+	}
+
+	for _, pixel := range b {
+		for channel := 0; channel < 4; channel++ {
+			value := channelOf(pixel, channel)
+
+			if value < minimum[channel] {
+				minimum[channel] = value
+			}
+
+			if value > maximum[channel] {
+				maximum[channel] = value
+			}
+		}
+	}
+
+	widest, width := 0, uint8(0)
+
+	for channel := 0; channel < 4; channel++ {
+		if maximum[channel] >= minimum[channel] && maximum[channel]-minimum[channel] > width {
+			widest, width = channel, maximum[channel]-minimum[channel]
+		}
+	}
+
+	return widest, width
+}
+
+// average returns the average color of the box.
+func (b box) average() color.NRGBA {
+	var sum [4]int
+
+	for _, pixel := range b {
+		sum[0] += int(pixel.R)
+		sum[1] += int(pixel.G)
+		sum[2] += int(pixel.B)
+		sum[3] += int(pixel.A)
+	}
+
+	n := len(b)
+
+	return color.NRGBA{
+		R: uint8(sum[0] / n),
+		G: uint8(sum[1] / n),
+		B: uint8(sum[2] / n),
+		A: uint8(sum[3] / n),
+	}
+}
+
+func channelOf(pixel color.NRGBA, channel int) uint8 {
+	switch channel {
+	case 0:
+		return pixel.R
+	case 1:
+		return pixel.G
+	case 2:
+		return pixel.B
+	default:
+		return pixel.A
+	}
+}
@@ -0,0 +1,78 @@
+package compressor
+
+import (
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/andrsj/go-rabbit-image/internal/domain/variant"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+	"github.com/nfnt/resize"
+)
+
+/*
+TransformImage resizes the image to the box of the transformation:
+  - fill stretches the image to the box;
+  - contain fits the whole image into the box keeping the aspect ratio;
+  - cover fills the box keeping the aspect ratio and crops the center of the image.
+
+The result is never bigger than the box: cover crops the center with the aspect ratio of the box
+before resizing, so a narrow image isn't resized far beyond the box first.
+*/
+func (c *compressorService) TransformImage(img image.Image, transform variant.Transform) image.Image {
+	srcX, srcY := img.Bounds().Dx(), img.Bounds().Dy()
+	boxX, boxY := transform.Box(srcX, srcY)
+
+	c.logger.Info("Transforming image", logger.M{
+		"transform": transform.Key(),
+		"width":     boxX,
+		"height":    boxY,
+	})
+
+	scaleX, scaleY := float64(boxX)/float64(srcX), float64(boxY)/float64(srcY)
+
+	switch transform.Fit {
+	case variant.FitContain:
+		scale := math.Min(scaleX, scaleY)
+
+		return resize.Resize(scaled(srcX, scale), scaled(srcY, scale), img, resize.Lanczos3)
+	case variant.FitCover:
+		scale := math.Max(scaleX, scaleY)
+		cropped := cropCenter(img, int(scaled(boxX, 1/scale)), int(scaled(boxY, 1/scale)))
+
+		return resize.Resize(uint(boxX), uint(boxY), cropped, resize.Lanczos3)
+	default:
+		return resize.Resize(uint(boxX), uint(boxY), img, resize.Lanczos3)
+	}
+}
+
+// scaled returns the scaled side, at least one pixel.
+func scaled(side int, scale float64) uint {
+	value := math.Round(float64(side) * scale)
+	if value < 1 {
+		return 1
+	}
+
+	return uint(value)
+}
+
+// cropCenter returns the center part of the image with the given size.
+func cropCenter(img image.Image, width, height int) image.Image {
+	bounds := img.Bounds()
+	if width > bounds.Dx() {
+		width = bounds.Dx()
+	}
+
+	if height > bounds.Dy() {
+		height = bounds.Dy()
+	}
+
+	x := bounds.Min.X + (bounds.Dx()-width)/2
+	y := bounds.Min.Y + (bounds.Dy()-height)/2
+	rect := image.Rect(x, y, x+width, y+height)
+
+	cropped := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(cropped, cropped.Bounds(), img, rect.Min, draw.Src)
+
+	return cropped
+}
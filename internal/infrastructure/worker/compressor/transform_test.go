@@ -0,0 +1,35 @@
+package compressor
+
+import (
+	"image"
+	"testing"
+
+	"github.com/andrsj/go-rabbit-image/internal/domain/variant"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+)
+
+func TestTransformImageSize(t *testing.T) {
+	c := New(logger.NewLogrusLogger("error"))
+
+	// A narrow image: cover into a tall box would scale it to 204800x2048 before cropping
+	img := image.NewNRGBA(image.Rect(0, 0, 2000, 20))
+
+	tests := []struct {
+		transform     variant.Transform
+		width, height int
+	}{
+		{variant.Transform{Width: 10, Height: 2048, Fit: variant.FitCover}, 10, 2048},
+		{variant.Transform{Width: 100, Height: 100, Fit: variant.FitContain}, 100, 1},
+		{variant.Transform{Width: 100, Height: 100, Fit: variant.FitFill}, 100, 100},
+		{variant.Transform{Width: 200, Fit: variant.FitContain}, 200, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.transform.Key(), func(t *testing.T) {
+			bounds := c.TransformImage(img, test.transform).Bounds()
+			if bounds.Dx() != test.width || bounds.Dy() != test.height {
+				t.Fatalf("got %dx%d, want %dx%d", bounds.Dx(), bounds.Dy(), test.width, test.height)
+			}
+		})
+	}
+}
@@ -1,90 +1,700 @@
 package worker
 
 import (
-	"strconv"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/andrsj/go-rabbit-image/internal/domain/dto"
+	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/queue"
+	"github.com/andrsj/go-rabbit-image/internal/domain/variant"
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/codec"
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/exif"
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/worker/compressor"
+	"github.com/andrsj/go-rabbit-image/internal/metrics"
+	"github.com/andrsj/go-rabbit-image/internal/tracing"
 	"github.com/andrsj/go-rabbit-image/pkg/logger"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-const (
-	level75 = 75
-	level50 = 50
-	level25 = 25
+const ratioPrecision = 1000
+
+var (
+	errDrainTimeout        = errors.New("drain deadline exceeded")
+	errJobStopped          = errors.New("job is stopped on shutdown")
+	errOriginalUnavailable = errors.New("original image is unavailable")
 )
 
 // Start() method of the worker struct.
+//
+// It runs the pool of goroutines that process the images one by one,
+// so no more than the concurrency of the worker images are processed at the same time.
 func (c *worker) Start() {
 	// Start consuming messages from the Consumer
 	messageCh, errorCh := c.client.MustConsumeMessages()
-	c.logger.Info("Consumer has started", nil)
+	c.logger.Info("Consumer has started", logger.M{
+		"concurrency": c.concurrency,
+		"encoders":    cap(c.encoders),
+	})
 
-	go func() {
-		for {
-			select {
-			case message := <-messageCh:
-				// Decode the image from the message body
-				img, contentType, err := decodeImage(message.Body)
-				if err != nil {
-					c.logger.Error("Decoding image", logger.M{"error": err})
-					c.logger.Warn("Skipping image", logger.M{"image_id": message.ImageID})
-
-					continue
-				}
-
-				// Create image with 100% quality
-				go func() {
-					err := c.fileRepository.CreateImage(message.Body, message.ImageID, "100")
-					if err != nil {
-						c.logger.Error("Creating image", logger.M{"error": err})
-						c.logger.Warn("Skipping image", logger.M{"image_id": message.ImageID})
-
-						return
-					}
-				}()
-
-				// Compress the image and create images with different levels of quality
-				for _, level := range []int{level75, level50, level25} {
-					go func(level int) {
-						// Compress the image to a specific quality level
-						newImage := c.compressor.CompressImage(img, level)
-
-						// Encode the compressed image
-						bufferImage, err := encodeImage(newImage, contentType)
-						if err != nil {
-							c.logger.Error("Encoding image", logger.M{"error": err})
-							c.logger.Warn("Skipping image", logger.M{"image_id": message.ImageID})
-
-							return
-						}
-
-						// Create image with the given quality level
-						err = c.fileRepository.CreateImage(bufferImage, message.ImageID, strconv.Itoa(level))
-						if err != nil {
-							c.logger.Error("Creating image", logger.M{"error": err})
-							c.logger.Warn("Skipping image", logger.M{"image_id": message.ImageID})
-
-							return
-						}
-					}(level)
-				}
-
-			case err := <-errorCh:
-				if err != nil {
-					// Log the error and exit the application
-					c.logger.Fatal("Consuming messages", logger.M{"error": err})
-				}
-
-			// Graceful shutdown?
-			case <-c.context.Done():
-				// Log the shutdown and return from the method
-				c.logger.Info("Shutdown job . . .", nil)
+	c.consumers.Add(c.concurrency)
+	atomic.AddInt64(&c.consuming, int64(c.concurrency))
+
+	for i := 0; i < c.concurrency; i++ {
+		go func() {
+			defer c.consumers.Done()
+			defer atomic.AddInt64(&c.consuming, -1)
+
+			c.consume(messageCh, errorCh)
+		}()
+	}
+}
+
+// consume processes the messages until the job is stopped.
+func (c *worker) consume(messageCh <-chan dto.MessageDTO, errorCh <-chan error) {
+	for {
+		select {
+		case message := <-messageCh:
+			// The message may be taken at the same time as the job is stopped
+			if c.context.Err() != nil {
+				c.requeue(message)
 
 				return
 			}
+
+			c.handleMessage(message)
+
+		case err := <-errorCh:
+			// The consumer is closed on shutdown, after the job is stopped
+			if c.context.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				// Log the error and exit the application
+				c.logger.Fatal("Consuming messages", logger.M{"error": err})
+			}
+
+		// Graceful shutdown?
+		case <-c.context.Done():
+			// Log the shutdown and return from the method
+			c.logger.Info("Shutdown job . . .", nil)
+
+			return
 		}
-	}()
+	}
 }
 
-func (c *worker) Stop() {
+/*
+Stop stops the job gracefully.
+
+It stops consuming new deliveries and waits for the running jobs until the context is done.
+After the deadline, the unfinished messages are returned to the queue,
+so another instance picks them up, and the jobs stop at their next step.
+*/
+func (c *worker) Stop(ctx context.Context) error {
 	c.cancelFunc()
+
+	c.logger.Info("Stopping consuming new messages", nil)
+
+	if err := c.client.StopConsuming(); err != nil {
+		c.logger.Error("Stopping consuming", logger.M{"error": err})
+	}
+
+	drained := make(chan struct{})
+
+	go func() {
+		c.consumers.Wait()
+		close(drained)
+	}()
+
+	c.logger.Info("Waiting for the running jobs", logger.M{"in_flight": atomic.LoadInt64(&c.inFlight)})
+
+	select {
+	case <-drained:
+		c.logger.Info("All jobs are finished", nil)
+
+		return nil
+	case <-ctx.Done():
+	}
+
+	// The deadline is exceeded: stop the jobs and return their messages to the queue
+	c.drainCancel()
+
+	requeued := c.requeueUnfinished()
+	c.logger.Warn("Drain deadline exceeded, unfinished jobs are requeued", logger.M{"requeued": requeued})
+
+	return fmt.Errorf("%w: %d job(s) requeued", errDrainTimeout, requeued)
+}
+
+// track registers the message as being processed.
+func (c *worker) track(message dto.MessageDTO) {
+	c.jobsMu.Lock()
+	defer c.jobsMu.Unlock()
+
+	c.jobs[message.DeliveryTag] = &inFlightJob{message: message}
+}
+
+// settle marks the message as finished and reports whether it was not finished before,
+// only the first call may acknowledge, retry, reject or requeue the message.
+func (c *worker) settle(message dto.MessageDTO) bool {
+	c.jobsMu.Lock()
+	defer c.jobsMu.Unlock()
+
+	job, ok := c.jobs[message.DeliveryTag]
+	if !ok {
+		// The message isn't tracked (e.g. taken on shutdown), so nobody else settles it
+		return true
+	}
+
+	delete(c.jobs, message.DeliveryTag)
+
+	if job.settled {
+		return false
+	}
+
+	job.settled = true
+
+	return true
+}
+
+// requeueUnfinished returns all unsettled messages to the queue.
+func (c *worker) requeueUnfinished() int {
+	c.jobsMu.Lock()
+	unfinished := make([]dto.MessageDTO, 0, len(c.jobs))
+
+	for _, job := range c.jobs {
+		if !job.settled {
+			job.settled = true
+			unfinished = append(unfinished, job.message)
+		}
+	}
+	c.jobsMu.Unlock()
+
+	for _, message := range unfinished {
+		if err := c.client.Requeue(message); err != nil {
+			c.logger.Error("Requeueing message", logger.M{"error": err, "image_id": message.ImageID})
+
+			continue
+		}
+
+		c.setStatus(message.ImageID, dto.JobQueued, "requeued on shutdown")
+	}
+
+	return len(unfinished)
+}
+
+// handleMessage decodes the image and creates all variants of it,
+// the state of the job is updated at each step.
+func (c *worker) handleMessage(message dto.MessageDTO) {
+	atomic.AddInt64(&c.inFlight, 1)
+	metrics.JobsInFlight.Inc()
+	metrics.Consumed.Inc()
+
+	defer func() {
+		atomic.AddInt64(&c.inFlight, -1)
+		metrics.JobsInFlight.Dec()
+	}()
+
+	c.track(message)
+
+	// The processing continues the trace of the upload
+	ctx, span := tracing.Tracer().Start(messageContext(message), "process image",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("image.id", message.ImageID),
+			attribute.Int("messaging.attempt", message.Attempt),
+		),
+	)
+	defer span.End()
+
+	// The image is deleted while its message was in the queue
+	if c.isDeleted(message.ImageID) {
+		c.logger.Info("Image is deleted, skipping", logger.M{"image_id": message.ImageID})
+		c.ack(message)
+
+		return
+	}
+
+	c.setStatus(message.ImageID, dto.JobProcessing, "")
+
+	// Decode the original image from the storage or the message body
+	original, err := c.decodeOriginal(ctx, message)
+	if errors.Is(err, errOriginalUnavailable) {
+		// The storage can be back on the next attempt
+		c.logger.Error("Reading image", logger.M{"error": err, "image_id": message.ImageID})
+		metrics.Failed.Inc()
+		tracing.Fail(span, err)
+		c.retry(message, err)
+
+		return
+	}
+
+	if err != nil {
+		// The image can't be decoded on any attempt, so it isn't retried
+		c.logger.Error("Decoding image", logger.M{"error": err})
+		c.logger.Warn("Skipping image", logger.M{"image_id": message.ImageID})
+		metrics.Failed.Inc()
+		tracing.Fail(span, err)
+		c.setStatus(message.ImageID, dto.JobFailed, err.Error())
+		c.reject(message, err)
+
+		return
+	}
+
+	// The dimensions of the original are known only after decoding
+	c.setDimensions(message.ImageID, original.Image.Bounds())
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		failed  int
+		lastErr error
+	)
+
+	// onVariant records the result of the variant and counts the failures
+	onVariant := func(key string, size int, err error) {
+		result := dto.VariantDTO{
+			Level:  key,
+			Status: dto.JobDone,
+			Size:   size,
+			Ratio:  sizeRatio(size, original.size),
+		}
+
+		if err != nil {
+			c.logger.Warn("Skipping image", logger.M{"image_id": message.ImageID, "variant": key})
+
+			result.Status = dto.JobFailed
+			result.Error = err.Error()
+
+			mu.Lock()
+			failed++
+			lastErr = err
+			mu.Unlock()
+		}
+
+		c.setVariant(message.ImageID, result)
+	}
+
+	if len(message.Body) == 0 {
+		// The original is already stored by the API
+		onVariant(variant.OriginalKey, original.size, nil)
+	} else {
+		// Store the original image of the message with the metadata kept by the policy
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			size, err := c.storeBody(ctx, message)
+			if err != nil {
+				c.logger.Error("Creating image", logger.M{"error": err})
+			}
+
+			onVariant(variant.OriginalKey, size, err)
+		}()
+	}
+
+	// Compress the image and create the variants of all profiles
+	for _, profile := range c.variants {
+		wg.Add(1)
+
+		go func(profile variant.Profile) {
+			defer wg.Done()
+
+			size, err := c.createVariant(ctx, original, message.ImageID, profile)
+			onVariant(profile.Name, size, err)
+		}(profile)
+	}
+
+	wg.Wait()
+
+	// The job was stopped by the drain deadline, its message is already requeued
+	if c.drainContext.Err() != nil {
+		return
+	}
+
+	// The image is deleted while it was processed, so the written variants are removed
+	if c.isDeleted(message.ImageID) {
+		c.logger.Info("Image is deleted during processing, removing its variants", logger.M{"image_id": message.ImageID})
+
+		if err := c.fileRepository.DeleteImage(message.ImageID); err != nil {
+			c.retry(message, err)
+
+			return
+		}
+
+		c.ack(message)
+
+		return
+	}
+
+	if failed > 0 {
+		err := fmt.Errorf("%d variant(s) failed, last error: %w", failed, lastErr)
+
+		metrics.Failed.Inc()
+		tracing.Fail(span, err)
+		c.retry(message, err)
+
+		return
+	}
+
+	// All variants are persisted, so the message can be removed from the queue
+	if !c.settle(message) {
+		return
+	}
+
+	c.setStatus(message.ImageID, dto.JobDone, "")
+	metrics.Processed.Inc()
+
+	if err := c.client.Ack(message); err != nil {
+		c.logger.Error("Acknowledging message", logger.M{"error": err, "image_id": message.ImageID})
+	}
+}
+
+// ack removes the message of the skipped image from the queue.
+func (c *worker) ack(message dto.MessageDTO) {
+	if !c.settle(message) {
+		return
+	}
+
+	if err := c.client.Ack(message); err != nil {
+		c.logger.Error("Acknowledging message", logger.M{"error": err, "image_id": message.ImageID})
+	}
+}
+
+// isDeleted reports whether the image has a tombstone,
+// the image is processed if the tombstone can't be read.
+func (c *worker) isDeleted(imageID string) bool {
+	deleted, err := c.jobRepository.IsDeleted(imageID)
+	if err != nil {
+		c.logger.Error("Reading tombstone", logger.M{"error": err, "image_id": imageID})
+
+		return false
+	}
+
+	return deleted
+}
+
+// retry returns the message to the queue, the job is failed if no attempts are left.
+func (c *worker) retry(message dto.MessageDTO, reason error) {
+	if !c.settle(message) {
+		return
+	}
+
+	err := c.client.Retry(message, reason)
+	if errors.Is(err, queue.ErrDeadLettered) {
+		c.setStatus(message.ImageID, dto.JobFailed, err.Error())
+
+		return
+	}
+
+	if err != nil {
+		c.logger.Error("Retrying message", logger.M{"error": err, "image_id": message.ImageID})
+	}
+
+	c.setStatus(message.ImageID, dto.JobQueued, fmt.Sprintf("attempt %d failed: %s", message.Attempt, reason))
+}
+
+// reject sends the message to the dead-letter queue.
+func (c *worker) reject(message dto.MessageDTO, reason error) {
+	if !c.settle(message) {
+		return
+	}
+
+	if err := c.client.Reject(message, reason); err != nil {
+		c.logger.Error("Rejecting message", logger.M{"error": err, "image_id": message.ImageID})
+	}
+}
+
+// requeue returns the message to the queue without counting a retry.
+func (c *worker) requeue(message dto.MessageDTO) {
+	if !c.settle(message) {
+		return
+	}
+
+	if err := c.client.Requeue(message); err != nil {
+		c.logger.Error("Requeueing message", logger.M{"error": err, "image_id": message.ImageID})
+	}
+}
+
+// decodedImage is the decoded original image.
+type decodedImage struct {
+	compressor.Decoded
+	// metadata is the EXIF written to the variants, it's nil if they get none
+	metadata *exif.EXIF
+	// size is the size of the original in bytes
+	size int
+}
+
+/*
+decodeOriginal decodes the original image of the message.
+
+The messages published by the API carry only the ID of the image,
+its original is read from the storage as a stream (claim check).
+The messages with the body are decoded from the body.
+
+The dimensions of the original are checked by its header before decoding,
+the originals over the limits are failed without decoding (decompression bombs).
+
+The image is already rotated by its orientation, so the variants get the metadata
+kept by the policy without the orientation.
+
+The animations bigger than the limit get the still variants of the first frame.
+*/
+func (c *worker) decodeOriginal(ctx context.Context, message dto.MessageDTO) (decodedImage, error) {
+	_, span := tracing.Tracer().Start(ctx, "decode original")
+	defer span.End()
+
+	var (
+		src  io.ReadSeeker
+		size int
+	)
+
+	if len(message.Body) > 0 {
+		src, size = bytes.NewReader(message.Body), len(message.Body)
+	} else {
+		original, info, err := c.fileRepository.OpenImage(message.ImageID, variant.OriginalKey)
+		if err != nil {
+			return decodedImage{}, fmt.Errorf("%w: %s", errOriginalUnavailable, err)
+		}
+		defer original.Close()
+
+		src, size = original, int(info.Size)
+	}
+
+	if err := c.checkDimensions(src); err != nil {
+		return decodedImage{}, err
+	}
+
+	// The animation over the limit is decoded as the still image of its first frame
+	decoded, err := compressor.DecodeAnimationFrom(src, c.maxAnimationPixels)
+	if err != nil {
+		metrics.DecodeErrors.WithLabelValues(message.ContentType).Inc()
+		tracing.Fail(span, err)
+
+		return decodedImage{}, err
+	}
+
+	if decoded.Frames > 1 && decoded.Animation == nil {
+		c.logger.Warn("Animation is too big, creating still variants", logger.M{
+			"image_id": message.ImageID,
+			"frames":   decoded.Frames,
+			"pixels":   decoded.Pixels,
+			"limit":    c.maxAnimationPixels,
+		})
+	}
+
+	metadata := c.metadataPolicy.Apply(decoded.EXIF).WithoutOrientation()
+
+	return decodedImage{decoded, metadata, size}, nil
+}
+
+// checkDimensions checks the dimensions of the original by the header and rewinds it.
+func (c *worker) checkDimensions(src io.ReadSeeker) error {
+	err := c.limits.CheckStream(src)
+	if err != nil && !errors.Is(err, codec.ErrTooLarge) {
+		return fmt.Errorf("%w: %s", errOriginalUnavailable, err)
+	}
+
+	return err
+}
+
+// storeBody stores the original image of the message body,
+// the metadata of the image is filtered by the policy as on the upload.
+func (c *worker) storeBody(ctx context.Context, message dto.MessageDTO) (int, error) {
+	_, span := tracing.Tracer().Start(ctx, "store original")
+	defer span.End()
+
+	src := io.Reader(bytes.NewReader(message.Body))
+
+	if format, err := codec.Detect(message.Body); err == nil && format.FilterMetadata != nil {
+		if src, _, err = format.FilterMetadata(src, c.metadataPolicy, c.limits); err != nil {
+			return 0, fmt.Errorf("filter metadata: %w", err)
+		}
+	}
+
+	size, err := c.fileRepository.WriteImage(src, message.ImageID, variant.OriginalKey)
+
+	return int(size), err
+}
+
+// createVariant compresses the image to the size and the format of the profile and stores it,
+// it returns the size of the stored variant.
+func (c *worker) createVariant(
+	ctx context.Context, original decodedImage, imageID string, profile variant.Profile,
+) (int, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "variant "+profile.Name,
+		trace.WithAttributes(attribute.String("image.variant", profile.Name)),
+	)
+	defer span.End()
+
+	// Wait for a free encoder, they are shared by all images
+	select {
+	case c.encoders <- struct{}{}:
+	case <-c.drainContext.Done():
+		return 0, fmt.Errorf("%w: %s", errJobStopped, c.drainContext.Err())
+	}
+
+	atomic.AddInt64(&c.encodesInFlight, 1)
+	metrics.EncodesInFlight.Inc()
+
+	defer func() {
+		atomic.AddInt64(&c.encodesInFlight, -1)
+		metrics.EncodesInFlight.Dec()
+		<-c.encoders
+	}()
+
+	start := time.Now()
+	_, encodeSpan := tracing.Tracer().Start(ctx, "encode")
+
+	bufferImage, contentType, bounds, err := c.encodeVariant(original, profile)
+	encodeSpan.End()
+
+	if err != nil {
+		c.logger.Error("Encoding image", logger.M{"error": err})
+		metrics.EncodeErrors.WithLabelValues(contentType).Inc()
+		tracing.Fail(span, err)
+
+		return 0, err
+	}
+
+	metrics.CompressionDuration.WithLabelValues(profile.Name).Observe(time.Since(start).Seconds())
+	metrics.VariantBytes.WithLabelValues(profile.Name).Observe(float64(len(bufferImage)))
+	span.SetAttributes(attribute.String("image.content_type", contentType), attribute.Int("image.size", len(bufferImage)))
+
+	// Create image with the name of the profile
+	_, storeSpan := tracing.Tracer().Start(ctx, "store")
+
+	err = c.fileRepository.CreateImage(bufferImage, imageID, profile.Name)
+	storeSpan.End()
+
+	if err != nil {
+		c.logger.Error("Creating image", logger.M{"error": err})
+		tracing.Fail(span, err)
+
+		return 0, err
+	}
+
+	checksum := sha256.Sum256(bufferImage)
+
+	c.setCatalogVariant(imageID, dto.ImageVariantDTO{
+		Level:       profile.Name,
+		ContentType: contentType,
+		Width:       bounds.Dx(),
+		Height:      bounds.Dy(),
+		Size:        int64(len(bufferImage)),
+		Checksum:    hex.EncodeToString(checksum[:]),
+	})
+
+	return len(bufferImage), nil
+}
+
+/*
+encodeVariant resizes and encodes the image by the profile,
+it returns the encoded variant, its content type and its bounds (the content type is set on error too).
+
+The animations get the animated GIF variants, unless the profile sets the format,
+then the variant is the still image of the first frame.
+*/
+func (c *worker) encodeVariant(original decodedImage, profile variant.Profile) ([]byte, string, image.Rectangle, error) {
+	if original.Animation != nil && profile.Format == "" {
+		anim := c.compressor.CompressAnimation(original.Animation, profile)
+		data, err := c.compressor.EncodeAnimation(anim)
+
+		return data, codec.GIFType, image.Rect(0, 0, anim.Config.Width, anim.Config.Height), err
+	}
+
+	contentType := profile.ContentType(original.ContentType)
+
+	// Compress the image to the size of the profile
+	newImage := c.compressor.CompressImage(original.Image, profile)
+
+	// Encode the compressed image in the format and with the encoder settings of the profile
+	data, err := c.compressor.EncodeImage(newImage, contentType, profile)
+	if err != nil {
+		return nil, contentType, image.Rectangle{}, err
+	}
+
+	// The encoders write no metadata, so the kept one is inserted into the encoded variant
+	switch contentType {
+	case codec.JPEGType:
+		data = exif.InjectJPEG(data, original.metadata)
+	case codec.PNGType:
+		data = exif.InjectPNG(data, original.metadata)
+	}
+
+	return data, contentType, newImage.Bounds(), nil
+}
+
+// messageContext returns the trace context of the message.
+func messageContext(message dto.MessageDTO) context.Context {
+	if message.Context == nil {
+		return context.Background()
+	}
+
+	return message.Context
+}
+
+// sizeRatio returns the size of the variant relative to the size of the original, rounded to 0.001.
+func sizeRatio(size, original int) float64 {
+	if original == 0 {
+		return 0
+	}
+
+	return math.Round(float64(size)/float64(original)*ratioPrecision) / ratioPrecision
+}
+
+// setStatus updates the status of the job, the errors are only logged
+// because the state of the job must not stop the processing of the image.
+func (c *worker) setStatus(imageID string, status dto.JobStatus, errText string) {
+	if err := c.jobRepository.SetStatus(imageID, status, errText); err != nil {
+		c.logger.Error("Updating job status", logger.M{
+			"error":    err,
+			"image_id": imageID,
+			"status":   status,
+		})
+	}
+}
+
+// setVariant records the result of the variant, the errors are only logged.
+func (c *worker) setVariant(imageID string, variant dto.VariantDTO) {
+	if err := c.jobRepository.SetVariant(imageID, variant); err != nil {
+		c.logger.Error("Updating job variant", logger.M{
+			"error":    err,
+			"image_id": imageID,
+			"level":    variant.Level,
+		})
+	}
+}
+
+// setDimensions records the dimensions of the original in the catalog, the errors are only logged.
+func (c *worker) setDimensions(imageID string, bounds image.Rectangle) {
+	if err := c.catalogRepository.SetDimensions(imageID, bounds.Dx(), bounds.Dy()); err != nil {
+		c.logger.Error("Updating image dimensions", logger.M{
+			"error":    err,
+			"image_id": imageID,
+		})
+	}
+}
+
+// setCatalogVariant records the written variant in the catalog, the errors are only logged.
+func (c *worker) setCatalogVariant(imageID string, variant dto.ImageVariantDTO) {
+	if err := c.catalogRepository.SetVariant(imageID, variant); err != nil {
+		c.logger.Error("Updating image variant", logger.M{
+			"error":    err,
+			"image_id": imageID,
+			"level":    variant.Level,
+		})
+	}
 }
@@ -2,9 +2,17 @@ package worker
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
 
+	"github.com/andrsj/go-rabbit-image/internal/domain/dto"
+	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/catalog"
 	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/file"
+	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/job"
 	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/queue"
+	"github.com/andrsj/go-rabbit-image/internal/domain/variant"
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/codec"
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/exif"
 	"github.com/andrsj/go-rabbit-image/internal/infrastructure/worker/compressor"
 	"github.com/andrsj/go-rabbit-image/pkg/logger"
 )
@@ -13,7 +21,20 @@ type Params struct {
 	logger         logger.Logger
 	client         queue.Consumer
 	fileRepository file.Repository
-	compressor     compressor.Compressor
+	jobRepository  job.Repository
+	// catalogRepository gets the dimensions, sizes and checksums of the written images
+	catalogRepository catalog.Repository
+	compressor        compressor.Compressor
+	variants          variant.Set
+
+	concurrency int
+	encoders    int
+	// maxAnimationPixels limits the frames × width × height of the animated variants
+	maxAnimationPixels int
+	// metadataPolicy decides which EXIF the stored images keep
+	metadataPolicy exif.Policy
+	// limits are the maximum dimensions of the originals that are decoded
+	limits codec.Limits
 
 	cancelFunc context.CancelFunc
 	context    context.Context
@@ -49,12 +70,67 @@ func WithFileRepository(fileRepository file.Repository) Option {
 	}
 }
 
+func WithJobRepository(jobRepository job.Repository) Option {
+	return func(p *Params) {
+		p.jobRepository = jobRepository
+	}
+}
+
+// WithCatalogRepository sets the catalog that gets the metadata of the written variants.
+func WithCatalogRepository(catalogRepository catalog.Repository) Option {
+	return func(p *Params) {
+		p.catalogRepository = catalogRepository
+	}
+}
+
 func WithCompressor(compressor compressor.Compressor) Option {
 	return func(p *Params) {
 		p.compressor = compressor
 	}
 }
 
+// WithVariants sets the profiles of the variants created from every image.
+func WithVariants(variants variant.Set) Option {
+	return func(p *Params) {
+		p.variants = variants
+	}
+}
+
+// WithConcurrency sets the number of images processed at the same time.
+func WithConcurrency(concurrency int) Option {
+	return func(p *Params) {
+		p.concurrency = concurrency
+	}
+}
+
+// WithEncoders sets the number of resize/encode tasks running at the same time for all images.
+func WithEncoders(encoders int) Option {
+	return func(p *Params) {
+		p.encoders = encoders
+	}
+}
+
+// WithMaxAnimationPixels sets the limit of the animations, the bigger ones get still variants.
+func WithMaxAnimationPixels(pixels int) Option {
+	return func(p *Params) {
+		p.maxAnimationPixels = pixels
+	}
+}
+
+// WithMetadataPolicy sets the policy of the EXIF metadata of the stored images.
+func WithMetadataPolicy(policy exif.Policy) Option {
+	return func(p *Params) {
+		p.metadataPolicy = policy
+	}
+}
+
+// WithLimits sets the maximum dimensions of the originals, the bigger ones are failed without decoding.
+func WithLimits(limits codec.Limits) Option {
+	return func(p *Params) {
+		p.limits = limits
+	}
+}
+
 func WithLogger(logger logger.Logger) Option {
 	return func(p *Params) {
 		p.logger = logger
@@ -63,13 +139,51 @@ func WithLogger(logger logger.Logger) Option {
 
 type Worker interface {
 	Start()
-	Stop()
+	// Stop stops consuming and waits for the running jobs until the context is done,
+	// the unfinished jobs are requeued.
+	Stop(ctx context.Context) error
+	Stats() dto.WorkerStatsDTO
+}
+
+// inFlightJob is a message that is being processed.
+type inFlightJob struct {
+	message dto.MessageDTO
+	// settled is true when the message was acknowledged, retried, rejected or requeued
+	settled bool
 }
 
 type worker struct {
 	client         queue.Consumer
 	compressor     compressor.Compressor
 	fileRepository file.Repository
+	jobRepository  job.Repository
+	// catalogRepository gets the metadata of the written variants
+	catalogRepository catalog.Repository
+	variants          variant.Set
+
+	// concurrency goroutines take the messages, encoders limits the encode tasks of all of them
+	concurrency int
+	encoders    chan struct{}
+	// maxAnimationPixels limits the frames × width × height of the animated variants
+	maxAnimationPixels int
+	// metadataPolicy decides which EXIF the stored images keep
+	metadataPolicy exif.Policy
+	// limits are the maximum dimensions of the originals that are decoded
+	limits codec.Limits
+
+	inFlight        int64
+	encodesInFlight int64
+
+	// consumers counts the running pool goroutines, they finish their jobs before exiting,
+	// consuming is the same number for the stats
+	consumers sync.WaitGroup
+	consuming int64
+	// jobs are the messages in processing, they are requeued if the drain deadline is exceeded
+	jobsMu sync.Mutex
+	jobs   map[uint64]*inFlightJob
+	// drainContext is canceled when the drain deadline is exceeded, the jobs stop at the next step
+	drainContext context.Context
+	drainCancel  context.CancelFunc
 
 	cancelFunc context.CancelFunc
 	context    context.Context
@@ -78,7 +192,7 @@ type worker struct {
 }
 
 func New(options ...Option) *worker {
-	params := &Params{nil, nil, nil, nil, nil, nil}
+	params := &Params{nil, nil, nil, nil, nil, nil, nil, 1, 1, 0, exif.Policy{Mode: exif.PolicyStrip}, codec.Limits{}, nil, nil}
 
 	// There is a problem that I DON'T CHECK
 	// if some REQUIRED parameter is not provided
@@ -90,15 +204,47 @@ func New(options ...Option) *worker {
 		option(params)
 	}
 
+	drainContext, drainCancel := context.WithCancel(context.Background())
+
 	return &worker{
-		client:         params.client,
-		fileRepository: params.fileRepository,
-		compressor:     params.compressor,
-		cancelFunc:     params.cancelFunc,
-		context:        params.context,
+		client:             params.client,
+		fileRepository:     params.fileRepository,
+		jobRepository:      params.jobRepository,
+		catalogRepository:  params.catalogRepository,
+		compressor:         params.compressor,
+		variants:           params.variants,
+		concurrency:        params.concurrency,
+		encoders:           make(chan struct{}, params.encoders),
+		maxAnimationPixels: params.maxAnimationPixels,
+		metadataPolicy:     params.metadataPolicy,
+		limits:             params.limits,
+		jobs:               make(map[uint64]*inFlightJob),
+		drainContext:       drainContext,
+		drainCancel:        drainCancel,
+		cancelFunc:         params.cancelFunc,
+		context:            params.context,
 		// Question: is it good to pass the name here?
 		// Because it's a constructor of the worker instance
 		// ..., but is it idiomatic way of GO?
 		logger: params.logger.Named("background job"),
 	}
 }
+
+// Stats returns the number of images and encode tasks in flight, the running consumers and the depth of the queue.
+func (c *worker) Stats() dto.WorkerStatsDTO {
+	depth, err := c.client.QueueDepth()
+	if err != nil {
+		c.logger.Debug("Can't read the queue depth", logger.M{"error": err})
+
+		depth = -1
+	}
+
+	return dto.WorkerStatsDTO{
+		InFlight:        int(atomic.LoadInt64(&c.inFlight)),
+		Concurrency:     c.concurrency,
+		Consumers:       int(atomic.LoadInt64(&c.consuming)),
+		EncodesInFlight: int(atomic.LoadInt64(&c.encodesInFlight)),
+		Encoders:        cap(c.encoders),
+		QueueDepth:      depth,
+	}
+}
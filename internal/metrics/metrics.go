@@ -0,0 +1,150 @@
+/*
+Package metrics holds the Prometheus metrics of the API, the message broker clients and the worker.
+
+The metrics are registered in the own registry of the application (not the global one),
+it's served by Handler on GET /metrics.
+*/
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace is the prefix of all metrics.
+const namespace = "gri"
+
+// The results of the published messages.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Registry holds all metrics of the application with the Go runtime and the process metrics.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// HTTP API
+var (
+	// HTTPRequests counts the requests by the route (the pattern, e.g. /img/:id) and the status code.
+	HTTPRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of the HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPDuration is the latency of the requests by the route.
+	HTTPDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of the HTTP requests by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+)
+
+// Message broker
+var (
+	// Published counts the published messages by the broker driver and the result.
+	Published = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "broker",
+		Name:      "published_total",
+		Help:      "Number of the published messages by driver and result (success or failure).",
+	}, []string{"driver", "result"})
+)
+
+// Worker
+var (
+	// Consumed counts the messages taken by the worker, the redeliveries included.
+	Consumed = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "worker",
+		Name:      "messages_consumed_total",
+		Help:      "Number of the messages taken from the queue.",
+	})
+
+	// Processed counts the images with all variants stored.
+	Processed = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "worker",
+		Name:      "images_processed_total",
+		Help:      "Number of the images processed with all variants.",
+	})
+
+	// Failed counts the failed attempts to process the images, they may be retried.
+	Failed = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "worker",
+		Name:      "images_failed_total",
+		Help:      "Number of the failed attempts to process an image.",
+	})
+
+	// CompressionDuration is the time of the resizing and encoding of a variant by the level (profile).
+	CompressionDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "worker",
+		Name:      "compression_duration_seconds",
+		Help:      "Time of the resizing and encoding of a variant by level.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"level"})
+
+	// VariantBytes is the size of the encoded variants by the level, from 1 KB to 256 MB.
+	VariantBytes = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "worker",
+		Name:      "variant_size_bytes",
+		Help:      "Size of the encoded variants by level.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 4, 10),
+	}, []string{"level"})
+
+	// DecodeErrors counts the originals that can't be decoded by the content type.
+	DecodeErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "worker",
+		Name:      "decode_errors_total",
+		Help:      "Number of the originals that can't be decoded by content type.",
+	}, []string{"content_type"})
+
+	// EncodeErrors counts the variants that can't be encoded by the content type of the variant.
+	EncodeErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "worker",
+		Name:      "encode_errors_total",
+		Help:      "Number of the variants that can't be encoded by content type.",
+	}, []string{"content_type"})
+
+	// JobsInFlight is the number of the images being processed.
+	JobsInFlight = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "worker",
+		Name:      "jobs_in_flight",
+		Help:      "Number of the images being processed.",
+	})
+
+	// EncodesInFlight is the number of the variants being resized and encoded.
+	EncodesInFlight = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "worker",
+		Name:      "encodes_in_flight",
+		Help:      "Number of the variants being resized and encoded.",
+	})
+)
+
+// Handler returns the HTTP handler of the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
@@ -0,0 +1,224 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andrsj/go-rabbit-image/internal/domain/dto"
+	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/file"
+	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/queue"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+)
+
+// The names of the checked components in the readiness report.
+const (
+	ComponentBroker  = "broker"
+	ComponentStorage = "storage"
+	ComponentWorker  = "worker"
+)
+
+var (
+	errCheckTimeout       = errors.New("check timed out")
+	errBrokerNotConnected = errors.New("message broker is not connected")
+	errConsumersStopped   = errors.New("consumers are not running")
+)
+
+// Checker interface represents a service that reports the liveness and the readiness of the application.
+type Checker interface {
+	// Live reports that the process is alive, the dependencies aren't checked.
+	Live() dto.HealthDTO
+	// Ready checks the broker, the storage and the worker, the request can be served if all of them are ok.
+	Ready(ctx context.Context) dto.HealthDTO
+	// Drain makes the readiness fail on shutdown, so the load balancers stop sending the requests first.
+	Drain()
+}
+
+// WorkerStats reports the running consumers of the background worker.
+type WorkerStats interface {
+	Stats() dto.WorkerStatsDTO
+}
+
+// check returns the details of the component and the reason why it isn't ready.
+type check func(ctx context.Context) (map[string]interface{}, error)
+
+// healthService represents a service that checks the components of the application.
+type healthService struct {
+	broker  queue.StateReporter
+	storage file.Prober
+	worker  WorkerStats
+	// timeout limits every check, the slower component is failing
+	timeout time.Duration
+	// draining is set on shutdown, the readiness fails from then on
+	draining int32
+	logger   logger.Logger
+}
+
+var _ Checker = (*healthService)(nil)
+
+// New creates a new instance of healthService, every check is limited by the timeout.
+func New(
+	broker queue.StateReporter,
+	storage file.Prober,
+	worker WorkerStats,
+	timeout time.Duration,
+	logger logger.Logger,
+) *healthService {
+	return &healthService{
+		broker:  broker,
+		storage: storage,
+		worker:  worker,
+		timeout: timeout,
+		logger:  logger.Named("Health service"),
+	}
+}
+
+// Live reports that the process is alive, the failing dependencies must not restart it.
+func (h *healthService) Live() dto.HealthDTO {
+	return dto.HealthDTO{Status: dto.HealthOK}
+}
+
+/*
+Ready runs the checks of the components at the same time and reports each of them.
+
+The service is ready if the broker is connected, the storage accepts the writes
+within the timeout and all consumers of the worker are running.
+On shutdown, the status is "draining" whatever the components report.
+*/
+func (h *healthService) Ready(ctx context.Context) dto.HealthDTO {
+	checks := map[string]check{
+		ComponentBroker:  h.checkBroker,
+		ComponentStorage: h.checkStorage,
+		ComponentWorker:  h.checkWorker,
+	}
+
+	var (
+		mu         sync.Mutex
+		wg         sync.WaitGroup
+		components = make(map[string]dto.ComponentHealthDTO, len(checks))
+	)
+
+	for name, c := range checks {
+		wg.Add(1)
+
+		go func(name string, c check) {
+			defer wg.Done()
+
+			component := h.run(ctx, name, c)
+
+			mu.Lock()
+			components[name] = component
+			mu.Unlock()
+		}(name, c)
+	}
+
+	wg.Wait()
+
+	status := dto.HealthOK
+
+	for _, component := range components {
+		if component.Status != dto.HealthOK {
+			status = dto.HealthFailing
+		}
+	}
+
+	if atomic.LoadInt32(&h.draining) == 1 {
+		status = dto.HealthDraining
+	}
+
+	return dto.HealthDTO{Status: status, Components: components}
+}
+
+// Drain makes the readiness fail, it can't be undone.
+func (h *healthService) Drain() {
+	if atomic.CompareAndSwapInt32(&h.draining, 0, 1) {
+		h.logger.Info("Readiness is failing, the service is draining", nil)
+	}
+}
+
+// run runs the check with the timeout and measures its latency, the check that hangs is abandoned.
+func (h *healthService) run(ctx context.Context, name string, c check) dto.ComponentHealthDTO {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	type result struct {
+		details map[string]interface{}
+		err     error
+	}
+
+	// The buffered channel lets the abandoned check finish without blocking
+	done := make(chan result, 1)
+	start := time.Now()
+
+	go func() {
+		details, err := c(ctx)
+		done <- result{details, err}
+	}()
+
+	var r result
+
+	select {
+	case r = <-done:
+	case <-ctx.Done():
+		r.err = fmt.Errorf("%w after %s", errCheckTimeout, h.timeout)
+	}
+
+	component := dto.ComponentHealthDTO{
+		Status:    dto.HealthOK,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+		Details:   r.details,
+	}
+
+	if r.err != nil {
+		h.logger.Warn("Component is not ready", logger.M{
+			"component": name,
+			"error":     r.err,
+		})
+
+		component.Status = dto.HealthFailing
+		component.Error = r.err.Error()
+	}
+
+	return component
+}
+
+// checkBroker checks that the connection and the channel to the broker are open.
+func (h *healthService) checkBroker(_ context.Context) (map[string]interface{}, error) {
+	state := h.broker.State()
+	details := map[string]interface{}{"state": state}
+
+	if state != queue.StateConnected {
+		return details, fmt.Errorf("%w: %s", errBrokerNotConnected, state)
+	}
+
+	return details, nil
+}
+
+// checkStorage writes and removes a probe, so the full or read-only storage is failing.
+func (h *healthService) checkStorage(ctx context.Context) (map[string]interface{}, error) {
+	if err := h.storage.Probe(ctx); err != nil {
+		return nil, fmt.Errorf("probe: %w", err)
+	}
+
+	return nil, nil
+}
+
+// checkWorker checks that all consumers of the worker are running.
+func (h *healthService) checkWorker(_ context.Context) (map[string]interface{}, error) {
+	stats := h.worker.Stats()
+	details := map[string]interface{}{
+		"consumers":   stats.Consumers,
+		"concurrency": stats.Concurrency,
+		"in_flight":   stats.InFlight,
+		"queue_depth": stats.QueueDepth,
+	}
+
+	if stats.Consumers < stats.Concurrency {
+		return details, fmt.Errorf("%w: %d of %d", errConsumersStopped, stats.Consumers, stats.Concurrency)
+	}
+
+	return details, nil
+}
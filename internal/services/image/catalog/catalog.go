@@ -0,0 +1,107 @@
+package catalog
+
+import (
+	"fmt"
+
+	"github.com/andrsj/go-rabbit-image/internal/domain/dto"
+	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/catalog"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+)
+
+// Catalog interface represents a service to register the uploaded images and read their metadata.
+type Catalog interface {
+	Register(image dto.ImageDTO) error
+	GetImage(id string) (*dto.ImageDTO, error)
+	ListImages(query dto.ImageQueryDTO) (*dto.ImagePageDTO, error)
+	Usage(uploader string) (*dto.UsageDTO, error)
+	Delete(id string) error
+}
+
+// catalogService represents a service that reads and writes the metadata of the images.
+type catalogService struct {
+	images catalog.Repository
+	logger logger.Logger
+}
+
+var _ Catalog = (*catalogService)(nil)
+
+// New creates a new instance of catalogService.
+func New(images catalog.Repository, logger logger.Logger) *catalogService {
+	return &catalogService{
+		images: images,
+		logger: logger.Named("Catalog service"),
+	}
+}
+
+// Register adds the uploaded image to the catalog, the worker adds its dimensions and variants.
+func (c *catalogService) Register(image dto.ImageDTO) error {
+	if err := c.images.Create(image); err != nil {
+		c.logger.Error("Error registering the image", logger.M{
+			"error": err,
+			"id":    image.ImageID,
+		})
+
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+// GetImage returns the metadata of the image with all variants.
+func (c *catalogService) GetImage(id string) (*dto.ImageDTO, error) {
+	image, err := c.images.Get(id)
+	if err != nil {
+		c.logger.Error("Error reading the image", logger.M{
+			"error": err,
+			"id":    id,
+		})
+
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return image, nil
+}
+
+// ListImages returns the page of the images.
+func (c *catalogService) ListImages(query dto.ImageQueryDTO) (*dto.ImagePageDTO, error) {
+	page, err := c.images.List(query)
+	if err != nil {
+		c.logger.Error("Error listing the images", logger.M{
+			"error": err,
+			"query": query,
+		})
+
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return page, nil
+}
+
+// Usage returns the number and the size of the images of the uploader.
+func (c *catalogService) Usage(uploader string) (*dto.UsageDTO, error) {
+	usage, err := c.images.Usage(uploader)
+	if err != nil {
+		c.logger.Error("Error reading the usage", logger.M{
+			"error":    err,
+			"uploader": uploader,
+		})
+
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return usage, nil
+}
+
+// Delete removes the image from the catalog.
+func (c *catalogService) Delete(id string) error {
+	if err := c.images.Delete(id); err != nil {
+		c.logger.Error("Error deleting the image", logger.M{
+			"error": err,
+			"id":    id,
+		})
+
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
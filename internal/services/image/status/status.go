@@ -0,0 +1,90 @@
+package status
+
+import (
+	"fmt"
+
+	"github.com/andrsj/go-rabbit-image/internal/domain/dto"
+	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/job"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+)
+
+// JobStatus interface represents a service to register the image jobs and read their state.
+type JobStatus interface {
+	MarkQueued(id string) error
+	MarkFailed(id string, reason error) error
+	GetStatus(id string) (*dto.JobDTO, error)
+	Delete(id string) error
+}
+
+// jobStatusService represents a service that reads and writes the state of the image jobs.
+type jobStatusService struct {
+	jobs   job.Repository
+	logger logger.Logger
+}
+
+var _ JobStatus = (*jobStatusService)(nil)
+
+// New creates a new instance of jobStatusService.
+func New(jobs job.Repository, logger logger.Logger) *jobStatusService {
+	return &jobStatusService{
+		jobs:   jobs,
+		logger: logger.Named("Job status service"),
+	}
+}
+
+// MarkQueued registers the job of the image before publishing it.
+func (j *jobStatusService) MarkQueued(id string) error {
+	if err := j.jobs.Create(id); err != nil {
+		j.logger.Error("Error registering the job", logger.M{
+			"error": err,
+			"id":    id,
+		})
+
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+// MarkFailed marks the job as failed with the reason.
+func (j *jobStatusService) MarkFailed(id string, reason error) error {
+	if err := j.jobs.SetStatus(id, dto.JobFailed, reason.Error()); err != nil {
+		j.logger.Error("Error marking the job as failed", logger.M{
+			"error": err,
+			"id":    id,
+		})
+
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+// GetStatus returns the state of the job with all variants.
+func (j *jobStatusService) GetStatus(id string) (*dto.JobDTO, error) {
+	status, err := j.jobs.Get(id)
+	if err != nil {
+		j.logger.Error("Error reading the job", logger.M{
+			"error": err,
+			"id":    id,
+		})
+
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return status, nil
+}
+
+// Delete removes the job of the image, its queued message is skipped by the worker.
+func (j *jobStatusService) Delete(id string) error {
+	if err := j.jobs.Delete(id); err != nil {
+		j.logger.Error("Error deleting the job", logger.M{
+			"error": err,
+			"id":    id,
+		})
+
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/file"
 	"github.com/andrsj/go-rabbit-image/pkg/logger"
@@ -11,6 +12,10 @@ import (
 type FileStorage interface {
 	WriteImageToStorage(image []byte, id string, level string) error
 	ReadImageFromStorage(id string, level string) ([]byte, error)
+	StreamImageToStorage(image io.Reader, id string, level string) (int64, error)
+	OpenImageFromStorage(id string, level string) (io.ReadSeekCloser, file.ImageInfo, error)
+	DeleteImageFromStorage(id string) error
+	ImageExists(id string, level string) (bool, error)
 }
 
 // fileStorageService represents a service that writes and reads image data to/from a file storage.
@@ -69,3 +74,75 @@ func (f fileStorageService) ReadImageFromStorage(name string, level string) ([]b
 
 	return data, nil
 }
+
+// StreamImageToStorage writes image data from the reader to a file storage without buffering it.
+func (f *fileStorageService) StreamImageToStorage(image io.Reader, name string, level string) (int64, error) {
+	size, err := f.fileStorage.WriteImage(image, name, level)
+	if err != nil {
+		f.logger.Error("Error streaming image to storage", logger.M{
+			"error": err,
+			"name":  name,
+			"level": level,
+		})
+
+		return 0, fmt.Errorf("%w", err)
+	}
+
+	f.logger.Info("Image streamed to storage", logger.M{
+		"name":  name,
+		"level": level,
+		"size":  size,
+	})
+
+	return size, nil
+}
+
+// OpenImageFromStorage opens image data in a file storage for streaming reads.
+func (f fileStorageService) OpenImageFromStorage(name string, level string) (io.ReadSeekCloser, file.ImageInfo, error) {
+	image, info, err := f.fileStorage.OpenImage(name, level)
+	if err != nil {
+		f.logger.Error("Error opening image in storage", logger.M{
+			"error": err,
+			"name":  name,
+			"level": level,
+		})
+
+		return nil, file.ImageInfo{}, fmt.Errorf("%w", err)
+	}
+
+	return image, info, nil
+}
+
+// DeleteImageFromStorage removes all levels of the image from a file storage.
+func (f *fileStorageService) DeleteImageFromStorage(name string) error {
+	if err := f.fileStorage.DeleteImage(name); err != nil {
+		f.logger.Error("Error deleting image from storage", logger.M{
+			"error": err,
+			"name":  name,
+		})
+
+		return fmt.Errorf("%w", err)
+	}
+
+	f.logger.Info("Image deleted from storage", logger.M{
+		"name": name,
+	})
+
+	return nil
+}
+
+// ImageExists reports whether the level of the image is in a file storage.
+func (f fileStorageService) ImageExists(name string, level string) (bool, error) {
+	exists, err := f.fileStorage.Exists(name, level)
+	if err != nil {
+		f.logger.Error("Error checking image in storage", logger.M{
+			"error": err,
+			"name":  name,
+			"level": level,
+		})
+
+		return false, fmt.Errorf("%w", err)
+	}
+
+	return exists, nil
+}
@@ -0,0 +1,192 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/andrsj/go-rabbit-image/internal/domain/repositories/file"
+	"github.com/andrsj/go-rabbit-image/internal/domain/variant"
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/codec"
+	"github.com/andrsj/go-rabbit-image/internal/infrastructure/worker/compressor"
+	"github.com/andrsj/go-rabbit-image/internal/services/image/storage"
+	"github.com/andrsj/go-rabbit-image/pkg/logger"
+	"golang.org/x/sync/singleflight"
+)
+
+// ErrNotFound is returned if the original image doesn't exist (or isn't processed yet).
+var ErrNotFound = errors.New("original image not found")
+
+// Transformer interface represents a service that creates the transformed images on the fly.
+type Transformer interface {
+	Transform(id string, transform variant.Transform) (*Image, error)
+}
+
+// Image is the transformed image.
+type Image struct {
+	Data        []byte
+	ContentType string
+	// Hash identifies the image: the hash of the original with the transformation, empty if the original has none.
+	Hash string
+	// ModTime is the time of the original, the transformed image changes only with it.
+	ModTime time.Time
+}
+
+// Config holds the limits of the transformations.
+type Config struct {
+	// MaxWidth and MaxHeight limit the size of the transformed images.
+	MaxWidth  int
+	MaxHeight int
+	// Limits are the upload limits of the originals, the bigger ones aren't decoded.
+	Limits codec.Limits
+	// Concurrency is the number of transformations running at the same time.
+	Concurrency int
+}
+
+// transformService represents a service that transforms the originals and caches the results.
+type transformService struct {
+	storage    storage.FileStorage
+	cache      file.Cache
+	compressor compressor.Compressor
+
+	maxWidth  int
+	maxHeight int
+	limits    codec.Limits
+	// slots limits the transformations running at the same time, they are CPU bound
+	slots chan struct{}
+	// group runs the same transformation only once for all concurrent requests
+	group singleflight.Group
+
+	logger logger.Logger
+}
+
+var _ Transformer = (*transformService)(nil)
+
+// New creates a new instance of transformService.
+func New(
+	cfg Config,
+	storage storage.FileStorage,
+	cache file.Cache,
+	compressor compressor.Compressor,
+	logger logger.Logger,
+) *transformService {
+	return &transformService{
+		storage:    storage,
+		cache:      cache,
+		compressor: compressor,
+		maxWidth:   cfg.MaxWidth,
+		maxHeight:  cfg.MaxHeight,
+		limits:     cfg.Limits,
+		slots:      make(chan struct{}, cfg.Concurrency),
+		logger:     logger.Named("Transform service"),
+	}
+}
+
+/*
+Transform returns the transformed original image.
+
+The result is read from the cache if it's there, otherwise it's created and cached.
+The original is opened anyway, its hash identifies the result.
+The concurrent requests of the same transformation wait for the single run of it.
+*/
+func (t *transformService) Transform(id string, transform variant.Transform) (*Image, error) {
+	if err := transform.Validate(t.maxWidth, t.maxHeight); err != nil {
+		return nil, err
+	}
+
+	original, info, err := t.storage.OpenImageFromStorage(id, variant.OriginalKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, err)
+	}
+	defer original.Close()
+
+	key := transform.Key()
+
+	result := &Image{ModTime: info.ModTime}
+	if info.Hash != "" {
+		result.Hash = info.Hash + "-" + key
+	}
+
+	data, err := t.cache.GetDerived(id, key)
+	if err == nil {
+		t.logger.Debug("Transformed image is cached", logger.M{"id": id, "transform": key})
+
+		result.Data, result.ContentType = data, contentTypeOf(data)
+
+		return result, nil
+	}
+
+	if !errors.Is(err, file.ErrCacheMiss) {
+		t.logger.Warn("Can't read the cache, transforming the image", logger.M{"error": err, "id": id})
+	}
+
+	// The original of the request that runs the transformation is read
+	encoded, err, shared := t.group.Do(id+"/"+key, func() (interface{}, error) {
+		return t.transform(id, original, transform)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if shared {
+		t.logger.Debug("Transformed image is shared with a concurrent request", logger.M{"id": id, "transform": key})
+	}
+
+	transformed := encoded.(*Image)
+	result.Data, result.ContentType = transformed.Data, transformed.ContentType
+
+	return result, nil
+}
+
+// contentTypeOf returns the content type of the cached image by the codec that encoded it.
+func contentTypeOf(data []byte) string {
+	format, err := codec.Detect(data)
+	if err != nil {
+		return "application/octet-stream"
+	}
+
+	return format.ContentType
+}
+
+/*
+transform creates the transformed image and stores it in the cache.
+
+The original is checked against the upload limits by its header before decoding,
+e.g. if it was uploaded without the known dimensions or the limits were lowered since,
+and the size of the result is checked before resizing.
+*/
+func (t *transformService) transform(id string, original io.ReadSeeker, transform variant.Transform) (*Image, error) {
+	t.slots <- struct{}{}
+	defer func() { <-t.slots }()
+
+	if err := t.limits.CheckStream(original); err != nil {
+		return nil, fmt.Errorf("check original: %w", err)
+	}
+
+	img, contentType, err := compressor.DecodeImageFrom(original)
+	if err != nil {
+		return nil, fmt.Errorf("decode original: %w", err)
+	}
+
+	// The size is checked with the decoded image, it's rotated by the orientation
+	bounds := img.Bounds()
+	if err := transform.CheckSize(bounds.Dx(), bounds.Dy(), t.maxWidth, t.maxHeight); err != nil {
+		return nil, err
+	}
+
+	profile := transform.Profile()
+	contentType = profile.ContentType(contentType)
+
+	data, err := t.compressor.EncodeImage(t.compressor.TransformImage(img, transform), contentType, profile)
+	if err != nil {
+		return nil, fmt.Errorf("encode: %w", err)
+	}
+
+	// The image is returned even if it can't be cached
+	if err := t.cache.PutDerived(data, id, transform.Key()); err != nil {
+		t.logger.Error("Can't cache the transformed image", logger.M{"error": err, "id": id})
+	}
+
+	return &Image{Data: data, ContentType: contentType}, nil
+}
@@ -0,0 +1,76 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// publishedAtHeader keeps the time (Unix nanoseconds) the message was put in the queue.
+const publishedAtHeader = "x-published-at"
+
+// headersCarrier adapts the headers of the messages (AMQP table) to the propagator.
+type headersCarrier map[string]interface{}
+
+func (c headersCarrier) Get(key string) string {
+	value, _ := c[key].(string)
+
+	return value
+}
+
+func (c headersCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c headersCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// Inject writes the trace context of the ctx and the time of the publishing to the message headers,
+// it's called on every publishing, so the retried messages measure their own wait.
+func Inject(ctx context.Context, headers map[string]interface{}) {
+	// The messages that weren't consumed have no context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	otel.GetTextMapPropagator().Inject(ctx, headersCarrier(headers))
+
+	headers[publishedAtHeader] = time.Now().UnixNano()
+}
+
+// Extract returns the context with the trace context of the message headers.
+func Extract(headers map[string]interface{}) context.Context {
+	return otel.GetTextMapPropagator().Extract(context.Background(), headersCarrier(headers))
+}
+
+// RecordQueueWait records the span of the time the message waited in the queue,
+// from the publishing till now. Nothing is recorded for the messages without the publishing time.
+func RecordQueueWait(ctx context.Context, headers map[string]interface{}, queue string) {
+	var publishedAt int64
+
+	// The integers of the AMQP tables are decoded by their size
+	switch value := headers[publishedAtHeader].(type) {
+	case int64:
+		publishedAt = value
+	case int32:
+		publishedAt = int64(value)
+	default:
+		return
+	}
+
+	_, span := Tracer().Start(ctx, "queue wait",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithTimestamp(time.Unix(0, publishedAt)),
+		trace.WithAttributes(semconv.MessagingSourceName(queue)),
+	)
+	span.End()
+}
@@ -0,0 +1,112 @@
+/*
+Package tracing sets up the OpenTelemetry tracing and carries the trace context through the message headers.
+
+An upload is one trace: the HTTP request, the publishing, the wait in the queue,
+the processing of the image by the worker and every variant.
+*/
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The exporters of the spans.
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// instrumentationName is the name of the tracer of the application.
+const instrumentationName = "github.com/andrsj/go-rabbit-image"
+
+var errUnknownExporter = errors.New("unknown exporter")
+
+// Config holds the settings of the tracing.
+type Config struct {
+	// Exporter is none, stdout or otlp (gRPC).
+	Exporter string
+	// Endpoint is the host:port of the OTLP collector.
+	Endpoint string
+	// Insecure disables TLS of the OTLP connection, e.g. for a local collector.
+	Insecure bool
+	// SamplePercent is the share of the new traces that are recorded,
+	// the traces started by the callers follow their decision.
+	SamplePercent int
+	// ServiceName is the name of the service in the traces.
+	ServiceName string
+}
+
+/*
+Setup sets the global tracer provider and the propagator of the W3C trace context,
+it returns the function that flushes the spans on shutdown.
+
+Without the exporter no spans are recorded, the trace context of the callers is still propagated.
+*/
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+
+	switch cfg.Exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New()
+	case ExporterOTLP:
+		options := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			options = append(options, otlptracegrpc.WithInsecure())
+		}
+
+		exporter, err = otlptracegrpc.New(ctx, options...)
+	default:
+		return nil, fmt.Errorf("%w: '%s'", errUnknownExporter, cfg.Exporter)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("create %s exporter: %w", cfg.Exporter, err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(float64(cfg.SamplePercent)/100))),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(cfg.ServiceName),
+		)),
+	)
+
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer of the application, it's a no-op one until Setup is called with an exporter.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Fail records the error in the span and marks the span as failed.
+func Fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}